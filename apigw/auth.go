@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/LeeZXin/zsf/rpcheader"
 	"github.com/LeeZXin/zsf/services/discovery"
+	"github.com/LeeZXin/zsf/services/lb"
 	"github.com/spf13/cast"
 	"net/http"
 	"strings"
@@ -82,17 +84,21 @@ func (t *httpExecutor) defaultAuth(c *ApiContext) bool {
 		path = "/" + path
 	}
 	authReqJson, authReqHeader := getAuthRequestBody(c, config)
-	var url string
+	var (
+		url    string
+		server *lb.Server
+	)
 	switch config.UriType {
 	case HttpUriType:
 		url = config.Uri.Address + path
 	case DiscoveryUriType:
-		server, err := discovery.ChooseServer(c, config.Uri.DiscoveryTarget)
+		chosen, err := discovery.ChooseServer(c, config.Uri.DiscoveryTarget)
 		if err != nil {
 			c.String(config.ErrorStatusCode, config.ErrorMessage)
 			return false
 		}
-		url = "http://" + fmt.Sprintf("%s:%d", server.Host, server.Port) + path
+		server = &chosen
+		url = "http://" + fmt.Sprintf("%s:%d", chosen.Host, chosen.Port) + path
 	}
 	reqBody, _ := json.Marshal(authReqJson)
 	var (
@@ -116,8 +122,19 @@ func (t *httpExecutor) defaultAuth(c *ApiContext) bool {
 	for k, v := range authReqHeader {
 		authReq.Header.Set(k, v)
 	}
+	rpcheader.InjectSpanContext(c.Request.Context(), authReq.Header.Set)
 	authReq.Header.Set(ContentTypeTag, JsonContentType)
 	resp, err := t.httpClient.Do(authReq)
+	if server != nil {
+		if err != nil {
+			// 客户端取消请求不是实例故障
+			if c.Request.Context().Err() != context.Canceled {
+				lb.DefaultOutlierDetector().ReportFailure(*server)
+			}
+		} else {
+			lb.DefaultOutlierDetector().Report(*server, resp.StatusCode >= http.StatusInternalServerError)
+		}
+	}
 	if err != nil {
 		c.String(config.ErrorStatusCode, config.ErrorMessage)
 		return false
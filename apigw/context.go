@@ -1,6 +1,8 @@
 package apigw
 
 import (
+	"context"
+	"github.com/LeeZXin/zsf/services/lb"
 	"github.com/gin-gonic/gin"
 	"net/http"
 )
@@ -11,6 +13,8 @@ type ApiContext struct {
 	config  RouterConfig
 	header  http.Header
 	url     string
+	// server 服务发现选择的实例
+	server *lb.Server
 }
 
 func (c *ApiContext) ReqBody() []byte {
@@ -28,3 +32,17 @@ func (c *ApiContext) Config() RouterConfig {
 func (c *ApiContext) Url() string {
 	return c.url
 }
+
+// reportOutlier 上报服务发现实例请求结果
+func (c *ApiContext) reportOutlier(resp *http.Response, err error) {
+	if c.server == nil {
+		return
+	}
+	if err != nil {
+		if c.Request.Context().Err() != context.Canceled {
+			lb.DefaultOutlierDetector().ReportFailure(*c.server)
+		}
+		return
+	}
+	lb.DefaultOutlierDetector().Report(*c.server, resp.StatusCode >= http.StatusInternalServerError)
+}
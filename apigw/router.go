@@ -6,13 +6,20 @@ import (
 	"github.com/LeeZXin/zsf-utils/httputil"
 	"github.com/LeeZXin/zsf-utils/trieutil"
 	"github.com/LeeZXin/zsf/apigw/hexpr"
+	"github.com/LeeZXin/zsf/http/openapi"
 	"github.com/LeeZXin/zsf/services/discovery"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"sync"
 )
 
 type LbPolicy string
 
+const (
+	// OpenApiMockTag mock路由文档tag
+	OpenApiMockTag = "apigwMock"
+)
+
 const (
 	RoundRobinPolicy         LbPolicy = "round_robin"
 	WeightedRoundRobinPolicy LbPolicy = "weighted_round_robin"
@@ -190,6 +197,9 @@ type routersImpl struct {
 	httpClient *http.Client
 	//服务发现
 	discovery discovery.Discovery
+	//mock路由 用于生成openapi文档
+	mockConfigs []RouterConfig
+	mmu         sync.RWMutex
 }
 
 type routerOpts struct {
@@ -220,9 +230,54 @@ func NewRouters(opts ...RouterOpt) Routers {
 	if httpClient == nil {
 		httpClient = httputil.NewHttpClient()
 	}
-	return &routersImpl{
+	r := &routersImpl{
 		httpClient: httpClient,
 	}
+	registerMockDocs(r)
+	return r
+}
+
+var (
+	// unregisterMockDocs 注销上一个Routers的mock路由文档
+	unregisterMockDocs func()
+	docsMu             sync.Mutex
+)
+
+// registerMockDocs 只生成最新创建的Routers的mock路由文档 替换Routers时注销旧的文档
+func registerMockDocs(r *routersImpl) {
+	docsMu.Lock()
+	defer docsMu.Unlock()
+	if unregisterMockDocs != nil {
+		unregisterMockDocs()
+	}
+	unregisterMockDocs = openapi.RegisterProvider(r.mockRouteDocs)
+}
+
+// mockRouteDocs mock路由文档 表达式匹配没有固定路径 不生成文档
+func (r *routersImpl) mockRouteDocs() []openapi.Route {
+	r.mmu.RLock()
+	defer r.mmu.RUnlock()
+	ret := make([]openapi.Route, 0, len(r.mockConfigs))
+	for _, config := range r.mockConfigs {
+		if config.MatchType == ExprMatchType {
+			continue
+		}
+		contentType := TextContentType
+		if config.MockContent.ContentType == MockJsonType {
+			contentType = JsonContentType
+		}
+		ret = append(ret, openapi.Route{
+			Method:              openapi.MethodAny,
+			Path:                config.Path,
+			Summary:             "apigw mock: " + config.Id,
+			Description:         string(config.MatchType),
+			Tags:                []string{OpenApiMockTag},
+			ResponseStatus:      config.MockContent.StatusCode,
+			ResponseContentType: contentType,
+			ResponseExample:     config.MockContent.RespStr,
+		})
+	}
+	return ret
 }
 
 func (r *routersImpl) putFullMatchTransport(path string, transport Transport) {
@@ -318,5 +373,10 @@ func (r *routersImpl) AddRouter(config RouterConfig) error {
 	case ExprMatchType:
 		err = exprMatchTransport(r, config, transport)
 	}
+	if err == nil && config.TargetType == MockTargetType {
+		r.mmu.Lock()
+		r.mockConfigs = append(r.mockConfigs, config)
+		r.mmu.Unlock()
+	}
 	return err
 }
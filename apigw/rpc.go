@@ -2,7 +2,11 @@ package apigw
 
 import (
 	"bytes"
+	"fmt"
+	"github.com/LeeZXin/zsf/rpcheader"
+	"github.com/LeeZXin/zsf/tracing"
 	"net/http"
+	"strconv"
 )
 
 // rpcExecutor 请求转发执行器
@@ -56,12 +60,27 @@ func (t *httpExecutor) Handle(c *ApiContext) {
 	for k := range c.header {
 		newReq.Header.Set(k, c.header.Get(k))
 	}
+	ctx, span := tracing.StartSpan(c.Request.Context(), c.Request.Method+" "+c.config.Path, tracing.ClientKind)
+	defer span.End()
+	span.SetAttribute("http.method", c.Request.Method)
+	span.SetAttribute("http.url", newReq.URL.Path)
+	span.SetAttribute("server.address", newReq.URL.Host)
+	if c.config.ServiceName != "" {
+		span.SetAttribute("peer.service", c.config.ServiceName)
+	}
+	rpcheader.InjectSpanContext(ctx, newReq.Header.Set)
 	newReq.Header.Set("User-Agent", "")
 	resp, err := t.httpClient.Do(newReq)
+	c.reportOutlier(resp, err)
 	if err != nil {
+		span.SetError(err)
 		c.String(http.StatusInternalServerError, "")
 		return
 	}
+	span.SetAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetError(fmt.Errorf("response code: %d", resp.StatusCode))
+	}
 	defer resp.Body.Close()
 	for k := range resp.Header {
 		c.Header(k, resp.Header.Get(k))
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/LeeZXin/zsf/services/discovery"
+	"github.com/LeeZXin/zsf/services/lb"
 	"sync"
 	"sync/atomic"
 )
@@ -13,18 +14,27 @@ type hostSelector interface {
 	Select(context.Context) (string, error)
 }
 
+// serverSelector 服务发现选择实例 用于上报实例请求结果
+type serverSelector interface {
+	SelectServer(context.Context) (lb.Server, error)
+}
+
 type ipPortSelector struct {
 	serviceName string
 }
 
 func (s *ipPortSelector) Select(ctx context.Context) (string, error) {
-	server, err := discovery.ChooseServer(ctx, s.serviceName)
+	server, err := s.SelectServer(ctx)
 	if err != nil {
 		return "", err
 	}
 	return fmt.Sprintf("%s:%d", server.Host, server.Port), nil
 }
 
+func (s *ipPortSelector) SelectServer(ctx context.Context) (lb.Server, error) {
+	return discovery.ChooseServer(ctx, s.serviceName)
+}
+
 type nilSelector struct{}
 
 func (s *nilSelector) Select(context.Context) (string, error) {
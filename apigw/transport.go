@@ -3,6 +3,7 @@ package apigw
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"github.com/LeeZXin/zsf/apigw/hexpr"
 	"github.com/gin-gonic/gin"
 	"io"
@@ -36,17 +37,29 @@ func (t *transportImpl) Handle(c *gin.Context) {
 	if t.rewriteStrategy != nil {
 		path = t.rewriteStrategy.Rewrite(path)
 	}
-	host, err := t.targetSelector.Select(c.Request.Context())
-	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
-		return
-	}
 	ctx := &ApiContext{
 		Context: c,
 		reqBody: body,
 		config:  t.config,
 		header:  make(http.Header),
 	}
+	var host string
+	if selector, ok := t.targetSelector.(serverSelector); ok {
+		server, err := selector.SelectServer(c.Request.Context())
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		host = fmt.Sprintf("%s:%d", server.Host, server.Port)
+		ctx.server = &server
+	} else {
+		var err error
+		host, err = t.targetSelector.Select(c.Request.Context())
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
 	if host != "" {
 		if !strings.HasPrefix(host, "http://") {
 			host = "http://" + host
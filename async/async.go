@@ -0,0 +1,128 @@
+package async
+
+import (
+	"context"
+	"fmt"
+	"github.com/LeeZXin/zsf-utils/threadutil"
+	"github.com/LeeZXin/zsf/logger"
+	"github.com/LeeZXin/zsf/prom"
+	"sync/atomic"
+	"time"
+)
+
+// 携带上下文的异步执行
+// 新协程的ctx保留调用方ctx中的值 包括调用链、MDC、rpcheader和租户信息 但不跟随调用方取消
+// panic会被recover并记录日志和监控
+
+const (
+	defaultName = "default"
+)
+
+var (
+	inFlight atomic.Int64
+)
+
+// detachedCtx 保留值但不继承取消和超时
+type detachedCtx struct {
+	parent context.Context
+}
+
+func (detachedCtx) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedCtx) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedCtx) Err() error {
+	return nil
+}
+
+func (c detachedCtx) Value(key any) any {
+	return c.parent.Value(key)
+}
+
+func (c detachedCtx) String() string {
+	return fmt.Sprintf("%v.Detached", c.parent)
+}
+
+// Detach 返回不会被取消的ctx 保留原ctx中的值
+func Detach(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return detachedCtx{parent: ctx}
+}
+
+type option struct {
+	name    string
+	timeout time.Duration
+}
+
+type Option func(*option)
+
+// WithName 任务名称 用于日志和监控
+func WithName(name string) Option {
+	return func(o *option) {
+		o.name = name
+	}
+}
+
+// WithTimeout 异步任务的超时时间 从任务开始执行时计算
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *option) {
+		o.timeout = timeout
+	}
+}
+
+func newOption(opts []Option) *option {
+	o := &option{
+		name: defaultName,
+	}
+	for _, apply := range opts {
+		apply(o)
+	}
+	return o
+}
+
+// InFlight 正在执行的异步任务数
+func InFlight() int64 {
+	return inFlight.Load()
+}
+
+// Go 异步执行fn
+func Go(ctx context.Context, fn func(context.Context), opts ...Option) {
+	o := newOption(opts)
+	ctx = Detach(ctx)
+	start(o.name)
+	go func() {
+		defer finish(o.name)
+		run(ctx, fn, o)
+	}()
+}
+
+func start(name string) {
+	inFlight.Add(1)
+	prom.AsyncInFlight.WithLabelValues(name).Inc()
+}
+
+func finish(name string) {
+	inFlight.Add(-1)
+	prom.AsyncInFlight.WithLabelValues(name).Dec()
+}
+
+// run 执行并recover panic
+func run(ctx context.Context, fn func(context.Context), o *option) {
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+	if err := threadutil.RunSafe(func() {
+		fn(ctx)
+	}); err != nil {
+		prom.AsyncPanicTotal.WithLabelValues(o.name).Inc()
+		logger.Logger.WithContext(ctx).Errorf("async: %s panic: %v", o.name, err)
+	}
+}
@@ -0,0 +1,111 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"github.com/LeeZXin/zsf/logger"
+	"github.com/LeeZXin/zsf/logger/loggertest"
+	"github.com/LeeZXin/zsf/rpcheader"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGo(t *testing.T) {
+	loggertest.NewHook(t)
+	ctx := logger.AppendToMDC(context.Background(), map[string]string{logger.TraceId: "abc"})
+	ctx = rpcheader.AppendToHeaders(ctx, map[string]string{rpcheader.Source: "test"})
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	done := make(chan context.Context, 1)
+	Go(ctx, func(ctx context.Context) {
+		done <- ctx
+	})
+	// panic不影响后续执行
+	Go(ctx, func(context.Context) {
+		panic("boom")
+	})
+	got := <-done
+	if got.Err() != nil {
+		t.Fatal("expected detached ctx not cancelled")
+	}
+	if logger.GetTraceId(got) != "abc" || rpcheader.GetHeaders(got).Get(rpcheader.Source) != "test" {
+		t.Fatal("expected values kept")
+	}
+	for InFlight() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestExecutor(t *testing.T) {
+	e := NewExecutor(1, 1)
+	block := make(chan struct{})
+	var count atomic.Int32
+	fn := func(context.Context) {
+		<-block
+		count.Add(1)
+	}
+	if err := e.Submit(context.Background(), fn); err != nil {
+		t.Fatal(err)
+	}
+	// 等待worker取走第一个任务
+	for len(e.queue) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := e.Submit(context.Background(), fn); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Submit(context.Background(), fn); err != ErrExecutorFull {
+		t.Fatalf("expected ErrExecutorFull, got %v", err)
+	}
+	close(block)
+	e.Shutdown()
+	if count.Load() != 2 {
+		t.Fatalf("expected 2 tasks, got %d", count.Load())
+	}
+	if err := e.Submit(context.Background(), fn); err != ErrExecutorClosed {
+		t.Fatalf("expected ErrExecutorClosed, got %v", err)
+	}
+}
+
+func TestMap(t *testing.T) {
+	loggertest.NewHook(t)
+	var (
+		mu      sync.Mutex
+		current int
+		maxSeen int
+	)
+	ret, err := Map(context.Background(), []int{1, 2, 3, 4, 5}, 2, func(_ context.Context, i int) (int, error) {
+		mu.Lock()
+		current++
+		if current > maxSeen {
+			maxSeen = current
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		current--
+		mu.Unlock()
+		return i * i, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxSeen > 2 || ret[4] != 25 || ret[0] != 1 {
+		t.Fatalf("unexpected result: %v, max concurrent: %d", ret, maxSeen)
+	}
+	expected := errors.New("failed")
+	err = All(context.Background(), func(ctx context.Context) error {
+		return expected
+	}, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, func(ctx context.Context) error {
+		panic("boom")
+	})
+	if err == nil {
+		t.Fatal("expected err")
+	}
+}
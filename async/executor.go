@@ -0,0 +1,83 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// 有界执行器
+// 固定数量的协程消费有界队列 队列满时Submit立即返回ErrExecutorFull
+
+var (
+	ErrExecutorFull   = errors.New("executor queue is full")
+	ErrExecutorClosed = errors.New("executor is closed")
+)
+
+type task struct {
+	ctx context.Context
+	fn  func(context.Context)
+}
+
+type Executor struct {
+	opt    *option
+	queue  chan task
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewExecutor workers为并发数 queueSize为排队数
+func NewExecutor(workers, queueSize int, opts ...Option) *Executor {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	ret := &Executor{
+		opt:   newOption(opts),
+		queue: make(chan task, queueSize),
+	}
+	ret.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go ret.work()
+	}
+	return ret
+}
+
+func (e *Executor) work() {
+	defer e.wg.Done()
+	for t := range e.queue {
+		run(t.ctx, t.fn, e.opt)
+		finish(e.opt.name)
+	}
+}
+
+// Submit 提交任务 没有空闲协程且队列已满时返回ErrExecutorFull
+func (e *Executor) Submit(ctx context.Context, fn func(context.Context)) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return ErrExecutorClosed
+	}
+	start(e.opt.name)
+	select {
+	case e.queue <- task{ctx: Detach(ctx), fn: fn}:
+		return nil
+	default:
+		finish(e.opt.name)
+		return ErrExecutorFull
+	}
+}
+
+// Shutdown 不再接收任务 等待已提交的任务执行完成
+func (e *Executor) Shutdown() {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.mu.Unlock()
+	e.wg.Wait()
+}
@@ -0,0 +1,88 @@
+package async
+
+import (
+	"context"
+	"fmt"
+	"github.com/LeeZXin/zsf-utils/threadutil"
+	"github.com/LeeZXin/zsf/logger"
+	"github.com/LeeZXin/zsf/prom"
+	"sync"
+)
+
+// 并发执行并汇总结果
+// 与Go不同 子任务跟随调用方ctx取消 任意任务失败时取消其余任务 返回第一个错误
+// panic转为错误返回
+
+// All 并发执行所有fn 等待全部完成
+func All(ctx context.Context, fns ...func(context.Context) error) error {
+	_, err := Map(ctx, fns, 0, func(ctx context.Context, fn func(context.Context) error) (struct{}, error) {
+		return struct{}{}, fn(ctx)
+	})
+	return err
+}
+
+// Map 并发处理items 结果顺序与items一致 limit为最大并发数 小于等于0不限制
+func Map[T, R any](ctx context.Context, items []T, limit int, fn func(context.Context, T) (R, error), opts ...Option) ([]R, error) {
+	o := newOption(opts)
+	ret := make([]R, len(items))
+	if len(items) == 0 {
+		return ret, nil
+	}
+	if limit <= 0 || limit > len(items) {
+		limit = len(items)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		indexes  = make(chan int)
+	)
+	setErr := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	wg.Add(limit)
+	for i := 0; i < limit; i++ {
+		start(o.name)
+		go func() {
+			defer wg.Done()
+			defer finish(o.name)
+			for index := range indexes {
+				var (
+					result R
+					err    error
+				)
+				if fatal := threadutil.RunSafe(func() {
+					result, err = fn(ctx, items[index])
+				}); fatal != nil {
+					prom.AsyncPanicTotal.WithLabelValues(o.name).Inc()
+					logger.Logger.WithContext(ctx).Errorf("async: %s panic: %v", o.name, fatal)
+					err = fmt.Errorf("async: %s panic: %w", o.name, fatal)
+				}
+				if err != nil {
+					setErr(err)
+					continue
+				}
+				ret[index] = result
+			}
+		}()
+	}
+loop:
+	for i := range items {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if firstErr != nil {
+		return ret, firstErr
+	}
+	return ret, ctx.Err()
+}
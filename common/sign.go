@@ -4,7 +4,16 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	AuthSignV1 = "v1"
+	AuthSignV2 = "v2"
 )
 
 func GenAuthSign(secret string, timestamp int64) (string, error) {
@@ -19,3 +28,73 @@ func GenAuthSign(secret string, timestamp int64) (string, error) {
 	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
 	return signature, nil
 }
+
+// AuthSignV2Content v2签名内容
+type AuthSignV2Content struct {
+	KeyId string
+	// Source 调用方 密钥按source和keyId获取
+	Source    string
+	Method    string
+	Path      string
+	RawQuery  string
+	BodyHash  string
+	Timestamp int64
+	Nonce     string
+}
+
+// GenAuthSignV2 对keyId、source、method、path、query、body摘要、时间戳和nonce做hmac-sha256, 再进行base64 encode
+func GenAuthSignV2(secret string, content AuthSignV2Content) (string, error) {
+	stringToSign := strings.Join([]string{
+		AuthSignV2,
+		content.KeyId,
+		content.Source,
+		strings.ToUpper(content.Method),
+		content.Path,
+		CanonicalQuery(content.RawQuery),
+		content.BodyHash,
+		fmt.Sprintf("%v", content.Timestamp),
+		content.Nonce,
+	}, "\n")
+	h := hmac.New(sha256.New, []byte(secret))
+	_, err := h.Write([]byte(stringToSign))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashAuthBody body sha256摘要 hex格式
+func HashAuthBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// CanonicalQuery 按key、value排序后的query
+func CanonicalQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for _, k := range keys {
+		vs := values[k]
+		sort.Strings(vs)
+		for _, v := range vs {
+			if sb.Len() > 0 {
+				sb.WriteByte('&')
+			}
+			sb.WriteString(url.QueryEscape(k))
+			sb.WriteByte('=')
+			sb.WriteString(url.QueryEscape(v))
+		}
+	}
+	return sb.String()
+}
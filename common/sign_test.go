@@ -0,0 +1,37 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestCanonicalQuery(t *testing.T) {
+	if q := CanonicalQuery("b=2&a=3&a=1&c="); q != "a=1&a=3&b=2&c=" {
+		t.Fatalf("unexpected canonical query: %s", q)
+	}
+}
+
+func TestGenAuthSignV2(t *testing.T) {
+	content := AuthSignV2Content{
+		KeyId:     "k1",
+		Method:    "post",
+		Path:      "/api/v1/user",
+		RawQuery:  "b=1&a=2",
+		BodyHash:  HashAuthBody([]byte(`{"name":"zsf"}`)),
+		Timestamp: 1700000000,
+		Nonce:     "nonce",
+	}
+	s1, err := GenAuthSignV2("secret", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content.RawQuery = "a=2&b=1"
+	s2, _ := GenAuthSignV2("secret", content)
+	if s1 != s2 {
+		t.Fatal("query order should not change sign")
+	}
+	content.Path = "/api/v1/admin"
+	s3, _ := GenAuthSignV2("secret", content)
+	if s1 == s3 {
+		t.Fatal("sign should cover path")
+	}
+}
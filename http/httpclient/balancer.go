@@ -0,0 +1,86 @@
+package httpclient
+
+import (
+	"context"
+	"github.com/LeeZXin/zsf/services/discovery"
+	"github.com/LeeZXin/zsf/services/lb"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 服务级别负载均衡策略
+// 定时从服务发现拉取实例列表 使用独立的负载均衡器选择实例
+
+const (
+	balancerRefreshInterval = 10 * time.Second
+)
+
+type policyBalancer struct {
+	policy    lb.Policy
+	zone      string
+	balancer  *lb.NearbyLoadBalancer
+	updatedAt atomic.Int64
+	mu        sync.Mutex
+}
+
+func newPolicyBalancer(policy lb.Policy, zone string) *policyBalancer {
+	return &policyBalancer{
+		policy: policy,
+		zone:   zone,
+		balancer: &lb.NearbyLoadBalancer{
+			LbPolicy: policy,
+		},
+	}
+}
+
+func (b *policyBalancer) refresh(ctx context.Context, dis discovery.Discovery, serviceName string) {
+	if time.Now().UnixMilli()-b.updatedAt.Load() < balancerRefreshInterval.Milliseconds() {
+		return
+	}
+	// 已有协程在刷新时使用旧的实例列表
+	if !b.mu.TryLock() {
+		return
+	}
+	defer b.mu.Unlock()
+	var (
+		servers []lb.Server
+		err     error
+	)
+	if b.zone == "" {
+		servers, err = dis.Discover(ctx, serviceName)
+	} else {
+		servers, err = dis.DiscoverWithZone(ctx, b.zone, serviceName)
+	}
+	if err != nil {
+		return
+	}
+	b.balancer.SetServers(servers)
+	b.updatedAt.Store(time.Now().UnixMilli())
+}
+
+func (b *policyBalancer) ChooseServer(ctx context.Context, dis discovery.Discovery, serviceName string) (lb.Server, error) {
+	b.refresh(ctx, dis, serviceName)
+	return b.balancer.ChooseServer(ctx)
+}
+
+// getBalancer 获取负载均衡策略对应的负载均衡器
+func (c *clientImpl) getBalancer(policy lb.Policy, zone string) *policyBalancer {
+	key := string(policy) + "#" + zone
+	if ret, b := c.balancers.Load(key); b {
+		return ret.(*policyBalancer)
+	}
+	ret, _ := c.balancers.LoadOrStore(key, newPolicyBalancer(policy, zone))
+	return ret.(*policyBalancer)
+}
+
+// getHostsDiscovery 获取静态实例列表对应的服务发现
+func (c *clientImpl) getHostsDiscovery(hosts []string, policy lb.Policy) discovery.Discovery {
+	key := string(policy) + "#" + strings.Join(hosts, ",")
+	if ret, b := c.hostsDiscoveries.Load(key); b {
+		return ret.(discovery.Discovery)
+	}
+	ret, _ := c.hostsDiscoveries.LoadOrStore(key, discovery.NewHostsDiscovery(c.ServiceName, hosts, policy))
+	return ret.(discovery.Discovery)
+}
@@ -0,0 +1,210 @@
+package httpclient
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 表单请求体 作为Post、Put的请求参数
+// FormBody application/x-www-form-urlencoded 可重试
+// MultipartBody multipart/form-data 边读边写不缓存文件内容 不可重试
+
+type FormBody struct {
+	values url.Values
+}
+
+func NewFormBody() *FormBody {
+	return &FormBody{
+		values: make(url.Values),
+	}
+}
+
+func (b *FormBody) Add(key, value string) *FormBody {
+	b.values.Add(key, value)
+	return b
+}
+
+func (b *FormBody) Set(key, value string) *FormBody {
+	b.values.Set(key, value)
+	return b
+}
+
+func (b *FormBody) ContentType() string {
+	return FormContentType
+}
+
+func (b *FormBody) Bytes() []byte {
+	return []byte(b.values.Encode())
+}
+
+type multipartPart struct {
+	field       string
+	value       string
+	filename    string
+	contentType string
+	reader      io.Reader
+	path        string
+}
+
+type MultipartBody struct {
+	parts    []multipartPart
+	boundary string
+	progress func(int64)
+}
+
+func NewMultipartBody() *MultipartBody {
+	return &MultipartBody{
+		boundary: multipart.NewWriter(io.Discard).Boundary(),
+	}
+}
+
+// AddField 添加表单字段
+func (b *MultipartBody) AddField(field, value string) *MultipartBody {
+	b.parts = append(b.parts, multipartPart{
+		field: field,
+		value: value,
+	})
+	return b
+}
+
+// AddFile 添加文件 reader由调用方关闭
+func (b *MultipartBody) AddFile(field, filename string, reader io.Reader) *MultipartBody {
+	return b.AddFileWithContentType(field, filename, "application/octet-stream", reader)
+}
+
+func (b *MultipartBody) AddFileWithContentType(field, filename, contentType string, reader io.Reader) *MultipartBody {
+	b.parts = append(b.parts, multipartPart{
+		field:       field,
+		filename:    filename,
+		contentType: contentType,
+		reader:      reader,
+	})
+	return b
+}
+
+// AddFilePath 添加本地文件 发送时打开
+func (b *MultipartBody) AddFilePath(field, path string) *MultipartBody {
+	b.parts = append(b.parts, multipartPart{
+		field:       field,
+		filename:    filepath.Base(path),
+		contentType: "application/octet-stream",
+		path:        path,
+	})
+	return b
+}
+
+// OnProgress 发送进度回调 参数为已发送字节数
+func (b *MultipartBody) OnProgress(fn func(written int64)) *MultipartBody {
+	b.progress = fn
+	return b
+}
+
+func (b *MultipartBody) ContentType() string {
+	return "multipart/form-data; boundary=" + b.boundary
+}
+
+// Reader 首次读取时开始写入 未读取时不占用协程
+func (b *MultipartBody) Reader() io.ReadCloser {
+	return &multipartReader{
+		body: b,
+	}
+}
+
+func (b *MultipartBody) writeTo(pw *io.PipeWriter) {
+	writer := multipart.NewWriter(pw)
+	if err := writer.SetBoundary(b.boundary); err != nil {
+		pw.CloseWithError(err)
+		return
+	}
+	for _, part := range b.parts {
+		if err := writePart(writer, part); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+	}
+	pw.CloseWithError(writer.Close())
+}
+
+func writePart(writer *multipart.Writer, part multipartPart) error {
+	if part.filename == "" {
+		return writer.WriteField(part.field, part.value)
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		escapeQuotes(part.field), escapeQuotes(part.filename)))
+	header.Set("Content-Type", part.contentType)
+	w, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	reader := part.reader
+	if part.path != "" {
+		file, err := os.Open(part.path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		reader = file
+	}
+	if reader == nil {
+		return nil
+	}
+	_, err = io.Copy(w, reader)
+	return err
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+type multipartReader struct {
+	body   *MultipartBody
+	once   sync.Once
+	reader *io.PipeReader
+}
+
+func (r *multipartReader) Read(p []byte) (int, error) {
+	r.once.Do(func() {
+		pr, pw := io.Pipe()
+		r.reader = pr
+		go r.body.writeTo(pw)
+	})
+	if r.reader == nil {
+		return 0, io.ErrClosedPipe
+	}
+	return r.reader.Read(p)
+}
+
+// Close 未读取时关闭不再启动写入
+func (r *multipartReader) Close() error {
+	r.once.Do(func() {})
+	if r.reader == nil {
+		return nil
+	}
+	return r.reader.Close()
+}
+
+// progressBody 统计已发送字节数
+type progressBody struct {
+	io.ReadCloser
+	written  int64
+	progress func(int64)
+}
+
+func (b *progressBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.written += int64(n)
+		b.progress(b.written)
+	}
+	return n, err
+}
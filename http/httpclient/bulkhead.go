@@ -0,0 +1,138 @@
+package httpclient
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"github.com/LeeZXin/zsf/prom"
+	"sync"
+	"time"
+)
+
+// 舱壁隔离
+// 限制每个目标服务的并发请求数 超过时排队等待 队列已满或等待超时立即返回错误
+// 并发数从发送请求开始计算 到响应体关闭为止 包含重试
+
+const (
+	bulkheadFullReason    = "full"
+	bulkheadTimeoutReason = "timeout"
+)
+
+var (
+	ErrBulkheadFull    = errors.New("bulkhead is full")
+	ErrBulkheadTimeout = errors.New("bulkhead wait timeout")
+)
+
+type BulkheadPolicy struct {
+	// MaxConcurrent 最大并发请求数 小于等于0不限制
+	MaxConcurrent int
+	// MaxQueue 最大排队数 为0时超过并发数立即拒绝
+	MaxQueue int
+	// QueueTimeout 排队超时时间 为0时等待到ctx结束
+	QueueTimeout time.Duration
+}
+
+// bulkhead 服务级别并发控制 策略可动态变化
+type bulkhead struct {
+	mu     sync.Mutex
+	active int
+	// limit 最近一次请求的最大并发数
+	limit   int
+	waiters list.List
+}
+
+// acquire 获取并发许可 返回释放函数
+func (b *bulkhead) acquire(ctx context.Context, target string, policy *BulkheadPolicy) (func(), error) {
+	if policy == nil || policy.MaxConcurrent <= 0 {
+		return func() {}, nil
+	}
+	b.mu.Lock()
+	// 并发数调大时唤醒排队请求
+	if policy.MaxConcurrent > b.limit {
+		b.wake(policy.MaxConcurrent)
+		b.report(target)
+	}
+	b.limit = policy.MaxConcurrent
+	if b.active < policy.MaxConcurrent && b.waiters.Len() == 0 {
+		b.active++
+		b.report(target)
+		b.mu.Unlock()
+		return b.releaseFunc(target), nil
+	}
+	if b.waiters.Len() >= policy.MaxQueue {
+		b.mu.Unlock()
+		prom.HttpClientBulkheadRejectedTotal.WithLabelValues(target, bulkheadFullReason).Inc()
+		return nil, ErrBulkheadFull
+	}
+	ready := make(chan struct{})
+	elem := b.waiters.PushBack(ready)
+	b.report(target)
+	b.mu.Unlock()
+	var timeoutC <-chan time.Time
+	if policy.QueueTimeout > 0 {
+		timer := time.NewTimer(policy.QueueTimeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+	var err error
+	select {
+	case <-ready:
+		return b.releaseFunc(target), nil
+	case <-timeoutC:
+		err = ErrBulkheadTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	b.mu.Lock()
+	select {
+	case <-ready:
+		// 超时的同时获取到许可
+		b.mu.Unlock()
+		return b.releaseFunc(target), nil
+	default:
+	}
+	b.waiters.Remove(elem)
+	b.report(target)
+	b.mu.Unlock()
+	if err == ErrBulkheadTimeout {
+		prom.HttpClientBulkheadRejectedTotal.WithLabelValues(target, bulkheadTimeoutReason).Inc()
+	}
+	return nil, err
+}
+
+// wake 唤醒排队请求直到达到最大并发数
+func (b *bulkhead) wake(maxConcurrent int) {
+	for b.active < maxConcurrent {
+		front := b.waiters.Front()
+		if front == nil {
+			return
+		}
+		b.waiters.Remove(front)
+		close(front.Value.(chan struct{}))
+		b.active++
+	}
+}
+
+// releaseFunc 释放许可 有排队请求时直接转交
+func (b *bulkhead) releaseFunc(target string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			// 并发数调小时不转交
+			if front := b.waiters.Front(); front != nil && b.active <= b.limit {
+				b.waiters.Remove(front)
+				close(front.Value.(chan struct{}))
+			} else {
+				b.active--
+			}
+			b.report(target)
+		})
+	}
+}
+
+func (b *bulkhead) report(target string) {
+	prom.HttpClientBulkheadActive.WithLabelValues(target).Set(float64(b.active))
+	prom.HttpClientBulkheadQueued.WithLabelValues(target).Set(float64(b.waiters.Len()))
+}
@@ -0,0 +1,403 @@
+package httpclient
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/LeeZXin/zsf/prom"
+	"github.com/LeeZXin/zsf/rpcheader"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GET响应缓存
+// 遵循Cache-Control、Expires 存在ETag、Last-Modified时过期后条件请求重新校验
+// 缓存key为目标服务+请求路径+指定请求头 相同key的并发请求合并为一次上游请求
+// 流式请求和带条件请求头的请求不缓存
+
+const (
+	defaultCacheMaxBytes      = 32 * 1024 * 1024
+	defaultCacheMaxEntryBytes = 1024 * 1024
+
+	cacheHitResult         = "hit"
+	cacheMissResult        = "miss"
+	cacheRevalidatedResult = "revalidated"
+	cacheCoalescedResult   = "coalesced"
+)
+
+type streamCtxKey struct{}
+
+// withStream 标记流式请求 拦截器不读取响应体
+func withStream(ctx context.Context) context.Context {
+	return context.WithValue(ctx, streamCtxKey{}, true)
+}
+
+func isStream(ctx context.Context) bool {
+	b, _ := ctx.Value(streamCtxKey{}).(bool)
+	return b
+}
+
+type CacheConfig struct {
+	// MaxBytes 缓存总大小 默认32M
+	MaxBytes int64
+	// MaxEntryBytes 单个响应最大缓存大小 默认1M
+	MaxEntryBytes int64
+	// KeyHeaders 参与缓存key的请求头
+	KeyHeaders []string
+}
+
+type cacheEntry struct {
+	key    string
+	status int
+	header http.Header
+	body   []byte
+	// vary 响应Vary指定的请求头的值
+	vary     map[string]string
+	expireAt time.Time
+	size     int64
+}
+
+func (e *cacheEntry) fresh(now time.Time) bool {
+	return now.Before(e.expireAt)
+}
+
+func (e *cacheEntry) hasValidator() bool {
+	return e.header.Get("ETag") != "" || e.header.Get("Last-Modified") != ""
+}
+
+func (e *cacheEntry) matchVary(request *http.Request) bool {
+	for k, v := range e.vary {
+		if request.Header.Get(k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// response 每次返回新的响应 entry不可修改
+func (e *cacheEntry) response(request *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.status, http.StatusText(e.status)),
+		StatusCode:    e.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       request,
+	}
+}
+
+// revalidated 304响应更新响应头和过期时间
+func (e *cacheEntry) revalidated(header http.Header, now time.Time) *cacheEntry {
+	ret := *e
+	ret.header = e.header.Clone()
+	for k, v := range header {
+		ret.header[k] = v
+	}
+	ret.expireAt = now.Add(freshness(ret.header, now))
+	return &ret
+}
+
+func newCacheEntry(key string, request *http.Request, resp *http.Response, body []byte, now time.Time) *cacheEntry {
+	ret := &cacheEntry{
+		key:      key,
+		status:   resp.StatusCode,
+		header:   resp.Header.Clone(),
+		body:     body,
+		expireAt: now.Add(freshness(resp.Header, now)),
+		size:     int64(len(key) + len(body)),
+	}
+	for _, vary := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(vary, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				if ret.vary == nil {
+					ret.vary = make(map[string]string)
+				}
+				ret.vary[name] = request.Header.Get(name)
+			}
+		}
+	}
+	for k, values := range ret.header {
+		ret.size += int64(len(k))
+		for _, v := range values {
+			ret.size += int64(len(v))
+		}
+	}
+	return ret
+}
+
+// storable 200响应 未禁止缓存且有过期时间或校验字段
+func storable(resp *http.Response, entry *cacheEntry, now time.Time) bool {
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	if _, b := parseCacheControl(resp.Header)["no-store"]; b {
+		return false
+	}
+	if _, b := entry.vary["*"]; b {
+		return false
+	}
+	return entry.fresh(now) || entry.hasValidator()
+}
+
+// freshness 响应有效时长 max-age优先于Expires no-cache为0
+func freshness(header http.Header, now time.Time) time.Duration {
+	cc := parseCacheControl(header)
+	if _, b := cc["no-cache"]; b {
+		return 0
+	}
+	if maxAge, b := cc["max-age"]; b {
+		seconds, err := strconv.Atoi(maxAge)
+		if err != nil {
+			return 0
+		}
+		age, _ := strconv.Atoi(header.Get("Age"))
+		return time.Duration(seconds-age) * time.Second
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		expireAt, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		date := now
+		if t, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = t
+		}
+		return expireAt.Sub(date)
+	}
+	return 0
+}
+
+func parseCacheControl(header http.Header) map[string]string {
+	ret := make(map[string]string)
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				ret[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return ret
+}
+
+// cacheCall 合并中的上游请求 entry为nil表示响应过大无法共享
+type cacheCall struct {
+	done  chan struct{}
+	entry *cacheEntry
+	err   error
+}
+
+// ResponseCache 按字节数淘汰的lru缓存
+type ResponseCache struct {
+	sync.Mutex
+	maxBytes      int64
+	maxEntryBytes int64
+	keyHeaders    []string
+	size          int64
+	ll            *list.List
+	cache         map[string]*list.Element
+	calls         map[string]*cacheCall
+}
+
+func NewResponseCache(cfg CacheConfig) *ResponseCache {
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = defaultCacheMaxBytes
+	}
+	if cfg.MaxEntryBytes <= 0 {
+		cfg.MaxEntryBytes = defaultCacheMaxEntryBytes
+	}
+	if cfg.MaxEntryBytes > cfg.MaxBytes {
+		cfg.MaxEntryBytes = cfg.MaxBytes
+	}
+	return &ResponseCache{
+		maxBytes:      cfg.MaxBytes,
+		maxEntryBytes: cfg.MaxEntryBytes,
+		keyHeaders:    cfg.KeyHeaders,
+		ll:            list.New(),
+		cache:         make(map[string]*list.Element, 1024),
+		calls:         make(map[string]*cacheCall, 8),
+	}
+}
+
+// CacheInterceptor 响应缓存拦截器 只作用于GET请求
+func CacheInterceptor(cache *ResponseCache) Interceptor {
+	return func(request *http.Request, invoker Invoker) (*http.Response, error) {
+		if request.Method != http.MethodGet || isStream(request.Context()) ||
+			request.Header.Get("If-None-Match") != "" || request.Header.Get("If-Modified-Since") != "" {
+			return invoker(request)
+		}
+		reqCc := parseCacheControl(request.Header)
+		if _, b := reqCc["no-store"]; b {
+			return invoker(request)
+		}
+		target := request.Header.Get(rpcheader.Target)
+		key := cache.key(request)
+		now := time.Now()
+		entry := cache.get(key, request)
+		if _, b := reqCc["no-cache"]; !b && entry != nil && entry.fresh(now) {
+			prom.HttpClientCacheTotal.WithLabelValues(target, cacheHitResult).Inc()
+			return entry.response(request), nil
+		}
+		cache.Lock()
+		if call, b := cache.calls[key]; b {
+			cache.Unlock()
+			// 等待合并的请求时不超过自身的超时时间
+			select {
+			case <-call.done:
+			case <-request.Context().Done():
+				return nil, request.Context().Err()
+			}
+			return cache.follow(request, invoker, target, call)
+		}
+		call := &cacheCall{
+			done: make(chan struct{}),
+		}
+		cache.calls[key] = call
+		cache.Unlock()
+		defer func() {
+			cache.Lock()
+			delete(cache.calls, key)
+			cache.Unlock()
+			close(call.done)
+		}()
+		var resp *http.Response
+		resp, call.entry, call.err = cache.fetch(request, invoker, key, target, entry)
+		return resp, call.err
+	}
+}
+
+// follow 使用合并请求的结果 上游请求被取消或响应过大时单独请求
+func (c *ResponseCache) follow(request *http.Request, invoker Invoker, target string, call *cacheCall) (*http.Response, error) {
+	if call.err != nil {
+		if request.Context().Err() == nil &&
+			(errors.Is(call.err, context.Canceled) || errors.Is(call.err, context.DeadlineExceeded)) {
+			return invoker(request)
+		}
+		return nil, call.err
+	}
+	if call.entry == nil {
+		return invoker(request)
+	}
+	prom.HttpClientCacheTotal.WithLabelValues(target, cacheCoalescedResult).Inc()
+	return call.entry.response(request), nil
+}
+
+// fetch 请求上游 存在过期缓存时发送条件请求
+func (c *ResponseCache) fetch(request *http.Request, invoker Invoker, key, target string, stale *cacheEntry) (*http.Response, *cacheEntry, error) {
+	if stale != nil && stale.hasValidator() {
+		if etag := stale.header.Get("ETag"); etag != "" {
+			request.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := stale.header.Get("Last-Modified"); lastModified != "" {
+			request.Header.Set("If-Modified-Since", lastModified)
+		}
+	} else {
+		stale = nil
+	}
+	resp, err := invoker(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	if stale != nil && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		entry := stale.revalidated(resp.Header, now)
+		c.put(entry)
+		prom.HttpClientCacheTotal.WithLabelValues(target, cacheRevalidatedResult).Inc()
+		return entry.response(request), entry, nil
+	}
+	prom.HttpClientCacheTotal.WithLabelValues(target, cacheMissResult).Inc()
+	if resp.ContentLength > c.maxEntryBytes {
+		return resp, nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.maxEntryBytes+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, nil, err
+	}
+	// 超过单个缓存大小 不缓存也不共享
+	if int64(len(body)) > c.maxEntryBytes {
+		resp.Body = &readCloser{
+			Reader: io.MultiReader(bytes.NewReader(body), resp.Body),
+			Closer: resp.Body,
+		}
+		return resp, nil, nil
+	}
+	resp.Body.Close()
+	entry := newCacheEntry(key, request, resp, body, now)
+	if storable(resp, entry, now) {
+		c.put(entry)
+	} else if stale != nil {
+		c.remove(key)
+	}
+	return entry.response(request), entry, nil
+}
+
+func (c *ResponseCache) key(request *http.Request) string {
+	var key strings.Builder
+	key.WriteString(request.Header.Get(rpcheader.Target))
+	key.WriteString(" ")
+	key.WriteString(request.URL.RequestURI())
+	for _, name := range c.keyHeaders {
+		key.WriteString("\n")
+		key.WriteString(name)
+		key.WriteString(":")
+		key.WriteString(request.Header.Get(name))
+	}
+	return key.String()
+}
+
+func (c *ResponseCache) get(key string, request *http.Request) *cacheEntry {
+	c.Lock()
+	defer c.Unlock()
+	elem, b := c.cache[key]
+	if !b {
+		return nil
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !entry.matchVary(request) {
+		return nil
+	}
+	c.ll.MoveToFront(elem)
+	return entry
+}
+
+func (c *ResponseCache) put(entry *cacheEntry) {
+	if entry.size > c.maxEntryBytes {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if elem, b := c.cache[entry.key]; b {
+		c.removeElement(elem)
+	}
+	c.cache[entry.key] = c.ll.PushFront(entry)
+	c.size += entry.size
+	for c.size > c.maxBytes {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *ResponseCache) remove(key string) {
+	c.Lock()
+	defer c.Unlock()
+	if elem, b := c.cache[key]; b {
+		c.removeElement(elem)
+	}
+}
+
+func (c *ResponseCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	c.ll.Remove(elem)
+	delete(c.cache, entry.key)
+	c.size -= entry.size
+}
@@ -3,10 +3,12 @@ package httpclient
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/LeeZXin/zsf/common"
+	"github.com/LeeZXin/zsf/logger"
+	"github.com/LeeZXin/zsf/prom"
 	"github.com/LeeZXin/zsf/rpcheader"
 	"github.com/LeeZXin/zsf/services/discovery"
 	"github.com/LeeZXin/zsf/services/lb"
@@ -15,11 +17,13 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // client封装
-// 仅支持contentType="app/json;charset=utf-8"请求发送
+// 请求体默认使用json编码 可通过WithCodec指定
 // 下游是http
 
 const (
@@ -34,17 +38,44 @@ type option struct {
 	httpClient      *http.Client
 	authTs          int64
 	authSecret      string
+	authKeyId       string
+	authKeySecret   string
 	applicationName string
 	region          string
 	zone            string
 	is              []Interceptor
+	retryPolicy     *RetryPolicy
+	lbPolicy        lb.Policy
+	codec           Codec
+	query           any
+	errorDecoder    ErrorDecoder
+	maxResponseSize int64
+	// progress 请求体发送进度
+	progress    func(int64)
+	scheme      string
+	tlsConfig   *tls.Config
+	staticHosts []string
+	// basePath DialURL的路径前缀
+	basePath       string
+	hedgePolicy    *HedgePolicy
+	bulkheadPolicy *BulkheadPolicy
+	// resource 路由模板 用于sentinel资源名
+	resource string
 }
 
 type Option func(*option)
 
+// WithHeader 请求头 多次设置时合并 后设置的覆盖相同的header
 func WithHeader(header map[string]string) Option {
 	return func(o *option) {
-		o.header = header
+		merged := make(map[string]string, len(o.header)+len(header))
+		for k, v := range o.header {
+			merged[k] = v
+		}
+		for k, v := range header {
+			merged[http.CanonicalHeaderKey(k)] = v
+		}
+		o.header = merged
 	}
 }
 
@@ -79,6 +110,14 @@ func WithAuthSecret(secret string) Option {
 	}
 }
 
+// WithAuthKey 使用v2签名 keyId用于服务端密钥轮换
+func WithAuthKey(keyId, secret string) Option {
+	return func(o *option) {
+		o.authKeyId = keyId
+		o.authKeySecret = secret
+	}
+}
+
 func WithApplicationName(applicationName string) Option {
 	return func(o *option) {
 		o.applicationName = applicationName
@@ -97,6 +136,96 @@ func WithZone(zone string) Option {
 	}
 }
 
+// WithRetryPolicy 重试策略
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *option) {
+		o.retryPolicy = &policy
+	}
+}
+
+// WithLbPolicy 服务级别负载均衡策略
+func WithLbPolicy(policy lb.Policy) Option {
+	return func(o *option) {
+		o.lbPolicy = policy
+	}
+}
+
+// WithCodec 请求编解码 默认json
+func WithCodec(codec Codec) Option {
+	return func(o *option) {
+		o.codec = codec
+	}
+}
+
+// WithQuery 查询参数 支持url.Values、map和带form标签的结构体
+func WithQuery(query any) Option {
+	return func(o *option) {
+		o.query = query
+	}
+}
+
+// WithErrorDecoder 业务错误解析器 优先于RegisterErrorDecoder 解析非2xx响应和code非0的2xx响应
+func WithErrorDecoder(decoder ErrorDecoder) Option {
+	return func(o *option) {
+		o.errorDecoder = decoder
+	}
+}
+
+// WithMaxResponseSize 响应体最大长度 默认10M 流式请求默认不限制
+func WithMaxResponseSize(size int64) Option {
+	return func(o *option) {
+		o.maxResponseSize = size
+	}
+}
+
+// WithScheme 请求协议http或https 默认http
+func WithScheme(scheme string) Option {
+	return func(o *option) {
+		o.scheme = scheme
+	}
+}
+
+// WithTLSConfig https的tls配置 仅作为Dial选项生效
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(o *option) {
+		o.tlsConfig = cfg
+	}
+}
+
+// WithStaticHosts 静态实例列表host:port 不使用服务发现
+func WithStaticHosts(hosts ...string) Option {
+	return func(o *option) {
+		o.staticHosts = hosts
+	}
+}
+
+func withBasePath(basePath string) Option {
+	return func(o *option) {
+		o.basePath = basePath
+	}
+}
+
+// WithHedgePolicy 对冲请求策略 只对GET、HEAD、OPTIONS生效
+func WithHedgePolicy(policy HedgePolicy) Option {
+	return func(o *option) {
+		o.hedgePolicy = &policy
+	}
+}
+
+// WithBulkhead 服务级别并发限制
+func WithBulkhead(policy BulkheadPolicy) Option {
+	return func(o *option) {
+		o.bulkheadPolicy = &policy
+	}
+}
+
+// WithResource 接口的路由模板 如/api/user/{id} 用于按接口流控熔断 路径中带id时不能使用原始路径
+func WithResource(resource string) Option {
+	return func(o *option) {
+		o.resource = resource
+	}
+}
+
 func WithInterceptors(is ...Interceptor) Option {
 	return func(o *option) {
 		o.is = is
@@ -109,6 +238,8 @@ type Client interface {
 	Put(ctx context.Context, path string, req, resp any, opts ...Option) error
 	Delete(ctx context.Context, path string, resp any, opts ...Option) error
 	Proxy(ctx *gin.Context, path string, opts ...Option) error
+	// Stream 流式请求 调用方需关闭StreamResponse
+	Stream(ctx context.Context, method, path string, req any, opts ...Option) (*StreamResponse, error)
 	Close()
 }
 
@@ -135,6 +266,10 @@ func (*emptyClient) Proxy(c *gin.Context, _ string, _ ...Option) error {
 	return lb.ServerNotFound
 }
 
+func (*emptyClient) Stream(context.Context, string, string, any, ...Option) (*StreamResponse, error) {
+	return nil, lb.ServerNotFound
+}
+
 func (*emptyClient) Close() {}
 
 func NewEmptyClient() Client {
@@ -143,42 +278,56 @@ func NewEmptyClient() Client {
 
 type clientImpl struct {
 	ServiceName  string
-	httpClient   *http.Client
 	Interceptors []Interceptor
+	// configName httpclient.services下的配置名 为空不读取配置
+	configName string
+	// opts 服务级别选项
+	opts []Option
+	// state 服务配置 动态配置变化时替换
+	state atomic.Pointer[clientState]
+	smu   sync.Mutex
+	// balancers 服务级别负载均衡器
+	balancers sync.Map
+	// hedge 对冲请求的延迟统计和限流
+	hedge hedgeState
+	// bulkhead 并发限制
+	bulkhead bulkhead
+	// hostsDiscoveries 静态实例列表
+	hostsDiscoveries sync.Map
 }
 
 func (c *clientImpl) Close() {
-	if c.httpClient != nil {
-		c.httpClient.CloseIdleConnections()
+	if state := c.state.Load(); state != nil {
+		state.httpClient.CloseIdleConnections()
 	}
 }
 
 func (c *clientImpl) Get(ctx context.Context, path string, resp any, opts ...Option) error {
-	err := c.send(ctx, path, http.MethodGet, "", nil, resp, opts...)
+	err := c.send(ctx, path, http.MethodGet, nil, resp, opts...)
 	if err != nil {
-		err = fmt.Errorf("transport: %s with err: %v", c.ServiceName, err)
+		err = fmt.Errorf("transport: %s with err: %w", c.ServiceName, err)
 	}
 	return err
 }
 func (c *clientImpl) Post(ctx context.Context, path string, req, resp any, opts ...Option) error {
-	err := c.send(ctx, path, http.MethodPost, JsonContentType, req, resp, opts...)
+	err := c.send(ctx, path, http.MethodPost, req, resp, opts...)
 	if err != nil {
-		err = fmt.Errorf("transport: %s with err: %v", c.ServiceName, err)
+		err = fmt.Errorf("transport: %s with err: %w", c.ServiceName, err)
 	}
 	return err
 }
 func (c *clientImpl) Put(ctx context.Context, path string, req, resp any, opts ...Option) error {
-	err := c.send(ctx, path, http.MethodPut, JsonContentType, req, resp, opts...)
+	err := c.send(ctx, path, http.MethodPut, req, resp, opts...)
 	if err != nil {
-		err = fmt.Errorf("transport: %s with err: %v", c.ServiceName, err)
+		err = fmt.Errorf("transport: %s with err: %w", c.ServiceName, err)
 	}
 	return err
 }
 
 func (c *clientImpl) Delete(ctx context.Context, path string, resp any, opts ...Option) error {
-	err := c.send(ctx, path, http.MethodDelete, "", nil, resp, opts...)
+	err := c.send(ctx, path, http.MethodDelete, nil, resp, opts...)
 	if err != nil {
-		err = fmt.Errorf("transport: %s with err: %v", c.ServiceName, err)
+		err = fmt.Errorf("transport: %s with err: %w", c.ServiceName, err)
 	}
 	return err
 }
@@ -189,66 +338,308 @@ func (c *clientImpl) Proxy(ctx *gin.Context, path string, opts ...Option) error
 	for k := range req.Header {
 		header[k] = req.Header.Get(k)
 	}
-	err := c.send(ctx, path, req.Method, "", req.Body, ctx, append(opts, withHeader(header))...)
+	result, err := c.execute(ctx, path, req.Method, req.Body, true, append(opts, withHeader(header)))
 	if err != nil {
 		ctx.String(http.StatusBadGateway, "")
+		return fmt.Errorf("transport: %s with err: %w", c.ServiceName, err)
 	}
-	return err
+	defer result.resp.Body.Close()
+	return writeProxyResponse(ctx, result.resp)
+}
+
+func (c *clientImpl) send(ctx context.Context, path, method string, req, resp any, opts ...Option) error {
+	result, err := c.execute(ctx, path, method, req, false, opts)
+	if err != nil {
+		return err
+	}
+	maxSize := result.opt.maxResponseSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxResponseSize
+	}
+	return handleResponse(result.resp, resp, result.codec, maxSize, result)
+}
+
+// callResult 请求结果
+type callResult struct {
+	resp    *http.Response
+	codec   Codec
+	opt     *option
+	target  string
+	server  lb.Server
+	attempt int
 }
 
-func (c *clientImpl) send(ctx context.Context, path, method, contentType string, req, resp any, opts ...Option) error {
-	// 加载选项
+func (r *callResult) httpError(resp *http.Response) error {
+	return newHTTPError(resp, r.target, serverAddr(r.server), r.attempt, r.errorDecoder())
+}
+
+// bizError 2xx响应体为{"code":非0,"message":"xx"}时使用错误解析器解析 未配置解析器时不处理
+func (r *callResult) bizError(resp *http.Response, body []byte) error {
+	decoder := r.errorDecoder()
+	if decoder == nil || !hasBizErrCode(body) {
+		return nil
+	}
+	ret := &HTTPError{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+		Target:     r.target,
+		Instance:   serverAddr(r.server),
+		Attempts:   r.attempt,
+	}
+	if len(body) > maxErrorBodySize {
+		ret.Body = body[:maxErrorBodySize]
+	}
+	if ret.Err = decoder(ret); ret.Err == nil {
+		return nil
+	}
+	return ret
+}
+
+func (r *callResult) errorDecoder() ErrorDecoder {
+	if r.opt.errorDecoder != nil {
+		return r.opt.errorDecoder
+	}
+	return getErrorDecoder(r.target)
+}
+
+// execute 选择实例发送请求 按重试策略重试
+// stream为true时不限制响应读取时间 超时时间只作用于等待响应头 响应体关闭时释放资源
+func (c *clientImpl) execute(ctx context.Context, path, method string, req any, stream bool, opts []Option) (*callResult, error) {
+	// 加载选项 服务级别选项在前 配置次之 调用级别选项覆盖
+	state := c.getState()
 	opt := new(option)
+	for _, apply := range c.opts {
+		apply(opt)
+	}
+	for _, apply := range state.opts {
+		apply(opt)
+	}
 	for _, apply := range opts {
 		apply(opt)
 	}
-	// 获取服务ip
-	var (
-		server lb.Server
-		err    error
-	)
 	dis := opt.discovery
+	if len(opt.staticHosts) > 0 {
+		dis = c.getHostsDiscovery(opt.staticHosts, opt.lbPolicy)
+	}
 	if dis == nil {
 		dis = discovery.GetDefaultDiscovery()
 	}
 	if dis == nil {
-		return errors.New("discovery is not set")
+		return nil, errors.New("discovery is not set")
 	}
-	if opt.discoveryZone == "" {
-		server, err = dis.ChooseServer(ctx, c.ServiceName)
-	} else {
-		server, err = dis.ChooseServerWithZone(ctx, opt.discoveryZone, c.ServiceName)
+	codec := opt.codec
+	if codec == nil {
+		codec = jsonCodec{}
 	}
+	if opt.query != nil {
+		var err error
+		if path, err = appendQuery(path, opt.query); err != nil {
+			return nil, err
+		}
+	}
+	// request
+	reqBody, reqReader, contentType, err := marshalRequest(codec, req)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if body, ok := req.(*MultipartBody); ok {
+		opt.progress = body.progress
 	}
+	httpClient := state.httpClient
+	if opt.httpClient != nil {
+		httpClient = opt.httpClient
+	}
+	if stream {
+		ctx = withStream(ctx)
+	}
+	if opt.resource != "" {
+		ctx = withResource(ctx, opt.resource)
+	}
+	var headerTimeout time.Duration
+	if stream && httpClient.Timeout > 0 {
+		headerTimeout = httpClient.Timeout
+		cpy := *httpClient
+		cpy.Timeout = 0
+		httpClient = &cpy
+	}
+	maxAttempts := 1
+	if reqReader == nil {
+		maxAttempts = opt.retryPolicy.maxAttempts(method)
+	}
+	release, err := c.bulkhead.acquire(ctx, c.ServiceName, opt.bulkheadPolicy)
+	if err != nil {
+		return nil, err
+	}
+	// cancels 响应体关闭或请求失败时释放
+	cancels := make([]context.CancelFunc, 0, 3)
+	cancels = append(cancels, release)
+	releaseAll := func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+	if maxAttempts > 1 && opt.retryPolicy.Budget > 0 {
+		var cancelFunc context.CancelFunc
+		ctx, cancelFunc = context.WithTimeout(ctx, opt.retryPolicy.Budget)
+		cancels = append(cancels, cancelFunc)
+	}
+	// 对冲请求只作用于幂等、可重放的非流式请求
+	hedge := opt.hedgePolicy != nil && !stream && reqReader == nil && isSafeMethod(method)
+	roundTrip := func(reqCtx context.Context, server lb.Server) (*http.Response, error) {
+		fallbackCtx, fallback := withFallbackFlag(reqCtx)
+		request, err := c.newRequest(fallbackCtx, server, path, method, contentType, reqBody, reqReader, opt)
+		if err != nil {
+			return nil, &requestError{err: err}
+		}
+		start := time.Now()
+		respBody, err := c.doRequest(request, httpClient, opt)
+		if fallback.Load() {
+			// 降级响应 没有请求实例
+			markFallback(reqCtx)
+			return respBody, err
+		}
+		reportOutlier(reqCtx, server, respBody, err)
+		if hedge && err == nil && respBody.StatusCode < http.StatusInternalServerError {
+			c.hedge.record(time.Since(start))
+		}
+		return respBody, err
+	}
+	tried := make(map[string]struct{}, maxAttempts)
+	for attempt := 1; ; attempt++ {
+		// 获取服务ip 重试时排除已请求过的实例
+		server, err := c.chooseServer(ctx, dis, opt, tried)
+		if err != nil {
+			releaseAll()
+			return nil, err
+		}
+		tried[serverAddr(server)] = struct{}{}
+		var (
+			respBody  *http.Response
+			reqCancel context.CancelCauseFunc
+		)
+		attemptCtx, fallback := withFallbackFlag(ctx)
+		if hedge {
+			respBody, server, reqCancel, err = c.doHedged(attemptCtx, server, dis, opt, tried, roundTrip)
+		} else {
+			var reqCtx context.Context
+			reqCtx, reqCancel = context.WithCancelCause(attemptCtx)
+			var timer *time.Timer
+			if headerTimeout > 0 {
+				timer = time.AfterFunc(headerTimeout, func() {
+					reqCancel(errHeaderTimeout)
+				})
+			}
+			respBody, err = roundTrip(reqCtx, server)
+			if timer != nil && !timer.Stop() {
+				if respBody != nil {
+					respBody.Body.Close()
+					respBody = nil
+				}
+				err = fmt.Errorf("%w after %v", errHeaderTimeout, headerTimeout)
+			}
+		}
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			reqCancel(nil)
+			releaseAll()
+			return nil, reqErr.err
+		}
+		// 降级响应不重试
+		retry := attempt < maxAttempts && !fallback.Load()
+		var reason string
+		if err != nil {
+			retry = retry && isRetryableErr(ctx, err)
+			reason = "error"
+		} else {
+			retry = retry && opt.retryPolicy.isRetryableStatus(respBody.StatusCode)
+			reason = strconv.Itoa(respBody.StatusCode)
+		}
+		var wait time.Duration
+		if retry {
+			wait = opt.retryPolicy.backoff(attempt)
+			retry = canWaitBackoff(ctx, wait)
+		}
+		if !retry {
+			if err != nil {
+				reqCancel(nil)
+				releaseAll()
+				return nil, err
+			}
+			respBody.Body = &releaseBody{
+				ReadCloser: respBody.Body,
+				release: func() {
+					reqCancel(nil)
+					releaseAll()
+				},
+			}
+			return &callResult{
+				resp:    respBody,
+				codec:   codec,
+				opt:     opt,
+				target:  c.ServiceName,
+				server:  server,
+				attempt: attempt,
+			}, nil
+		}
+		// 释放连接后等待重试
+		if respBody != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(respBody.Body, 4096))
+			respBody.Body.Close()
+		}
+		reqCancel(nil)
+		attemptErr := err
+		if err = waitBackoff(ctx, wait); err != nil {
+			releaseAll()
+			return nil, err
+		}
+		prom.HttpClientRetryTotal.WithLabelValues(c.ServiceName, reason, strconv.Itoa(attempt+1)).Inc()
+		logger.Logger.WithContext(ctx).Warnf("httpclient retry target: %s, instance: %s, attempt: %d, reason: %s, err: %v",
+			c.ServiceName, serverAddr(server), attempt+1, reason, attemptErr)
+	}
+}
+
+// marshalRequest 序列化请求 返回请求体和Content-Type io.Reader和MultipartBody无法重放
+func marshalRequest(codec Codec, req any) ([]byte, io.Reader, string, error) {
+	switch body := req.(type) {
+	case nil:
+		return nil, nil, "", nil
+	case *FormBody:
+		return body.Bytes(), nil, body.ContentType(), nil
+	case *MultipartBody:
+		return nil, body.Reader(), body.ContentType(), nil
+	case io.Reader:
+		return nil, body, "", nil
+	}
+	reqBytes, err := codec.Marshal(req)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return reqBytes, nil, codec.ContentType(), nil
+}
+
+func (c *clientImpl) newRequest(ctx context.Context, server lb.Server, path, method, contentType string, reqBody []byte, reqReader io.Reader, opt *option) (*http.Request, error) {
 	// 拼接host
-	url := "http://" + fmt.Sprintf("%s:%d", server.Host, server.Port)
+	scheme := opt.scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	url := scheme + "://" + serverAddr(server) + opt.basePath
 	if !strings.HasPrefix(path, "/") {
 		url += "/"
 	}
 	url += path
-	// request
-	var reqReader io.Reader
-	if req != nil {
-		var ok bool
-		reqReader, ok = req.(io.Reader)
-		if !ok {
-			reqBytes, err := json.Marshal(req)
-			if err != nil {
-				return err
-			}
-			reqReader = bytes.NewReader(reqBytes)
-		}
+	if reqReader == nil && reqBody != nil {
+		reqReader = bytes.NewReader(reqBody)
 	}
-	// request
 	request, err := http.NewRequestWithContext(ctx, method, url, reqReader)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	headers := rpcheader.GetHeaders(ctx)
 	for k, v := range headers {
-		if strings.HasPrefix(k, rpcheader.Prefix) {
+		// 上游的签名信息不透传
+		if strings.HasPrefix(k, rpcheader.Prefix) && !strings.HasPrefix(k, rpcheader.AuthPrefix) {
 			request.Header.Set(k, v)
 		}
 	}
@@ -259,18 +650,11 @@ func (c *clientImpl) send(ctx context.Context, path, method, contentType string,
 	for k, v := range opt.extraHeader {
 		request.Header.Set(k, v)
 	}
+	// 调用链
+	rpcheader.InjectSpanContext(ctx, request.Header.Set)
 	if contentType != "" {
 		request.Header.Set("Content-Type", contentType)
 	}
-	// 鉴权
-	if opt.authSecret != "" {
-		sign, err := common.GenAuthSign(opt.authSecret, opt.authTs)
-		if err != nil {
-			return err
-		}
-		request.Header.Set(rpcheader.AuthTs, strconv.FormatInt(opt.authTs, 10))
-		request.Header.Set(rpcheader.AuthSign, sign)
-	}
 	if opt.applicationName != "" {
 		// 塞source信息
 		request.Header.Set(rpcheader.Source, opt.applicationName+"-http")
@@ -290,45 +674,130 @@ func (c *clientImpl) send(ctx context.Context, path, method, contentType string,
 	}
 	// 塞target信息
 	request.Header.Set(rpcheader.Target, c.ServiceName)
+	// 鉴权 v2签名包含source
+	if opt.authKeyId != "" {
+		if err = signRequestV2(request, opt.authKeyId, opt.authKeySecret); err != nil {
+			return nil, err
+		}
+	} else if opt.authSecret != "" {
+		if err = signRequestV1(request, opt.authSecret, opt.authTs); err != nil {
+			return nil, err
+		}
+	}
 	// 去除默认User-Agent
 	request.Header.Set("User-Agent", "")
 	// 默认长连接去除connection: close
 	request.Header.Set("Connection", "")
-	// 执行拦截器
+	// 签名读取请求体后再统计发送进度
+	if opt.progress != nil && request.Body != nil && request.Body != http.NoBody {
+		request.Body = &progressBody{
+			ReadCloser: request.Body,
+			progress:   opt.progress,
+		}
+	}
+	return request, nil
+}
+
+// doRequest 执行拦截器和请求
+func (c *clientImpl) doRequest(request *http.Request, httpClient *http.Client, opt *option) (*http.Response, error) {
 	var wrapper interceptorsWrapper
 	if len(opt.is) > 0 {
-		wrapper = interceptorsWrapper{interceptorList: append(c.Interceptors, opt.is...)}
+		wrapper = interceptorsWrapper{interceptorList: append(c.Interceptors[:len(c.Interceptors):len(c.Interceptors)], opt.is...)}
 	} else {
 		wrapper = interceptorsWrapper{interceptorList: c.Interceptors}
 	}
-	respBody, err := wrapper.intercept(request, func(request *http.Request) (*http.Response, error) {
-		if opt.httpClient != nil {
-			return opt.httpClient.Do(request)
-		}
-		return c.httpClient.Do(request)
-	})
+	return wrapper.intercept(request, httpClient.Do)
+}
+
+// handleResponse 处理响应 根据Content-Type选择编解码 *[]byte和*string直接读取原始内容
+// 2xx响应也可能是业务错误 如ginutil.HandleErr返回的{"code":xx,"message":"xx"}
+func handleResponse(respBody *http.Response, resp any, codec Codec, maxSize int64, result *callResult) error {
+	defer respBody.Body.Close()
+	if respBody.StatusCode < http.StatusOK || respBody.StatusCode >= http.StatusMultipleChoices {
+		return result.httpError(respBody)
+	}
+	if resp == nil && result.errorDecoder() == nil {
+		return nil
+	}
+	respBytes, err := io.ReadAll(newLimitReader(respBody.Body, maxSize))
 	if err != nil {
 		return err
 	}
-	defer respBody.Body.Close()
-	if resp != nil {
-		if gctx, ok := resp.(*gin.Context); ok {
-			for k := range respBody.Header {
-				gctx.Header(k, respBody.Header.Get(k))
-			}
-			gctx.DataFromReader(respBody.StatusCode, respBody.ContentLength, respBody.Header.Get("Content-Type"), respBody.Body, nil)
-			return nil
-		}
+	if err = result.bizError(respBody, respBytes); err != nil {
+		return err
+	}
+	if resp == nil {
+		return nil
 	}
-	if respBody.StatusCode != http.StatusOK {
-		return fmt.Errorf("request error with code: %v", respBody.StatusCode)
+	switch resp.(type) {
+	case *[]byte, *string:
+		return rawCodec{}.Unmarshal(respBytes, resp)
 	}
-	if resp != nil {
-		respBytes, err := io.ReadAll(io.LimitReader(respBody.Body, 1024*1024*10))
+	if len(respBytes) == 0 {
+		return nil
+	}
+	// 原始字节只能解码到*[]byte和*string 使用请求编解码
+	if respCodec, b := getCodecByContentType(respBody.Header.Get("Content-Type")); b && respCodec.Name() != RawCodecName {
+		codec = respCodec
+	}
+	return codec.Unmarshal(respBytes, resp)
+}
+
+func (c *clientImpl) chooseServer(ctx context.Context, dis discovery.Discovery, opt *option, tried map[string]struct{}) (lb.Server, error) {
+	var (
+		server lb.Server
+		err    error
+	)
+	for i := 0; i <= len(tried); i++ {
+		// 静态实例列表已使用负载均衡策略
+		usePolicy := opt.lbPolicy != "" && len(opt.staticHosts) == 0
+		if usePolicy {
+			server, err = c.getBalancer(opt.lbPolicy, opt.discoveryZone).ChooseServer(ctx, dis, c.ServiceName)
+		}
+		if !usePolicy || err != nil {
+			server, err = c.chooseDiscoveryServer(ctx, dis, opt)
+		}
 		if err != nil {
-			return err
+			return server, err
+		}
+		if _, b := tried[serverAddr(server)]; !b {
+			return server, nil
+		}
+	}
+	// 所有实例均已尝试
+	return server, nil
+}
+
+func (c *clientImpl) chooseDiscoveryServer(ctx context.Context, dis discovery.Discovery, opt *option) (lb.Server, error) {
+	if opt.discoveryZone == "" {
+		return dis.ChooseServer(ctx, c.ServiceName)
+	}
+	return dis.ChooseServerWithZone(ctx, opt.discoveryZone, c.ServiceName)
+}
+
+// requestError 构建请求失败 不重试
+type requestError struct {
+	err error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+// reportOutlier 上报实例请求结果 调用方主动取消、对冲失败和被sentinel拒绝的请求不计入失败
+func reportOutlier(ctx context.Context, server lb.Server, resp *http.Response, err error) {
+	if isBlockErr(err) {
+		return
+	}
+	if err != nil {
+		if cause := context.Cause(ctx); cause != context.Canceled && cause != errHedgeLost {
+			lb.DefaultOutlierDetector().ReportFailure(server)
 		}
-		return json.Unmarshal(respBytes, resp)
+		return
 	}
-	return nil
+	lb.DefaultOutlierDetector().Report(server, resp.StatusCode >= http.StatusInternalServerError)
+}
+
+func serverAddr(server lb.Server) string {
+	return fmt.Sprintf("%s:%d", server.Host, server.Port)
 }
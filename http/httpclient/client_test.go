@@ -0,0 +1,808 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/LeeZXin/zsf-utils/bizerr"
+	"github.com/LeeZXin/zsf-utils/ginutil"
+	"github.com/LeeZXin/zsf/logger/loggertest"
+	"github.com/LeeZXin/zsf/rpcheader"
+	"github.com/LeeZXin/zsf/services/lb"
+	"github.com/alibaba/sentinel-golang/core/base"
+	"github.com/alibaba/sentinel-golang/core/flow"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testDiscovery struct {
+	balancer lb.LoadBalancer
+}
+
+func newTestDiscovery(servers ...*httptest.Server) *testDiscovery {
+	list := make([]lb.Server, 0, len(servers))
+	for _, s := range servers {
+		host, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+		p, _ := strconv.Atoi(port)
+		list = append(list, lb.Server{
+			Name:   "test",
+			Host:   host,
+			Port:   p,
+			Weight: 1,
+		})
+	}
+	balancer := &lb.NearbyLoadBalancer{
+		LbPolicy: lb.RoundRobin,
+	}
+	balancer.SetServers(list)
+	return &testDiscovery{
+		balancer: balancer,
+	}
+}
+
+func (d *testDiscovery) Discover(context.Context, string) ([]lb.Server, error) {
+	return d.balancer.GetServers(), nil
+}
+
+func (d *testDiscovery) DiscoverWithZone(context.Context, string, string) ([]lb.Server, error) {
+	return nil, lb.ServerNotFound
+}
+
+func (d *testDiscovery) ChooseServer(ctx context.Context, _ string) (lb.Server, error) {
+	return d.balancer.ChooseServer(ctx)
+}
+
+func (d *testDiscovery) ChooseServerWithZone(context.Context, string, string) (lb.Server, error) {
+	return lb.Server{}, lb.ServerNotFound
+}
+
+func newTestClient(opts ...Option) *clientImpl {
+	return &clientImpl{
+		ServiceName: "test",
+		opts:        append([]Option{WithHttpClient(http.DefaultClient)}, opts...),
+	}
+}
+
+func TestClient_Retry(t *testing.T) {
+	loggertest.NewHook(t)
+	var badHits, goodHits atomic.Int32
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		badHits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		goodHits.Add(1)
+		w.Write([]byte(`{"name":"zsf"}`))
+	}))
+	defer good.Close()
+	client := newTestClient(WithDiscovery(newTestDiscovery(bad, good)), WithRetryPolicy(RetryPolicy{
+		MaxAttempts: 2,
+		BaseDelay:   time.Millisecond,
+	}))
+	for i := 0; i < 4; i++ {
+		var resp struct {
+			Name string `json:"name"`
+		}
+		if err := client.Get(context.Background(), "/hello", &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Name != "zsf" {
+			t.Fatalf("unexpected resp: %v", resp)
+		}
+	}
+	if goodHits.Load() != 4 {
+		t.Fatalf("unexpected good hits: %d", goodHits.Load())
+	}
+	// 非幂等请求默认不重试
+	badHits.Store(0)
+	failed := 0
+	for i := 0; i < 2; i++ {
+		if client.Post(context.Background(), "/hello", map[string]string{}, nil) != nil {
+			failed++
+		}
+	}
+	if failed != 1 || badHits.Load() != 1 {
+		t.Fatalf("post should not be retried, failed: %d, bad hits: %d", failed, badHits.Load())
+	}
+}
+
+func TestClient_RetryLog(t *testing.T) {
+	hook := loggertest.NewHook(t)
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 不返回响应直接断开连接
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer good.Close()
+	client := newTestClient(WithStaticHosts(bad.Listener.Addr().String(), good.Listener.Addr().String()), WithRetryPolicy(RetryPolicy{
+		MaxAttempts: 2,
+		BaseDelay:   time.Millisecond,
+	}))
+	// 实例顺序随机 请求两次保证有一次先请求bad
+	for i := 0; i < 2; i++ {
+		if err := client.Get(context.Background(), "/hello", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var retryLog string
+	for _, entry := range hook.AllEntries() {
+		if strings.HasPrefix(entry.Message, "httpclient retry") {
+			retryLog = entry.Message
+		}
+	}
+	if !strings.Contains(retryLog, "reason: error") || !strings.Contains(retryLog, "EOF") {
+		t.Fatalf("unexpected retry log: %s", retryLog)
+	}
+}
+
+func TestClient_MergeHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-A") + "," + r.Header.Get("X-B") + "," + r.Header.Get("X-C")))
+	}))
+	defer server.Close()
+	client := newTestClient(
+		WithStaticHosts(server.Listener.Addr().String()),
+		WithHeader(map[string]string{"X-A": "dial", "x-b": "dial"}),
+	)
+	var ret string
+	if err := client.Get(context.Background(), "/hello", &ret, WithHeader(map[string]string{"X-B": "call", "x-c": "call"})); err != nil {
+		t.Fatal(err)
+	}
+	if ret != "dial,call,call" {
+		t.Fatalf("unexpected headers: %s", ret)
+	}
+	// 调用级别的header不影响其他调用
+	if err := client.Get(context.Background(), "/hello", &ret); err != nil {
+		t.Fatal(err)
+	}
+	if ret != "dial,dial," {
+		t.Fatalf("unexpected headers: %s", ret)
+	}
+}
+
+func TestClient_LoadState(t *testing.T) {
+	defer dynamicServices.Store(nil)
+	client := &clientImpl{
+		ServiceName: "test",
+		configName:  "test",
+	}
+	services := map[string]any{
+		"test": map[string]any{
+			"timeoutms":     1000,
+			"discoveryzone": "z1",
+			"headers": map[string]any{
+				"x-app": "zsf",
+			},
+		},
+	}
+	dynamicServices.Store(&services)
+	client.loadState()
+	state := client.getState()
+	if state.httpClient.Timeout != time.Second {
+		t.Fatalf("unexpected timeout: %v", state.httpClient.Timeout)
+	}
+	opt := new(option)
+	for _, apply := range state.opts {
+		apply(opt)
+	}
+	if opt.discoveryZone != "z1" || opt.header["X-App"] != "zsf" {
+		t.Fatalf("unexpected option: %v", opt)
+	}
+	// 只修改超时时间 复用连接
+	services = map[string]any{
+		"test": map[string]any{
+			"timeoutms": 2000,
+		},
+	}
+	dynamicServices.Store(&services)
+	if !client.loadState() {
+		t.Fatal("state should be changed")
+	}
+	newState := client.getState()
+	if newState.httpClient.Timeout != 2*time.Second || newState.httpClient.Transport != state.httpClient.Transport {
+		t.Fatal("transport should be reused")
+	}
+	if client.loadState() {
+		t.Fatal("state should not be changed")
+	}
+	// 修改连接配置 重建连接
+	services = map[string]any{
+		"test": map[string]any{
+			"timeoutms":        2000,
+			"connecttimeoutms": 100,
+		},
+	}
+	dynamicServices.Store(&services)
+	client.loadState()
+	if client.getState().httpClient.Transport == state.httpClient.Transport {
+		t.Fatal("transport should be recreated")
+	}
+}
+
+func TestClient_Codec(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/query":
+			w.Header().Set("Content-Type", "application/xml")
+			w.Write([]byte("<resp><query><![CDATA[" + r.URL.RawQuery + "]]></query></resp>"))
+		case "/form":
+			r.ParseForm()
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(r.Header.Get("Content-Type") + "|" + r.PostForm.Encode()))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+	client := newTestClient(WithDiscovery(newTestDiscovery(server)))
+	type query struct {
+		Name  string   `form:"name"`
+		Ids   []int    `form:"id"`
+		Empty string   `form:"empty,omitempty"`
+		Skip  string   `form:"-"`
+		Tags  []string `form:"tag,omitempty"`
+	}
+	var xmlResp struct {
+		Query string `xml:"query"`
+	}
+	err := client.Get(context.Background(), "/query?a=1", &xmlResp, WithQuery(query{
+		Name: "zsf",
+		Ids:  []int{1, 2},
+		Skip: "skip",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xmlResp.Query != "a=1&id=1&id=2&name=zsf" {
+		t.Fatalf("unexpected query: %s", xmlResp.Query)
+	}
+	var formResp string
+	err = client.Post(context.Background(), "/form", map[string]string{"k": "v"}, &formResp, WithCodec(formCodec{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formResp != FormContentType+"|k=v" {
+		t.Fatalf("unexpected form resp: %s", formResp)
+	}
+	// 204无响应体
+	var empty struct{}
+	if err = client.Delete(context.Background(), "/empty", &empty); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_HTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code":1001,"message":"invalid name"}`))
+	}))
+	defer server.Close()
+	client := newTestClient(WithDiscovery(newTestDiscovery(server)))
+	err := client.Get(context.Background(), "/hello", nil)
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if httpErr.StatusCode != http.StatusBadRequest || httpErr.Target != "test" || httpErr.Attempts != 1 || httpErr.Err != nil {
+		t.Fatalf("unexpected http error: %v", httpErr)
+	}
+	err = client.Get(context.Background(), "/hello", nil, WithErrorDecoder(BizErrorDecoder))
+	var bizErr *bizerr.Err
+	if !errors.As(err, &bizErr) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if bizErr.Code != 1001 || bizErr.Message != "invalid name" {
+		t.Fatalf("unexpected biz error: %v", bizErr)
+	}
+}
+
+func TestClient_BizErrorWithStatusOK(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		// 与ginutil.HandleErr一致 业务错误返回200
+		if r.URL.Path == "/ok" {
+			w.Write([]byte(`{"code":0,"message":"success","data":"zsf"}`))
+			return
+		}
+		w.Write([]byte(`{"code":1001,"message":"invalid name"}`))
+	}))
+	defer server.Close()
+	client := newTestClient(WithDiscovery(newTestDiscovery(server)))
+	var resp ginutil.DataResp[string]
+	// 未配置错误解析器时由调用方处理code
+	if err := client.Get(context.Background(), "/hello", &resp); err != nil || resp.Code != 1001 {
+		t.Fatalf("unexpected result: %v %v", resp, err)
+	}
+	for _, r := range []any{&resp, nil} {
+		err := client.Get(context.Background(), "/hello", r, WithErrorDecoder(BizErrorDecoder))
+		var bizErr *bizerr.Err
+		if !errors.As(err, &bizErr) || bizErr.Code != 1001 || bizErr.Message != "invalid name" {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+	resp = ginutil.DataResp[string]{}
+	if err := client.Get(context.Background(), "/ok", &resp, WithErrorDecoder(BizErrorDecoder)); err != nil || resp.Data != "zsf" {
+		t.Fatalf("unexpected result: %v %v", resp, err)
+	}
+}
+
+func TestClient_Stream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/events":
+			w.Header().Set("Content-Type", EventStreamContentType)
+			w.Write([]byte(": ping\n\nid: 1\nevent: msg\ndata: {\"name\":\"a\"}\n\n"))
+			w.(http.Flusher).Flush()
+			// 超过请求超时时间继续推送
+			time.Sleep(150 * time.Millisecond)
+			w.Write([]byte("data: line1\r\ndata: line2\r\n\r\n"))
+		case "/slow":
+			time.Sleep(150 * time.Millisecond)
+		default:
+			w.Write([]byte("0123456789"))
+		}
+	}))
+	defer server.Close()
+	client := newTestClient(WithDiscovery(newTestDiscovery(server)), WithHttpClient(&http.Client{
+		Timeout: 100 * time.Millisecond,
+	}))
+	resp, err := client.Stream(context.Background(), http.MethodGet, "/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Close()
+	events := resp.Events()
+	event, err := events.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data struct {
+		Name string `json:"name"`
+	}
+	if event.Id != "1" || event.Event != "msg" || event.Decode(&data) != nil || data.Name != "a" {
+		t.Fatalf("unexpected event: %v", event)
+	}
+	event, err = events.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Data != "line1\nline2" || event.Id != "1" {
+		t.Fatalf("unexpected event: %v", event)
+	}
+	if _, err = events.Next(); err != io.EOF {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	// 等待响应头超时
+	if _, err = client.Stream(context.Background(), http.MethodGet, "/slow", nil); err == nil {
+		t.Fatal("stream should be timeout")
+	}
+	// 长度限制
+	resp, err = client.Stream(context.Background(), http.MethodGet, "/bytes", nil, WithMaxResponseSize(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadAll(resp.Body); err != ErrResponseTooLarge {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	resp.Close()
+	var str string
+	if err = client.Get(context.Background(), "/bytes", &str, WithMaxResponseSize(5)); !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	// 代理
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, "/events", nil)
+	if err = client.Proxy(c, "/events"); err != nil {
+		t.Fatal(err)
+	}
+	if !recorder.Flushed || recorder.Header().Get("Content-Type") != EventStreamContentType {
+		t.Fatal("proxy should flush event stream")
+	}
+}
+
+func TestClient_Multipart(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1024 * 1024); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		content, _ := io.ReadAll(file)
+		w.Write([]byte(r.FormValue("name") + "|" + header.Filename + "|" + string(content)))
+	}))
+	defer server.Close()
+	client := newTestClient(WithDiscovery(newTestDiscovery(server)))
+	var written int64
+	body := NewMultipartBody().
+		AddField("name", "zsf").
+		AddFile("file", "a.txt", strings.NewReader("hello")).
+		OnProgress(func(n int64) {
+			written = n
+		})
+	var resp string
+	if err := client.Post(context.Background(), "/upload", body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp != "zsf|a.txt|hello" {
+		t.Fatalf("unexpected resp: %s", resp)
+	}
+	if written == 0 {
+		t.Fatal("progress should be reported")
+	}
+}
+
+func TestDialURL(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path + "|" + r.Header.Get(rpcheader.Target)))
+	}))
+	defer server.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	client, err := DialURL(server.URL+"/api/", WithTLSConfig(&tls.Config{
+		RootCAs: pool,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var resp string
+	if err = client.Get(context.Background(), "/hello", &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp != "/api/hello|127.0.0.1" {
+		t.Fatalf("unexpected resp: %s", resp)
+	}
+	if _, err = DialURL("ftp://127.0.0.1"); err == nil {
+		t.Fatal("ftp should not be supported")
+	}
+}
+
+func TestClient_StaticHosts(t *testing.T) {
+	var hits [2]atomic.Int32
+	servers := make([]string, 0, 2)
+	for i := range hits {
+		i := i
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[i].Add(1)
+		}))
+		defer server.Close()
+		servers = append(servers, server.Listener.Addr().String())
+	}
+	client := newTestClient(WithStaticHosts(servers...))
+	for i := 0; i < 4; i++ {
+		if err := client.Get(context.Background(), "/hello", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits[0].Load() != 2 || hits[1].Load() != 2 {
+		t.Fatalf("unexpected hits: %d %d", hits[0].Load(), hits[1].Load())
+	}
+}
+
+func TestClient_Hedge(t *testing.T) {
+	var slowHits, fastHits atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slowHits.Add(1)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+		w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fastHits.Add(1)
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+	client := newTestClient(
+		WithStaticHosts(slow.Listener.Addr().String(), fast.Listener.Addr().String()),
+		WithHedgePolicy(HedgePolicy{Delay: 20 * time.Millisecond, MaxRatio: 1}),
+	)
+	for i := 0; i < 2; i++ {
+		var ret string
+		start := time.Now()
+		if err := client.Get(context.Background(), "/hello", &ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != "fast" || time.Since(start) > time.Second {
+			t.Fatalf("unexpected response: %s cost: %v", ret, time.Since(start))
+		}
+	}
+	if slowHits.Load() == 0 || fastHits.Load() != 2 {
+		t.Fatalf("unexpected hits: %d %d", slowHits.Load(), fastHits.Load())
+	}
+	// 幂等的写请求不对冲
+	slowHits.Store(0)
+	fastHits.Store(0)
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		_ = client.Delete(ctx, "/hello", nil)
+		cancel()
+	}
+	if slowHits.Load()+fastHits.Load() != 2 {
+		t.Fatalf("unexpected hits: %d %d", slowHits.Load(), fastHits.Load())
+	}
+}
+
+func TestClient_Cache(t *testing.T) {
+	var hits, notModified atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				notModified.Add(1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/slow":
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+	client := newTestClient(
+		WithStaticHosts(server.Listener.Addr().String()),
+		WithInterceptors(CacheInterceptor(NewResponseCache(CacheConfig{}))),
+	)
+	get := func(path string) {
+		var ret string
+		if err := client.Get(context.Background(), path, &ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != path {
+			t.Fatalf("unexpected response: %s", ret)
+		}
+	}
+	get("/fresh")
+	get("/fresh")
+	if hits.Load() != 1 {
+		t.Fatalf("unexpected hits: %d", hits.Load())
+	}
+	get("/etag")
+	get("/etag")
+	if hits.Load() != 3 || notModified.Load() != 1 {
+		t.Fatalf("unexpected hits: %d notModified: %d", hits.Load(), notModified.Load())
+	}
+	// 并发请求合并
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get("/slow")
+		}()
+	}
+	wg.Wait()
+	if hits.Load() != 4 {
+		t.Fatalf("unexpected hits: %d", hits.Load())
+	}
+}
+
+func TestClient_CacheFollowerTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-unblock:
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer server.Close()
+	defer close(unblock)
+	client := newTestClient(
+		WithStaticHosts(server.Listener.Addr().String()),
+		WithInterceptors(CacheInterceptor(NewResponseCache(CacheConfig{}))),
+	)
+	go client.Get(context.Background(), "/slow", nil)
+	time.Sleep(20 * time.Millisecond)
+	// 合并的请求按自身的超时时间返回
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := client.Get(ctx, "/slow", nil); !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Fatalf("unexpected err: %v cost: %v", err, time.Since(start))
+	}
+}
+
+func TestClient_Bulkhead(t *testing.T) {
+	var hits atomic.Int32
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-unblock
+	}))
+	defer server.Close()
+	client := newTestClient(
+		WithStaticHosts(server.Listener.Addr().String()),
+		WithBulkhead(BulkheadPolicy{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: 200 * time.Millisecond}),
+	)
+	queued := func() int {
+		client.bulkhead.mu.Lock()
+		defer client.bulkhead.mu.Unlock()
+		return client.bulkhead.waiters.Len()
+	}
+	var wg sync.WaitGroup
+	call := func() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := client.Get(context.Background(), "/hello", nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	call()
+	for hits.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := client.Get(context.Background(), "/hello", nil); !errors.Is(err, ErrBulkheadTimeout) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	call()
+	for queued() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := client.Get(context.Background(), "/hello", nil); !errors.Is(err, ErrBulkheadFull) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	close(unblock)
+	wg.Wait()
+	if hits.Load() != 2 || client.bulkhead.active != 0 {
+		t.Fatalf("unexpected hits: %d active: %d", hits.Load(), client.bulkhead.active)
+	}
+}
+
+func TestClient_BulkheadPolicyChanged(t *testing.T) {
+	var hits atomic.Int32
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-unblock
+	}))
+	defer server.Close()
+	client := newTestClient(WithStaticHosts(server.Listener.Addr().String()))
+	var wg sync.WaitGroup
+	call := func(policy BulkheadPolicy) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := client.Get(context.Background(), "/hello", nil, WithBulkhead(policy)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	small := BulkheadPolicy{MaxConcurrent: 1, MaxQueue: 3}
+	for i := 0; i < 3; i++ {
+		call(small)
+	}
+	for hits.Load() != 1 || func() bool {
+		client.bulkhead.mu.Lock()
+		defer client.bulkhead.mu.Unlock()
+		return client.bulkhead.waiters.Len() != 2
+	}() {
+		time.Sleep(time.Millisecond)
+	}
+	// 调大并发数后排队请求不需要等待释放
+	call(BulkheadPolicy{MaxConcurrent: 4, MaxQueue: 3})
+	deadline := time.Now().Add(time.Second)
+	for hits.Load() != 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	concurrent := hits.Load()
+	close(unblock)
+	wg.Wait()
+	if concurrent != 4 || client.bulkhead.active != 0 {
+		t.Fatalf("unexpected hits: %d active: %d", concurrent, client.bulkhead.active)
+	}
+}
+
+func TestClient_Sentinel(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Write([]byte(`{"name":"upstream"}`))
+	}))
+	defer server.Close()
+	_, err := flow.LoadRules([]*flow.Rule{
+		{Resource: "test:/blocked", TokenCalculateStrategy: flow.Direct, ControlBehavior: flow.Reject, Threshold: 0, StatIntervalInMs: 1000},
+		{Resource: "test:/fallback", TokenCalculateStrategy: flow.Direct, ControlBehavior: flow.Reject, Threshold: 0, StatIntervalInMs: 1000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer flow.ClearRules()
+	RegisterFallback("test:/fallback", JsonFallback(http.StatusOK, map[string]string{"name": "fallback"}))
+	client := newTestClient(
+		WithStaticHosts(server.Listener.Addr().String()),
+		WithInterceptors(SentinelInterceptor(true)),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3}),
+	)
+	var ret map[string]string
+	if err = client.Get(context.Background(), "/ok", &ret); err != nil || ret["name"] != "upstream" {
+		t.Fatalf("unexpected response: %v err: %v", ret, err)
+	}
+	if err = client.Get(context.Background(), "/fallback/1", &ret, WithResource("/fallback")); err != nil || ret["name"] != "fallback" {
+		t.Fatalf("unexpected response: %v err: %v", ret, err)
+	}
+	var blockErr *base.BlockError
+	if err = client.Get(context.Background(), "/blocked/1", &ret, WithResource("/blocked")); !errors.As(err, &blockErr) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	// 未指定路由模板时只按目标服务统计 不使用原始路径
+	if err = client.Get(context.Background(), "/blocked", &ret); err != nil || ret["name"] != "upstream" {
+		t.Fatalf("unexpected response: %v err: %v", ret, err)
+	}
+	if hits.Load() != 2 {
+		t.Fatalf("unexpected hits: %d", hits.Load())
+	}
+}
+
+func TestClient_SentinelFallbackNotReported(t *testing.T) {
+	loggertest.NewHook(t)
+	var hits, fallbacks atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+	_, err := flow.LoadRules([]*flow.Rule{
+		{Resource: "test:/unavailable", TokenCalculateStrategy: flow.Direct, ControlBehavior: flow.Reject, Threshold: 0, StatIntervalInMs: 1000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer flow.ClearRules()
+	unavailable := JsonFallback(http.StatusServiceUnavailable, map[string]string{"name": "fallback"})
+	RegisterFallback("test:/unavailable", func(request *http.Request, blockErr *base.BlockError) (*http.Response, error) {
+		fallbacks.Add(1)
+		return unavailable(request, blockErr)
+	})
+	client := newTestClient(
+		WithStaticHosts(server.Listener.Addr().String()),
+		WithInterceptors(SentinelInterceptor(true)),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}),
+	)
+	for i := 0; i < lb.DefaultConsecutiveFailures+1; i++ {
+		var httpErr *HTTPError
+		if err = client.Get(context.Background(), "/unavailable", nil, WithResource("/unavailable")); !errors.As(err, &httpErr) {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+	// 降级的5xx响应不重试
+	if fallbacks.Load() != lb.DefaultConsecutiveFailures+1 || hits.Load() != 0 {
+		t.Fatalf("unexpected fallbacks: %d hits: %d", fallbacks.Load(), hits.Load())
+	}
+	// 没有请求实例 不能摘除
+	addr := server.Listener.Addr().(*net.TCPAddr)
+	if lb.DefaultOutlierDetector().IsEjected(lb.Server{Name: "test", Host: addr.IP.String(), Port: addr.Port}) {
+		t.Fatal("fallback response should not eject instance")
+	}
+}
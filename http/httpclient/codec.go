@@ -0,0 +1,384 @@
+package httpclient
+
+import (
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"mime"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 请求和响应编解码
+// 请求使用选项或服务配置指定的编解码 默认json
+// 响应根据Content-Type选择编解码 找不到时使用请求的编解码
+
+const (
+	JsonCodecName     = "json"
+	FormCodecName     = "form"
+	XmlCodecName      = "xml"
+	ProtobufCodecName = "protobuf"
+	RawCodecName      = "raw"
+
+	FormContentType     = "application/x-www-form-urlencoded"
+	XmlContentType      = "application/xml;charset=utf-8"
+	ProtobufContentType = "application/x-protobuf"
+	RawContentType      = "application/octet-stream"
+)
+
+var (
+	codecMu        = sync.RWMutex{}
+	codecs         = make(map[string]Codec, 8)
+	mediaTypeCodec = make(map[string]Codec, 8)
+)
+
+type Codec interface {
+	// Name 编解码名称
+	Name() string
+	// ContentType 请求Content-Type
+	ContentType() string
+	Marshal(any) ([]byte, error)
+	Unmarshal([]byte, any) error
+}
+
+func init() {
+	RegisterCodec(jsonCodec{})
+	RegisterCodec(formCodec{})
+	RegisterCodec(xmlCodec{}, "text/xml")
+	RegisterCodec(protobufCodec{}, "application/protobuf", "application/vnd.google.protobuf")
+	RegisterCodec(rawCodec{})
+}
+
+// RegisterCodec 注册编解码 mediaTypes为额外匹配的响应Content-Type 同名覆盖
+func RegisterCodec(codec Codec, mediaTypes ...string) {
+	if codec == nil || codec.Name() == "" {
+		return
+	}
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	codecs[codec.Name()] = codec
+	for _, mediaType := range append([]string{codec.ContentType()}, mediaTypes...) {
+		if t := parseMediaType(mediaType); t != "" {
+			mediaTypeCodec[t] = codec
+		}
+	}
+}
+
+// GetCodec 根据名称获取编解码
+func GetCodec(name string) (Codec, bool) {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	ret, b := codecs[name]
+	return ret, b
+}
+
+// getCodecByContentType 根据Content-Type获取编解码 兼容application/xxx+json
+func getCodecByContentType(contentType string) (Codec, bool) {
+	mediaType := parseMediaType(contentType)
+	if mediaType == "" {
+		return nil, false
+	}
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	if ret, b := mediaTypeCodec[mediaType]; b {
+		return ret, true
+	}
+	if i := strings.LastIndex(mediaType, "+"); i >= 0 {
+		switch mediaType[i+1:] {
+		case "json":
+			return codecs[JsonCodecName], true
+		case "xml":
+			return codecs[XmlCodecName], true
+		}
+	}
+	return nil, false
+}
+
+func parseMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return JsonCodecName
+}
+
+func (jsonCodec) ContentType() string {
+	return JsonContentType
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type xmlCodec struct{}
+
+func (xmlCodec) Name() string {
+	return XmlCodecName
+}
+
+func (xmlCodec) ContentType() string {
+	return XmlContentType
+}
+
+func (xmlCodec) Marshal(v any) ([]byte, error) {
+	return xml.Marshal(v)
+}
+
+func (xmlCodec) Unmarshal(data []byte, v any) error {
+	return xml.Unmarshal(data, v)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string {
+	return ProtobufCodecName
+}
+
+func (protobufCodec) ContentType() string {
+	return ProtobufContentType
+}
+
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T is not proto.Message", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T is not proto.Message", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+// rawCodec 原始字节 支持[]byte、string、io.Reader 解码支持*[]byte、*string、io.Writer
+type rawCodec struct{}
+
+func (rawCodec) Name() string {
+	return RawCodecName
+}
+
+func (rawCodec) ContentType() string {
+	return RawContentType
+}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	switch val := v.(type) {
+	case []byte:
+		return val, nil
+	case string:
+		return []byte(val), nil
+	case io.Reader:
+		return io.ReadAll(val)
+	default:
+		return nil, fmt.Errorf("raw codec: unsupported type %T", v)
+	}
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	switch val := v.(type) {
+	case *[]byte:
+		*val = data
+	case *string:
+		*val = string(data)
+	case io.Writer:
+		_, err := val.Write(data)
+		return err
+	default:
+		return fmt.Errorf("raw codec: unsupported type %T", v)
+	}
+	return nil
+}
+
+// formCodec application/x-www-form-urlencoded
+type formCodec struct{}
+
+func (formCodec) Name() string {
+	return FormCodecName
+}
+
+func (formCodec) ContentType() string {
+	return FormContentType
+}
+
+func (formCodec) Marshal(v any) ([]byte, error) {
+	values, err := EncodeValues(v)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(values.Encode()), nil
+}
+
+func (formCodec) Unmarshal(data []byte, v any) error {
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return err
+	}
+	switch val := v.(type) {
+	case *url.Values:
+		*val = values
+	case *map[string]string:
+		m := make(map[string]string, len(values))
+		for k := range values {
+			m[k] = values.Get(k)
+		}
+		*val = m
+	default:
+		return fmt.Errorf("form codec: unsupported type %T", v)
+	}
+	return nil
+}
+
+// EncodeValues 转化为url.Values
+// 支持url.Values、map[string]string、map[string]any和结构体 结构体字段名取form标签 支持omitempty
+func EncodeValues(v any) (url.Values, error) {
+	ret := make(url.Values)
+	if v == nil {
+		return ret, nil
+	}
+	switch val := v.(type) {
+	case url.Values:
+		return val, nil
+	case map[string]string:
+		for k, s := range val {
+			ret.Set(k, s)
+		}
+		return ret, nil
+	case map[string][]string:
+		return val, nil
+	case map[string]any:
+		for k, item := range val {
+			addValue(ret, k, reflect.ValueOf(item))
+		}
+		return ret, nil
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return ret, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("encode values: unsupported type %T", v)
+	}
+	encodeStruct(ret, rv)
+	return ret, nil
+}
+
+func encodeStruct(values url.Values, rv reflect.Value) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fv := rv.Field(i)
+		tag := field.Tag.Get("form")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		// 匿名结构体展开
+		if field.Anonymous && name == "" {
+			for fv.Kind() == reflect.Pointer && !fv.IsNil() {
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				encodeStruct(values, fv)
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.Contains(opts, "omitempty") && fv.IsZero() {
+			continue
+		}
+		addValue(values, name, fv)
+	}
+}
+
+func addValue(values url.Values, name string, rv reflect.Value) {
+	for rv.IsValid() && (rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface) {
+		if rv.IsNil() {
+			return
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return
+	}
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		if rv.Type().Elem().Kind() != reflect.Uint8 {
+			for i := 0; i < rv.Len(); i++ {
+				addValue(values, name, rv.Index(i))
+			}
+			return
+		}
+	}
+	values.Add(name, formatValue(rv))
+}
+
+func formatValue(rv reflect.Value) string {
+	if rv.CanInterface() {
+		switch val := rv.Interface().(type) {
+		case encoding.TextMarshaler:
+			if text, err := val.MarshalText(); err == nil {
+				return string(text)
+			}
+		case fmt.Stringer:
+			return val.String()
+		}
+	}
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, rv.Type().Bits())
+	case reflect.Slice:
+		return string(rv.Bytes())
+	default:
+		return fmt.Sprint(rv.Interface())
+	}
+}
+
+// appendQuery 拼接查询参数
+func appendQuery(path string, query any) (string, error) {
+	values, err := EncodeValues(query)
+	if err != nil {
+		return "", err
+	}
+	if len(values) == 0 {
+		return path, nil
+	}
+	if strings.Contains(path, "?") {
+		return path + "&" + values.Encode(), nil
+	}
+	return path + "?" + values.Encode(), nil
+}
@@ -0,0 +1,434 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/LeeZXin/zsf/logger"
+	"github.com/LeeZXin/zsf/property/dynamic"
+	"github.com/LeeZXin/zsf/property/static"
+	"github.com/LeeZXin/zsf/services/lb"
+	"github.com/spf13/viper"
+	"golang.org/x/net/http2"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 服务级别配置
+// 静态配置 httpclient.services.<name>
+// 动态配置 key默认httpclient.json 内容格式同样为services.<name> 覆盖静态配置
+// 连接相关配置未变化时复用连接
+
+const (
+	defaultDynamicKey = "httpclient.json"
+)
+
+var (
+	// dynamicServices 动态配置 服务名 -> 配置
+	dynamicServices atomic.Pointer[map[string]any]
+)
+
+type ServiceConfig struct {
+	// Http1 使用http1.1 默认h2c
+	Http1 bool `json:"http1"`
+	// TimeoutMs 请求超时时间 默认30s
+	TimeoutMs int `json:"timeoutMs"`
+	// ConnectTimeoutMs 建立连接超时时间 默认10s
+	ConnectTimeoutMs int `json:"connectTimeoutMs"`
+	// 连接池配置 仅http1.1生效
+	MaxIdleConns        int `json:"maxIdleConns"`
+	MaxIdleConnsPerHost int `json:"maxIdleConnsPerHost"`
+	MaxConnsPerHost     int `json:"maxConnsPerHost"`
+	IdleConnTimeoutSec  int `json:"idleConnTimeoutSec"`
+	// Retry 重试策略
+	Retry *RetryConfig `json:"retry"`
+	// LbPolicy 负载均衡策略 为空时使用服务发现的负载均衡
+	LbPolicy string `json:"lbPolicy"`
+	// AuthSecret v1签名密钥
+	AuthSecret string `json:"authSecret"`
+	// AuthKeyId AuthKeySecret v2签名密钥
+	AuthKeyId     string `json:"authKeyId"`
+	AuthKeySecret string `json:"authKeySecret"`
+	// Headers 默认请求头
+	Headers map[string]string `json:"headers"`
+	// DiscoveryZone 服务发现区域
+	DiscoveryZone string `json:"discoveryZone"`
+	// Codec 请求编解码名称 默认json
+	Codec string `json:"codec"`
+	// Scheme http或https 默认http
+	Scheme string `json:"scheme"`
+	// Tls https证书配置
+	Tls *TlsConfig `json:"tls"`
+	// Hosts 静态实例列表host:port 配置后不使用服务发现
+	Hosts []string `json:"hosts"`
+	// Hedge 对冲请求策略
+	Hedge *HedgeConfig `json:"hedge"`
+	// Bulkhead 并发限制
+	Bulkhead *BulkheadConfig `json:"bulkhead"`
+}
+
+type BulkheadConfig struct {
+	MaxConcurrent  int `json:"maxConcurrent"`
+	MaxQueue       int `json:"maxQueue"`
+	QueueTimeoutMs int `json:"queueTimeoutMs"`
+}
+
+type HedgeConfig struct {
+	// DelayMs 为0时使用观测到的p95延迟
+	DelayMs   int     `json:"delayMs"`
+	MaxHedges int     `json:"maxHedges"`
+	MaxRatio  float64 `json:"maxRatio"`
+}
+
+type TlsConfig struct {
+	// CaFile 自定义ca证书
+	CaFile string `json:"caFile"`
+	// CertFile KeyFile 客户端证书 用于mTLS
+	CertFile           string `json:"certFile"`
+	KeyFile            string `json:"keyFile"`
+	ServerName         string `json:"serverName"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+}
+
+type RetryConfig struct {
+	MaxAttempts          int     `json:"maxAttempts"`
+	BaseDelayMs          int     `json:"baseDelayMs"`
+	MaxDelayMs           int     `json:"maxDelayMs"`
+	Jitter               float64 `json:"jitter"`
+	RetryableStatusCodes []int   `json:"retryableStatusCodes"`
+	RetryNonIdempotent   bool    `json:"retryNonIdempotent"`
+	BudgetMs             int     `json:"budgetMs"`
+}
+
+// transportConfig 连接相关配置 变化时才重建连接
+type transportConfig struct {
+	Http1               bool
+	ConnectTimeoutMs    int
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeoutSec  int
+	Https               bool
+	Tls                 TlsConfig
+	// tlsConfig Dial选项指定的tls配置 优先于Tls
+	tlsConfig *tls.Config
+}
+
+// transportConfig base为Dial选项 用于获取scheme和tls配置
+func (c *ServiceConfig) transportConfig(base *option) transportConfig {
+	ret := transportConfig{
+		Http1:               c.Http1,
+		ConnectTimeoutMs:    c.ConnectTimeoutMs,
+		MaxIdleConns:        c.MaxIdleConns,
+		MaxIdleConnsPerHost: c.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     c.MaxConnsPerHost,
+		IdleConnTimeoutSec:  c.IdleConnTimeoutSec,
+		Https:               c.Scheme == "https" || (c.Scheme == "" && base.scheme == "https"),
+		tlsConfig:           base.tlsConfig,
+	}
+	if c.Tls != nil {
+		ret.Tls = *c.Tls
+	}
+	return ret
+}
+
+// options 配置转化为选项
+func (c *ServiceConfig) options() []Option {
+	ret := make([]Option, 0)
+	if c.Scheme != "" {
+		ret = append(ret, WithScheme(c.Scheme))
+	}
+	if len(c.Hosts) > 0 {
+		ret = append(ret, WithStaticHosts(c.Hosts...))
+	}
+	if len(c.Headers) > 0 {
+		ret = append(ret, WithHeader(c.Headers))
+	}
+	if c.DiscoveryZone != "" {
+		ret = append(ret, WithDiscoveryZone(c.DiscoveryZone))
+	}
+	if c.LbPolicy != "" {
+		ret = append(ret, WithLbPolicy(lb.Policy(c.LbPolicy)))
+	}
+	if c.AuthKeyId != "" {
+		ret = append(ret, WithAuthKey(c.AuthKeyId, c.AuthKeySecret))
+	} else if c.AuthSecret != "" {
+		ret = append(ret, WithAuthSecret(c.AuthSecret))
+	}
+	if c.Codec != "" {
+		if codec, b := GetCodec(c.Codec); b {
+			ret = append(ret, WithCodec(codec))
+		} else {
+			logger.Logger.Errorf("httpclient unknown codec: %s", c.Codec)
+		}
+	}
+	if c.Hedge != nil {
+		ret = append(ret, WithHedgePolicy(HedgePolicy{
+			Delay:     time.Duration(c.Hedge.DelayMs) * time.Millisecond,
+			MaxHedges: c.Hedge.MaxHedges,
+			MaxRatio:  c.Hedge.MaxRatio,
+		}))
+	}
+	if c.Bulkhead != nil {
+		ret = append(ret, WithBulkhead(BulkheadPolicy{
+			MaxConcurrent: c.Bulkhead.MaxConcurrent,
+			MaxQueue:      c.Bulkhead.MaxQueue,
+			QueueTimeout:  time.Duration(c.Bulkhead.QueueTimeoutMs) * time.Millisecond,
+		}))
+	}
+	if c.Retry != nil {
+		ret = append(ret, WithRetryPolicy(RetryPolicy{
+			MaxAttempts:          c.Retry.MaxAttempts,
+			BaseDelay:            time.Duration(c.Retry.BaseDelayMs) * time.Millisecond,
+			MaxDelay:             time.Duration(c.Retry.MaxDelayMs) * time.Millisecond,
+			Jitter:               c.Retry.Jitter,
+			RetryableStatusCodes: c.Retry.RetryableStatusCodes,
+			RetryNonIdempotent:   c.Retry.RetryNonIdempotent,
+			Budget:               time.Duration(c.Retry.BudgetMs) * time.Millisecond,
+		}))
+	}
+	return ret
+}
+
+// loadServiceConfig 读取服务配置 动态配置覆盖静态配置
+func loadServiceConfig(serviceName string) ServiceConfig {
+	var ret ServiceConfig
+	if serviceName == "" {
+		return ret
+	}
+	mergeConfig(&ret, static.Get("httpclient.services."+serviceName))
+	if services := dynamicServices.Load(); services != nil {
+		mergeConfig(&ret, (*services)[strings.ToLower(serviceName)])
+	}
+	return ret
+}
+
+func mergeConfig(cfg *ServiceConfig, val any) {
+	if val == nil {
+		return
+	}
+	content, err := json.Marshal(val)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(content, cfg); err != nil {
+		logger.Logger.Errorf("httpclient read service config failed with err: %v", err)
+	}
+}
+
+// newHttpClient 连接配置未变化时复用transport
+func newHttpClient(cfg ServiceConfig, tc transportConfig, old *http.Client, oldCfg *transportConfig) (*http.Client, error) {
+	timeout := 30 * time.Second
+	if cfg.TimeoutMs > 0 {
+		timeout = time.Duration(cfg.TimeoutMs) * time.Millisecond
+	}
+	if old != nil && oldCfg != nil && *oldCfg == tc {
+		if old.Timeout == timeout {
+			return old, nil
+		}
+		return &http.Client{
+			Transport: old.Transport,
+			Timeout:   timeout,
+		}, nil
+	}
+	transport, err := newTransport(tc)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}, nil
+}
+
+func newTransport(cfg transportConfig) (http.RoundTripper, error) {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if cfg.ConnectTimeoutMs > 0 {
+		dialer.Timeout = time.Duration(cfg.ConnectTimeoutMs) * time.Millisecond
+	}
+	if !cfg.Http1 && !cfg.Https {
+		return &http2.Transport{
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			AllowHTTP:                  true,
+			StrictMaxConcurrentStreams: true,
+			ReadIdleTimeout:            5 * time.Second,
+			PingTimeout:                5 * time.Second,
+		}, nil
+	}
+	ret := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     100,
+		IdleConnTimeout:     time.Minute,
+	}
+	if cfg.Https {
+		tlsConfig, err := newTlsConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		ret.TLSClientConfig = tlsConfig
+		ret.ForceAttemptHTTP2 = !cfg.Http1
+	}
+	if cfg.MaxIdleConns > 0 {
+		ret.MaxIdleConns = cfg.MaxIdleConns
+	}
+	if cfg.MaxConnsPerHost > 0 {
+		ret.MaxConnsPerHost = cfg.MaxConnsPerHost
+	}
+	if cfg.IdleConnTimeoutSec > 0 {
+		ret.IdleConnTimeout = time.Duration(cfg.IdleConnTimeoutSec) * time.Second
+	}
+	return ret, nil
+}
+
+func newTlsConfig(cfg transportConfig) (*tls.Config, error) {
+	if cfg.tlsConfig != nil {
+		return cfg.tlsConfig.Clone(), nil
+	}
+	ret := &tls.Config{
+		ServerName:         cfg.Tls.ServerName,
+		InsecureSkipVerify: cfg.Tls.InsecureSkipVerify,
+	}
+	if cfg.Tls.CaFile != "" {
+		ca, err := os.ReadFile(cfg.Tls.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid ca file: %s", cfg.Tls.CaFile)
+		}
+		ret.RootCAs = pool
+	}
+	if cfg.Tls.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.Tls.CertFile, cfg.Tls.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		ret.Certificates = []tls.Certificate{cert}
+	}
+	return ret, nil
+}
+
+// clientState 服务配置生效后的状态
+type clientState struct {
+	config     ServiceConfig
+	transport  transportConfig
+	opts       []Option
+	httpClient *http.Client
+}
+
+// loadState 加载配置 返回是否有变化
+func (c *clientImpl) loadState() bool {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	cfg := loadServiceConfig(c.configName)
+	old := c.state.Load()
+	var (
+		oldClient *http.Client
+		oldCfg    *transportConfig
+	)
+	if old != nil {
+		if reflect.DeepEqual(old.config, cfg) {
+			return false
+		}
+		oldClient = old.httpClient
+		oldCfg = &old.transport
+	}
+	base := new(option)
+	for _, apply := range c.opts {
+		apply(base)
+	}
+	tc := cfg.transportConfig(base)
+	httpClient, err := newHttpClient(cfg, tc, oldClient, oldCfg)
+	if err != nil {
+		logger.Logger.Errorf("httpclient service: %s create transport failed with err: %v", c.ServiceName, err)
+		if old != nil {
+			return false
+		}
+		// 首次加载失败时使用默认连接配置
+		tc = transportConfig{}
+		httpClient, _ = newHttpClient(ServiceConfig{}, tc, nil, nil)
+	}
+	c.state.Store(&clientState{
+		config:     cfg,
+		transport:  tc,
+		opts:       cfg.options(),
+		httpClient: httpClient,
+	})
+	// transport重建 关闭旧的空闲连接
+	if oldClient != nil && oldClient.Transport != httpClient.Transport {
+		oldClient.CloseIdleConnections()
+	}
+	return true
+}
+
+func (c *clientImpl) getState() *clientState {
+	if state := c.state.Load(); state != nil {
+		return state
+	}
+	c.loadState()
+	return c.state.Load()
+}
+
+// listenDynamicConfig 监听动态配置 刷新已创建的client
+func listenDynamicConfig() {
+	key := static.GetString("httpclient.dynamicKey")
+	if key == "" {
+		key = defaultDynamicKey
+	}
+	content, b := dynamic.GetRawContent(key)
+	if b {
+		loadDynamicServices(key, content.Content)
+	}
+	dynamic.RegisterListener(key, func(eventType dynamic.EventType, content dynamic.Content) {
+		switch eventType {
+		case dynamic.PutEventType:
+			loadDynamicServices(key, content.Content)
+		case dynamic.DeleteEventType:
+			dynamicServices.Store(nil)
+		}
+		refreshClients()
+	})
+}
+
+func loadDynamicServices(key, content string) {
+	v := viper.New()
+	ext := path.Ext(key)
+	if len(ext) > 0 {
+		ext = ext[1:]
+	}
+	v.SetConfigType(ext)
+	if err := v.ReadConfig(bytes.NewReader([]byte(content))); err != nil {
+		logger.Logger.Errorf("httpclient read dynamic config: %s failed with err: %v", key, err)
+		return
+	}
+	services := v.GetStringMap("services")
+	dynamicServices.Store(&services)
+}
+
+func refreshClients() {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	for name, client := range clientCache {
+		if impl, ok := client.(*clientImpl); ok && impl.loadState() {
+			logger.Logger.Infof("httpclient service: %s config refreshed", name)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package httpclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/LeeZXin/zsf-utils/bizerr"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// 非2xx响应错误
+// 可注册错误解析器将响应体解析为业务错误 通过errors.As获取
+// 配置了错误解析器时 code非0的2xx响应同样解析为业务错误
+
+const (
+	// maxErrorBodySize 错误响应体最大保留长度
+	maxErrorBodySize = 4096
+)
+
+var (
+	errorDecoderMu = sync.RWMutex{}
+	errorDecoders  = make(map[string]ErrorDecoder, 8)
+)
+
+// ErrorDecoder 解析错误响应 返回nil表示无法解析
+type ErrorDecoder func(*HTTPError) error
+
+type HTTPError struct {
+	// StatusCode 响应码
+	StatusCode int
+	// Header 响应头
+	Header http.Header
+	// Body 响应体 超过4k截断
+	Body []byte
+	// Target 目标服务
+	Target string
+	// Instance 实例地址
+	Instance string
+	// Attempts 请求次数
+	Attempts int
+	// Err 解析出的业务错误
+	Err error
+}
+
+func (e *HTTPError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("request error with code: %d, target: %s, instance: %s, attempts: %d, err: %v",
+			e.StatusCode, e.Target, e.Instance, e.Attempts, e.Err)
+	}
+	return fmt.Sprintf("request error with code: %d, target: %s, instance: %s, attempts: %d",
+		e.StatusCode, e.Target, e.Instance, e.Attempts)
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// RegisterErrorDecoder 注册服务的错误解析器 serviceName为空时对所有服务生效
+func RegisterErrorDecoder(serviceName string, decoder ErrorDecoder) {
+	if decoder == nil {
+		return
+	}
+	errorDecoderMu.Lock()
+	defer errorDecoderMu.Unlock()
+	errorDecoders[serviceName] = decoder
+}
+
+func getErrorDecoder(serviceName string) ErrorDecoder {
+	errorDecoderMu.RLock()
+	defer errorDecoderMu.RUnlock()
+	if ret, b := errorDecoders[serviceName]; b {
+		return ret
+	}
+	return errorDecoders[""]
+}
+
+// BizErrorDecoder 解析zsf错误格式{"code":xx,"message":"xx"}为*bizerr.Err
+func BizErrorDecoder(e *HTTPError) error {
+	var body struct {
+		Code    *int   `json:"code"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(e.Body, &body) != nil || body.Code == nil {
+		return nil
+	}
+	return bizerr.NewBizErr(*body.Code, body.Message)
+}
+
+// hasBizErrCode 响应体是否为code非0的{"code":xx,"message":"xx"}
+func hasBizErrCode(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '{' {
+		return false
+	}
+	var envelope struct {
+		Code int `json:"code"`
+	}
+	return json.Unmarshal(body, &envelope) == nil && envelope.Code != 0
+}
+
+// newHTTPError 读取错误响应 使用调用选项或已注册的错误解析器解析业务错误
+func newHTTPError(resp *http.Response, target, instance string, attempts int, decoder ErrorDecoder) *HTTPError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	ret := &HTTPError{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+		Target:     target,
+		Instance:   instance,
+		Attempts:   attempts,
+	}
+	if decoder == nil {
+		decoder = getErrorDecoder(target)
+	}
+	if decoder != nil {
+		ret.Err = decoder(ret)
+	}
+	return ret
+}
@@ -0,0 +1,248 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"github.com/LeeZXin/zsf/prom"
+	"github.com/LeeZXin/zsf/services/discovery"
+	"github.com/LeeZXin/zsf/services/lb"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 对冲请求
+// 只作用于幂等、可重放且非流式的请求
+// 超过对冲延迟未返回时向其他实例发送相同请求 先成功的响应胜出 其余请求取消
+
+const (
+	latencyWindowSize  = 256
+	latencyMinSamples  = 20
+	hedgeMaxTokens     = 10
+	defaultHedgeRatio  = 0.1
+	hedgeSentEvent     = "sent"
+	hedgeWonEvent      = "won"
+	hedgeThrottleEvent = "throttled"
+)
+
+var (
+	errHedgeLost     = errors.New("hedged request lost")
+	errHeaderTimeout = errors.New("timeout awaiting response headers")
+)
+
+// HedgePolicy 对冲请求策略 只对GET、HEAD、OPTIONS生效
+// PUT、DELETE虽然幂等 但并发执行会和其他写请求交错 不发送对冲请求
+type HedgePolicy struct {
+	// Delay 发送对冲请求前的等待时间 为0时使用观测到的p95延迟
+	Delay time.Duration
+	// MaxHedges 最多对冲请求数 默认1
+	MaxHedges int
+	// MaxRatio 对冲请求占总请求的比例上限 默认0.1
+	MaxRatio float64
+}
+
+// isSafeMethod 没有副作用的请求 可以并发发送对冲请求
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *HedgePolicy) maxHedges() int {
+	if p.MaxHedges <= 0 {
+		return 1
+	}
+	return p.MaxHedges
+}
+
+func (p *HedgePolicy) maxRatio() float64 {
+	if p.MaxRatio <= 0 {
+		return defaultHedgeRatio
+	}
+	return p.MaxRatio
+}
+
+// hedgeState 服务级别的延迟统计和对冲限流
+type hedgeState struct {
+	mu        sync.Mutex
+	latencies [latencyWindowSize]time.Duration
+	index     int
+	count     int
+	p95       time.Duration
+	tokens    float64
+}
+
+// record 记录成功请求的延迟 每写满1/4窗口重新计算p95
+func (s *hedgeState) record(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latencies[s.index] = latency
+	s.index = (s.index + 1) % latencyWindowSize
+	if s.count < latencyWindowSize {
+		s.count++
+	}
+	if s.count >= latencyMinSamples && (s.p95 == 0 || s.index%(latencyWindowSize/4) == 0) {
+		samples := make([]time.Duration, s.count)
+		copy(samples, s.latencies[:s.count])
+		sort.Slice(samples, func(i, j int) bool {
+			return samples[i] < samples[j]
+		})
+		s.p95 = samples[s.count*95/100]
+	}
+}
+
+// delay 对冲延迟 样本不足时返回0不对冲
+func (s *hedgeState) delay(policy *HedgePolicy) time.Duration {
+	if policy.Delay > 0 {
+		return policy.Delay
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.p95
+}
+
+// addToken 每个请求增加ratio个令牌
+func (s *hedgeState) addToken(ratio float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens += ratio
+	if s.tokens > hedgeMaxTokens {
+		s.tokens = hedgeMaxTokens
+	}
+}
+
+// acquire 发送对冲请求消耗一个令牌
+func (s *hedgeState) acquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokens < 1 {
+		return false
+	}
+	s.tokens--
+	return true
+}
+
+type hedgeResult struct {
+	resp   *http.Response
+	err    error
+	server lb.Server
+	cancel context.CancelCauseFunc
+	hedged bool
+	index  int
+}
+
+func (r *hedgeResult) succeeded() bool {
+	return r.err == nil && r.resp.StatusCode < http.StatusInternalServerError
+}
+
+func (r *hedgeResult) release() {
+	if r.resp != nil {
+		r.resp.Body.Close()
+	}
+	r.cancel(errHedgeLost)
+}
+
+// doHedged 发送请求并按策略对冲 返回胜出的响应和对应的取消函数
+func (c *clientImpl) doHedged(ctx context.Context, first lb.Server, dis discovery.Discovery, opt *option, tried map[string]struct{},
+	roundTrip func(context.Context, lb.Server) (*http.Response, error)) (*http.Response, lb.Server, context.CancelCauseFunc, error) {
+	policy := opt.hedgePolicy
+	state := &c.hedge
+	state.addToken(policy.maxRatio())
+	results := make(chan *hedgeResult, policy.maxHedges()+1)
+	cancels := make([]context.CancelCauseFunc, 0, policy.maxHedges()+1)
+	launch := func(server lb.Server, hedged bool) {
+		reqCtx, cancel := context.WithCancelCause(ctx)
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			resp, err := roundTrip(reqCtx, server)
+			results <- &hedgeResult{
+				resp:   resp,
+				err:    err,
+				server: server,
+				cancel: cancel,
+				index:  index,
+				hedged: hedged,
+			}
+		}()
+	}
+	launch(first, false)
+	pending, hedges := 1, 0
+	var timerC <-chan time.Time
+	if delay := state.delay(policy); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		timerC = timer.C
+	}
+	var last *hedgeResult
+	// 取消并释放胜出请求以外的请求
+	drain := func(winner, n int) {
+		for i, cancel := range cancels {
+			if i != winner {
+				cancel(errHedgeLost)
+			}
+		}
+		go func() {
+			for i := 0; i < n; i++ {
+				(<-results).release()
+			}
+		}()
+	}
+	for {
+		select {
+		case result := <-results:
+			pending--
+			if result.succeeded() {
+				if last != nil {
+					last.release()
+				}
+				drain(result.index, pending)
+				if result.hedged {
+					prom.HttpClientHedgeTotal.WithLabelValues(c.ServiceName, hedgeWonEvent).Inc()
+				}
+				return result.resp, result.server, result.cancel, nil
+			}
+			// 失败时等待其他请求 全部失败返回最后的结果
+			if last != nil {
+				last.release()
+			}
+			last = result
+			if pending == 0 {
+				return last.resp, last.server, last.cancel, last.err
+			}
+		case <-timerC:
+			timerC = nil
+			if hedges >= policy.maxHedges() {
+				continue
+			}
+			server, err := c.chooseServer(ctx, dis, opt, tried)
+			if err != nil {
+				continue
+			}
+			if _, b := tried[serverAddr(server)]; b {
+				// 没有其他实例 不对冲
+				continue
+			}
+			if !state.acquire() {
+				prom.HttpClientHedgeTotal.WithLabelValues(c.ServiceName, hedgeThrottleEvent).Inc()
+				continue
+			}
+			tried[serverAddr(server)] = struct{}{}
+			launch(server, true)
+			pending++
+			hedges++
+			prom.HttpClientHedgeTotal.WithLabelValues(c.ServiceName, hedgeSentEvent).Inc()
+			if hedges < policy.maxHedges() {
+				if delay := state.delay(policy); delay > 0 {
+					timer := time.NewTimer(delay)
+					defer timer.Stop()
+					timerC = timer.C
+				}
+			}
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package httpclient
 
 import (
-	"github.com/LeeZXin/zsf-utils/httputil"
+	"fmt"
 	"github.com/LeeZXin/zsf-utils/quit"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 )
 
@@ -21,7 +24,11 @@ func init() {
 	//注册拦截器
 	RegisterInterceptors(
 		promInterceptor(),
+		traceInterceptor(),
+		LogInterceptor(),
 	)
+	// 监听动态配置
+	listenDynamicConfig()
 	//关闭所有的连接
 	quit.AddShutdownHook(func() {
 		cacheMu.Lock()
@@ -32,21 +39,66 @@ func init() {
 	})
 }
 
-// Dial 获取服务的client
-func Dial(serviceName string) Client {
+// Dial 获取服务的client opts为服务级别选项 仅首次Dial生效
+// httpclient.services.<name>配置优先于opts 动态配置变化时实时生效
+func Dial(serviceName string, opts ...Option) Client {
 	cacheMu.Lock()
 	defer cacheMu.Unlock()
 	client, ok := clientCache[serviceName]
 	if ok {
 		return client
 	}
-	client = &clientImpl{
+	impl := &clientImpl{
 		ServiceName:  serviceName,
 		Interceptors: getInterceptors(),
-		httpClient:   httputil.NewHttp2Client(),
+		configName:   serviceName,
+		opts:         opts,
 	}
-	clientCache[serviceName] = client
-	return client
+	// 加载httpclient.services.<name>配置
+	impl.loadState()
+	clientCache[serviceName] = impl
+	return impl
+}
+
+// DialURL 获取直连地址的client 如https://api.example.com/v1
+// 不使用服务发现和服务配置 target为域名 请求路径拼接在baseURL的路径之后
+func DialURL(baseURL string, opts ...Option) (Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("empty host: %s", baseURL)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "https" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	client, ok := clientCache[baseURL]
+	if ok {
+		return client, nil
+	}
+	impl := &clientImpl{
+		ServiceName:  u.Hostname(),
+		Interceptors: getInterceptors(),
+		opts: append([]Option{
+			WithScheme(u.Scheme),
+			WithStaticHosts(host),
+			withBasePath(strings.TrimSuffix(u.Path, "/")),
+		}, opts...),
+	}
+	impl.loadState()
+	clientCache[baseURL] = impl
+	return impl, nil
 }
 
 // 拦截器wrapper 实现类似洋葱递归执行功能
@@ -1,11 +1,19 @@
 package httpclient
 
 import (
+	"bytes"
+	"fmt"
+	"github.com/LeeZXin/zsf-utils/idutil"
 	"github.com/LeeZXin/zsf/common"
+	"github.com/LeeZXin/zsf/http/httplog"
+	"github.com/LeeZXin/zsf/logger"
 	"github.com/LeeZXin/zsf/prom"
 	"github.com/LeeZXin/zsf/rpcheader"
+	"github.com/LeeZXin/zsf/tracing"
+	"io"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -25,16 +33,177 @@ func promInterceptor() Interceptor {
 	}
 }
 
+// traceInterceptor 记录客户端span 每次尝试一个span 以子span向下游传递调用链
+func traceInterceptor() Interceptor {
+	return func(request *http.Request, invoker Invoker) (*http.Response, error) {
+		ctx, span := tracing.StartSpan(request.Context(), request.Method+" "+request.URL.Path, tracing.ClientKind)
+		if span == nil {
+			return invoker(request)
+		}
+		defer span.End()
+		rpcheader.InjectSpanContext(ctx, request.Header.Set)
+		span.SetAttribute("http.method", request.Method)
+		span.SetAttribute("http.url", request.URL.Path)
+		span.SetAttribute("server.address", request.URL.Host)
+		if target := request.Header.Get(rpcheader.Target); target != "" {
+			span.SetAttribute("peer.service", target)
+		}
+		response, err := invoker(request.WithContext(ctx))
+		if err != nil {
+			span.SetError(err)
+			return response, err
+		}
+		span.SetAttribute("http.status_code", strconv.Itoa(response.StatusCode))
+		if response.StatusCode >= http.StatusInternalServerError {
+			span.SetError(fmt.Errorf("response code: %d", response.StatusCode))
+		}
+		return response, err
+	}
+}
+
+// LogInterceptor 请求响应调试日志 通过httplog动态配置按目标服务开启 响应体关闭时输出
+func LogInterceptor() Interceptor {
+	return func(request *http.Request, invoker Invoker) (*http.Response, error) {
+		target := request.Header.Get(rpcheader.Target)
+		if !httplog.ClientEnabled(target) {
+			return invoker(request)
+		}
+		reqCapture := httplog.NewCapture()
+		if request.GetBody != nil {
+			if body, err := request.GetBody(); err == nil {
+				_, _ = io.Copy(reqCapture, body)
+				body.Close()
+			}
+		}
+		begin := time.Now()
+		response, err := invoker(request)
+		if err != nil {
+			logger.Logger.WithContext(request.Context()).Infof("httplog client target: %s, %s %s, cost: %v, request header: %s, request body: %s, err: %v",
+				target, request.Method, httplog.RedactURL(request.URL), time.Since(begin),
+				httplog.RedactHeader(request.Header), reqCapture.String(request.Header.Get("Content-Type")), err)
+			return response, err
+		}
+		respCapture := httplog.NewCapture()
+		response.Body = &logBody{
+			ReadCloser: response.Body,
+			capture:    respCapture,
+			log: func() {
+				logger.Logger.WithContext(request.Context()).Infof("httplog client target: %s, %s %s, status: %d, cost: %v, request header: %s, request body: %s, response header: %s, response body: %s",
+					target, request.Method, httplog.RedactURL(request.URL), response.StatusCode, time.Since(begin),
+					httplog.RedactHeader(request.Header), reqCapture.String(request.Header.Get("Content-Type")),
+					httplog.RedactHeader(response.Header), respCapture.String(response.Header.Get("Content-Type")))
+			},
+		}
+		return response, nil
+	}
+}
+
+// logBody 记录读取的响应体 关闭时输出日志
+type logBody struct {
+	io.ReadCloser
+	capture *httplog.Capture
+	log     func()
+	once    sync.Once
+}
+
+func (b *logBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		_, _ = b.capture.Write(p[:n])
+	}
+	return n, err
+}
+
+func (b *logBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.log)
+	return err
+}
+
 func AuthInterceptor(getSecret func(string) string) Interceptor {
 	return func(request *http.Request, invoker Invoker) (*http.Response, error) {
 		secret := getSecret(request.Header.Get(rpcheader.Target))
-		now := time.Now().Unix()
-		sign, err := common.GenAuthSign(secret, now)
-		if err != nil {
+		if err := signRequestV1(request, secret, time.Now().Unix()); err != nil {
 			return nil, err
 		}
-		request.Header.Set(rpcheader.AuthTs, strconv.FormatInt(now, 10))
-		request.Header.Set(rpcheader.AuthSign, sign)
 		return invoker(request)
 	}
 }
+
+// AuthInterceptorV2 v2签名 getKey根据target返回keyId和secret
+func AuthInterceptorV2(getKey func(string) (string, string)) Interceptor {
+	return func(request *http.Request, invoker Invoker) (*http.Response, error) {
+		keyId, secret := getKey(request.Header.Get(rpcheader.Target))
+		if err := signRequestV2(request, keyId, secret); err != nil {
+			return nil, err
+		}
+		return invoker(request)
+	}
+}
+
+func signRequestV1(request *http.Request, secret string, ts int64) error {
+	sign, err := common.GenAuthSign(secret, ts)
+	if err != nil {
+		return err
+	}
+	request.Header.Del(rpcheader.AuthVersion)
+	request.Header.Del(rpcheader.AuthKeyId)
+	request.Header.Del(rpcheader.AuthNonce)
+	request.Header.Set(rpcheader.AuthTs, strconv.FormatInt(ts, 10))
+	request.Header.Set(rpcheader.AuthSign, sign)
+	return nil
+}
+
+func signRequestV2(request *http.Request, keyId, secret string) error {
+	body, err := peekRequestBody(request)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	nonce := idutil.RandomUuid()
+	sign, err := common.GenAuthSignV2(secret, common.AuthSignV2Content{
+		KeyId:     keyId,
+		Source:    request.Header.Get(rpcheader.Source),
+		Method:    request.Method,
+		Path:      request.URL.Path,
+		RawQuery:  request.URL.RawQuery,
+		BodyHash:  common.HashAuthBody(body),
+		Timestamp: now,
+		Nonce:     nonce,
+	})
+	if err != nil {
+		return err
+	}
+	request.Header.Set(rpcheader.AuthVersion, common.AuthSignV2)
+	request.Header.Set(rpcheader.AuthKeyId, keyId)
+	request.Header.Set(rpcheader.AuthNonce, nonce)
+	request.Header.Set(rpcheader.AuthTs, strconv.FormatInt(now, 10))
+	request.Header.Set(rpcheader.AuthSign, sign)
+	return nil
+}
+
+// peekRequestBody 读取body用于签名 并重置request body
+func peekRequestBody(request *http.Request) ([]byte, error) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return nil, nil
+	}
+	if request.GetBody != nil {
+		reader, err := request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	}
+	body, err := io.ReadAll(request.Body)
+	request.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	request.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	request.ContentLength = int64(len(body))
+	return body, nil
+}
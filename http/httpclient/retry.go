@@ -0,0 +1,115 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"github.com/LeeZXin/zsf-utils/backoffutil"
+	"net/http"
+	"time"
+)
+
+// 重试策略
+// 默认只重试幂等请求 每次重试选择不同的实例
+
+var (
+	defaultRetryableStatusCodes = []int{
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}
+)
+
+type RetryPolicy struct {
+	// MaxAttempts 最大请求次数 包括首次请求
+	MaxAttempts int
+	// BaseDelay 首次重试等待时间 默认50ms
+	BaseDelay time.Duration
+	// MaxDelay 最大等待时间 默认1s
+	MaxDelay time.Duration
+	// Jitter 抖动比例 默认0.2
+	Jitter float64
+	// RetryableStatusCodes 可重试的响应码 默认502、503、504
+	RetryableStatusCodes []int
+	// RetryNonIdempotent 是否重试POST、PATCH等非幂等请求
+	RetryNonIdempotent bool
+	// Budget 所有请求和等待的总耗时上限 0不限制
+	Budget time.Duration
+}
+
+func (p *RetryPolicy) maxAttempts(method string) int {
+	if p == nil || p.MaxAttempts <= 1 {
+		return 1
+	}
+	if !p.RetryNonIdempotent && !isIdempotent(method) {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+func (p *RetryPolicy) backoff(retries int) time.Duration {
+	cfg := backoffutil.Config{
+		BaseDelay:  p.BaseDelay,
+		Multiplier: 2,
+		Jitter:     p.Jitter,
+		MaxDelay:   p.MaxDelay,
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = 50 * time.Millisecond
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = time.Second
+	}
+	if cfg.Jitter <= 0 {
+		cfg.Jitter = 0.2
+	}
+	return (&backoffutil.Exponential{Config: cfg}).Backoff(retries - 1)
+}
+
+func (p *RetryPolicy) isRetryableStatus(code int) bool {
+	codes := p.RetryableStatusCodes
+	if len(codes) == 0 {
+		codes = defaultRetryableStatusCodes
+	}
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// isRetryableErr 上下文取消、超时或被sentinel拒绝不重试
+func isRetryableErr(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !isBlockErr(err)
+}
+
+// canWaitBackoff 等待后超过context deadline则不重试
+func canWaitBackoff(ctx context.Context, wait time.Duration) bool {
+	if deadline, b := ctx.Deadline(); b && time.Until(deadline) <= wait {
+		return false
+	}
+	return ctx.Err() == nil
+}
+
+func waitBackoff(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,156 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/LeeZXin/zsf/rpcheader"
+	sentinel "github.com/alibaba/sentinel-golang/api"
+	"github.com/alibaba/sentinel-golang/core/base"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// 出口流控熔断
+// 资源名为目标服务 开启withPath且调用时通过WithResource指定路由模板时 同时以目标服务:路由模板作为资源
+// 不使用原始路径 避免路径中的id产生无限的资源 规则通过sentinel-flow.json、sentinel-circuitbreaker.json配置
+// 请求失败和5xx响应计为异常 被拒绝时使用注册的降级函数 未注册返回*base.BlockError
+// 降级响应没有请求实例 不上报异常检测也不重试
+
+var (
+	fallbackMu = sync.RWMutex{}
+	fallbacks  = make(map[string]Fallback, 8)
+)
+
+// Fallback 降级函数 返回降级的响应
+type Fallback func(*http.Request, *base.BlockError) (*http.Response, error)
+
+// RegisterFallback 注册资源的降级函数 resource为目标服务或目标服务:路由模板
+func RegisterFallback(resource string, fallback Fallback) {
+	if fallback == nil {
+		return
+	}
+	fallbackMu.Lock()
+	defer fallbackMu.Unlock()
+	fallbacks[resource] = fallback
+}
+
+func getFallback(resources ...string) Fallback {
+	fallbackMu.RLock()
+	defer fallbackMu.RUnlock()
+	for _, resource := range resources {
+		if ret, b := fallbacks[resource]; b {
+			return ret
+		}
+	}
+	return nil
+}
+
+// JsonFallback 返回固定json响应的降级函数
+func JsonFallback(statusCode int, v any) Fallback {
+	body, err := json.Marshal(v)
+	return func(request *http.Request, _ *base.BlockError) (*http.Response, error) {
+		if err != nil {
+			return nil, err
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+			StatusCode:    statusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": []string{JsonContentType}},
+			Body:          io.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       request,
+		}, nil
+	}
+}
+
+type resourceCtxKey struct{}
+
+// withResource 传递路由模板给拦截器
+func withResource(ctx context.Context, resource string) context.Context {
+	return context.WithValue(ctx, resourceCtxKey{}, resource)
+}
+
+func getResource(ctx context.Context) string {
+	ret, _ := ctx.Value(resourceCtxKey{}).(string)
+	return ret
+}
+
+type fallbackCtxKey struct{}
+
+// withFallbackFlag 记录请求是否返回了降级响应
+func withFallbackFlag(ctx context.Context) (context.Context, *atomic.Bool) {
+	flag := new(atomic.Bool)
+	return context.WithValue(ctx, fallbackCtxKey{}, flag), flag
+}
+
+// markFallback 标记返回了降级响应
+func markFallback(ctx context.Context) {
+	if flag, b := ctx.Value(fallbackCtxKey{}).(*atomic.Bool); b {
+		flag.Store(true)
+	}
+}
+
+func sentinelResource(target, resource string) string {
+	return target + ":" + resource
+}
+
+// SentinelInterceptor 出口流控熔断拦截器 withPath为true时对指定了WithResource的请求同时按目标服务:路由模板统计
+func SentinelInterceptor(withPath bool) Interceptor {
+	return func(request *http.Request, invoker Invoker) (*http.Response, error) {
+		target := request.Header.Get(rpcheader.Target)
+		if target == "" {
+			return invoker(request)
+		}
+		entries := make([]*base.SentinelEntry, 0, 2)
+		defer func() {
+			for i := len(entries) - 1; i >= 0; i-- {
+				entries[i].Exit()
+			}
+		}()
+		resources := []string{target}
+		if resource := getResource(request.Context()); withPath && resource != "" {
+			resources = append(resources, sentinelResource(target, resource))
+		}
+		for _, resource := range resources {
+			entry, blockErr := sentinel.Entry(resource,
+				sentinel.WithTrafficType(base.Outbound),
+				sentinel.WithResourceType(base.ResTypeWeb),
+			)
+			if blockErr != nil {
+				// 优先使用接口级别的降级函数
+				fallback := getFallback(resources[len(resources)-1], target)
+				if fallback != nil {
+					markFallback(request.Context())
+					return fallback(request, blockErr)
+				}
+				return nil, blockErr
+			}
+			entries = append(entries, entry)
+		}
+		response, err := invoker(request)
+		traceErr := err
+		if err == nil && response.StatusCode >= http.StatusInternalServerError {
+			traceErr = fmt.Errorf("response code: %d", response.StatusCode)
+		}
+		if traceErr != nil {
+			for _, entry := range entries {
+				sentinel.TraceError(entry, traceErr)
+			}
+		}
+		return response, err
+	}
+}
+
+// isBlockErr 被sentinel拒绝
+func isBlockErr(err error) bool {
+	var blockErr *base.BlockError
+	return errors.As(err, &blockErr)
+}
@@ -0,0 +1,228 @@
+package httpclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// 流式请求
+// 响应体不缓存 超时时间只作用于等待响应头 读取时间由ctx控制
+
+const (
+	defaultMaxResponseSize = 1024 * 1024 * 10
+	EventStreamContentType = "text/event-stream"
+)
+
+var (
+	ErrResponseTooLarge = errors.New("response body too large")
+)
+
+type StreamResponse struct {
+	StatusCode int
+	Header     http.Header
+	// Body 响应体 读取完毕后需关闭以释放连接
+	Body io.ReadCloser
+}
+
+func (r *StreamResponse) Close() error {
+	return r.Body.Close()
+}
+
+// Events 按text/event-stream格式读取事件
+func (r *StreamResponse) Events() *SseReader {
+	return NewSseReader(r.Body)
+}
+
+func (c *clientImpl) Stream(ctx context.Context, method, path string, req any, opts ...Option) (*StreamResponse, error) {
+	result, err := c.execute(ctx, path, method, req, true, opts)
+	if err != nil {
+		return nil, fmt.Errorf("transport: %s with err: %w", c.ServiceName, err)
+	}
+	resp := result.resp
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("transport: %s with err: %w", c.ServiceName, result.httpError(resp))
+	}
+	body := resp.Body
+	if result.opt.maxResponseSize > 0 {
+		body = &readCloser{
+			Reader: newLimitReader(resp.Body, result.opt.maxResponseSize),
+			Closer: resp.Body,
+		}
+	}
+	return &StreamResponse{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	}, nil
+}
+
+// SseEvent text/event-stream事件
+type SseEvent struct {
+	Id    string
+	Event string
+	Data  string
+	// Retry 重连时间 毫秒
+	Retry int
+}
+
+// Decode json解析事件数据
+func (e *SseEvent) Decode(v any) error {
+	return json.Unmarshal([]byte(e.Data), v)
+}
+
+// SseReader 事件迭代器 非并发安全
+type SseReader struct {
+	reader *bufio.Reader
+	lastId string
+}
+
+func NewSseReader(reader io.Reader) *SseReader {
+	return &SseReader{
+		reader: bufio.NewReader(reader),
+	}
+}
+
+// Next 读取下一个事件 流结束返回io.EOF
+func (r *SseReader) Next() (*SseEvent, error) {
+	var (
+		event   SseEvent
+		data    strings.Builder
+		hasData bool
+	)
+	for {
+		line, err := r.reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		// 空行分发事件
+		if line == "" {
+			if !hasData {
+				event = SseEvent{}
+				continue
+			}
+			event.Id = r.lastId
+			event.Data = data.String()
+			return &event, nil
+		}
+		// 注释
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event.Event = value
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+			hasData = true
+		case "id":
+			if !strings.Contains(value, "\x00") {
+				r.lastId = value
+			}
+		case "retry":
+			if retry, err := strconv.Atoi(value); err == nil {
+				event.Retry = retry
+			}
+		}
+	}
+}
+
+// LastEventId 最近收到的事件id 用于断线重连
+func (r *SseReader) LastEventId() string {
+	return r.lastId
+}
+
+// writeProxyResponse 透传响应 事件流和未知长度的响应每次写入后刷新
+func writeProxyResponse(c *gin.Context, resp *http.Response) error {
+	header := c.Writer.Header()
+	for k, values := range resp.Header {
+		for _, v := range values {
+			header.Add(k, v)
+		}
+	}
+	c.Status(resp.StatusCode)
+	c.Writer.WriteHeaderNow()
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	flush := mediaType == EventStreamContentType || resp.ContentLength == -1
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := c.Writer.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if flush {
+				c.Writer.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// releaseBody 关闭响应体时释放请求资源
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// limitReader 超过长度返回ErrResponseTooLarge
+type limitReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func newLimitReader(reader io.Reader, size int64) io.Reader {
+	return &limitReader{
+		reader:    reader,
+		remaining: size,
+	}
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		// 读一个字节判断是否结束
+		var b [1]byte
+		n, err := r.reader.Read(b[:])
+		if n > 0 {
+			return 0, ErrResponseTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	return n, err
+}
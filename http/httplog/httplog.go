@@ -0,0 +1,260 @@
+package httplog
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/LeeZXin/zsf/logger"
+	"github.com/LeeZXin/zsf/property/dynamic"
+	"github.com/LeeZXin/zsf/property/static"
+	"github.com/LeeZXin/zsf/rpcheader"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// 请求响应调试日志
+// httpclient按目标服务开启 httpserver按路由开启 通过动态配置httplog.json在排查问题时临时打开
+// 日志中的敏感请求头和json、表单、url query字段脱敏 请求体和响应体按长度截断
+
+const (
+	defaultDynamicKey  = "httplog.json"
+	defaultMaxBodySize = 2048
+	redactedValue      = "***"
+	// All 对所有服务或路由开启
+	All = "*"
+)
+
+var (
+	defaultRedactHeaders = []string{rpcheader.AuthSign, "Authorization", "Cookie", "Set-Cookie"}
+	defaultRedactFields  = []string{"password", "idCard", "token", "accessToken", "secret", "apiKey"}
+
+	current atomic.Pointer[config]
+)
+
+type Config struct {
+	// Services httpclient开启日志的目标服务
+	Services []string `json:"services"`
+	// Routes httpserver开启日志的路由 如/api/user/get 以*结尾时前缀匹配
+	Routes []string `json:"routes"`
+	// SampleRate 采样率 0到1 默认1
+	SampleRate float64 `json:"sampleRate"`
+	// MaxBodySize 请求体和响应体最大记录长度 默认2048
+	MaxBodySize int `json:"maxBodySize"`
+	// RedactHeaders 脱敏请求头 默认包含Z-Auth-Sign、Authorization、Cookie、Set-Cookie
+	RedactHeaders []string `json:"redactHeaders"`
+	// RedactFields 脱敏字段 同时作用于请求体和url query 默认包含password、idCard、token、accessToken、secret、apiKey
+	RedactFields []string `json:"redactFields"`
+}
+
+type config struct {
+	services      map[string]struct{}
+	routes        []string
+	sampleRate    float64
+	maxBodySize   int
+	redactHeaders map[string]struct{}
+	redactFields  map[string]struct{}
+	jsonPattern   *regexp.Regexp
+}
+
+func init() {
+	SetConfig(Config{})
+	key := static.GetString("httplog.dynamicKey")
+	if key == "" {
+		key = defaultDynamicKey
+	}
+	content, b := dynamic.GetRawContent(key)
+	if b {
+		loadConfig(content.Content)
+	}
+	dynamic.RegisterListener(key, func(eventType dynamic.EventType, content dynamic.Content) {
+		switch eventType {
+		case dynamic.PutEventType:
+			loadConfig(content.Content)
+		case dynamic.DeleteEventType:
+			SetConfig(Config{})
+		}
+	})
+}
+
+func loadConfig(content string) {
+	var cfg Config
+	if err := json.Unmarshal([]byte(content), &cfg); err != nil {
+		logger.Logger.Errorf("httplog read dynamic config failed with err: %v", err)
+		return
+	}
+	SetConfig(cfg)
+	logger.Logger.Infof("httplog config refreshed, services: %v, routes: %v", cfg.Services, cfg.Routes)
+}
+
+// SetConfig 替换日志配置
+func SetConfig(cfg Config) {
+	ret := &config{
+		services:      make(map[string]struct{}, len(cfg.Services)),
+		routes:        cfg.Routes,
+		sampleRate:    cfg.SampleRate,
+		maxBodySize:   cfg.MaxBodySize,
+		redactHeaders: make(map[string]struct{}),
+		redactFields:  make(map[string]struct{}),
+	}
+	if ret.sampleRate <= 0 || ret.sampleRate > 1 {
+		ret.sampleRate = 1
+	}
+	if ret.maxBodySize <= 0 {
+		ret.maxBodySize = defaultMaxBodySize
+	}
+	for _, service := range cfg.Services {
+		ret.services[service] = struct{}{}
+	}
+	for _, header := range append(defaultRedactHeaders, cfg.RedactHeaders...) {
+		ret.redactHeaders[http.CanonicalHeaderKey(header)] = struct{}{}
+	}
+	fields := make([]string, 0, len(defaultRedactFields)+len(cfg.RedactFields))
+	for _, field := range append(defaultRedactFields, cfg.RedactFields...) {
+		ret.redactFields[strings.ToLower(field)] = struct{}{}
+		fields = append(fields, regexp.QuoteMeta(field))
+	}
+	// 匹配"field": value 截断的json也能脱敏
+	ret.jsonPattern = regexp.MustCompile(`(?i)("(?:` + strings.Join(fields, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`)
+	current.Store(ret)
+}
+
+func sampled(rate float64) bool {
+	return rate >= 1 || rand.Float64() < rate
+}
+
+// ClientEnabled 目标服务是否记录日志 包含采样
+func ClientEnabled(target string) bool {
+	cfg := current.Load()
+	if len(cfg.services) == 0 {
+		return false
+	}
+	_, b := cfg.services[target]
+	if !b {
+		_, b = cfg.services[All]
+	}
+	return b && sampled(cfg.sampleRate)
+}
+
+// ServerEnabled 路由是否记录日志 包含采样
+func ServerEnabled(route string) bool {
+	cfg := current.Load()
+	for _, pattern := range cfg.routes {
+		if pattern == All || pattern == route ||
+			(strings.HasSuffix(pattern, "*") && strings.HasPrefix(route, strings.TrimSuffix(pattern, "*"))) {
+			return sampled(cfg.sampleRate)
+		}
+	}
+	return false
+}
+
+// RedactHeader 格式化请求头 脱敏请求头的值替换为***
+func RedactHeader(header http.Header) string {
+	cfg := current.Load()
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(k)
+		sb.WriteString(": ")
+		if _, b := cfg.redactHeaders[http.CanonicalHeaderKey(k)]; b {
+			sb.WriteString(redactedValue)
+		} else {
+			sb.WriteString(strings.Join(header[k], ","))
+		}
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+// RedactURL 格式化url query中的脱敏字段和密码替换为***
+func RedactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	ret := *u
+	if ret.RawQuery != "" {
+		ret.RawQuery = redactForm(current.Load(), ret.RawQuery)
+	}
+	return ret.Redacted()
+}
+
+// RedactBody 格式化请求体 json和表单脱敏 非文本内容只记录长度
+func RedactBody(contentType string, body []byte, total int64) string {
+	if total == 0 {
+		return ""
+	}
+	cfg := current.Load()
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	var ret string
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		ret = redactForm(cfg, string(body))
+	case mediaType == "" || isText(mediaType):
+		ret = cfg.jsonPattern.ReplaceAllString(string(body), `${1}"`+redactedValue+`"`)
+	default:
+		return fmt.Sprintf("[%s %d bytes]", mediaType, total)
+	}
+	if total > int64(len(body)) {
+		ret += fmt.Sprintf("...(%d bytes)", total)
+	}
+	return ret
+}
+
+func isText(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "json") ||
+		strings.HasSuffix(mediaType, "xml")
+}
+
+func redactForm(cfg *config, body string) string {
+	pairs := strings.Split(body, "&")
+	for i, pair := range pairs {
+		key, _, _ := strings.Cut(pair, "=")
+		if name, err := url.QueryUnescape(key); err == nil {
+			if _, b := cfg.redactFields[strings.ToLower(name)]; b {
+				pairs[i] = key + "=" + redactedValue
+			}
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// Capture 记录读写内容的前MaxBodySize个字节
+type Capture struct {
+	buf   []byte
+	max   int
+	total int64
+}
+
+func NewCapture() *Capture {
+	return &Capture{
+		max: current.Load().maxBodySize,
+	}
+}
+
+func (c *Capture) Write(p []byte) (int, error) {
+	c.total += int64(len(p))
+	if n := c.max - len(c.buf); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		c.buf = append(c.buf, p[:n]...)
+	}
+	return len(p), nil
+}
+
+func (c *Capture) String(contentType string) string {
+	return RedactBody(contentType, c.buf, c.total)
+}
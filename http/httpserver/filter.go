@@ -1,15 +1,17 @@
 package httpserver
 
 import (
-	"github.com/LeeZXin/zsf-utils/idutil"
+	"fmt"
 	"github.com/LeeZXin/zsf-utils/threadutil"
-	"github.com/LeeZXin/zsf/common"
+	"github.com/LeeZXin/zsf/http/httplog"
 	"github.com/LeeZXin/zsf/logger"
 	"github.com/LeeZXin/zsf/prom"
 	"github.com/LeeZXin/zsf/rpcheader"
+	"github.com/LeeZXin/zsf/tracing"
 	sentinel "github.com/alibaba/sentinel-golang/api"
 	"github.com/alibaba/sentinel-golang/core/base"
 	"github.com/gin-gonic/gin"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -18,6 +20,11 @@ import (
 
 //常见filter封装
 
+const (
+	trustedRequestKey  = "zsf-trusted-request"
+	untrustedHeaderKey = "zsf-untrusted-header"
+)
+
 // recoverFilter recover封装
 func recoverFilter() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -44,6 +51,75 @@ func promFilter() gin.HandlerFunc {
 	}
 }
 
+// logFilter 请求响应调试日志 通过httplog动态配置按路由开启
+func logFilter() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		if !httplog.ServerEnabled(route) {
+			c.Next()
+			return
+		}
+		reqCapture := httplog.NewCapture()
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			c.Request.Body = &captureBody{
+				ReadCloser: c.Request.Body,
+				capture:    reqCapture,
+			}
+		}
+		writer := &captureWriter{
+			ResponseWriter: c.Writer,
+			capture:        httplog.NewCapture(),
+		}
+		c.Writer = writer
+		begin := time.Now()
+		c.Next()
+		logger.Logger.WithContext(c.Request.Context()).Infof("httplog server route: %s, %s %s, status: %d, cost: %v, request header: %s, request body: %s, response header: %s, response body: %s",
+			route, c.Request.Method, httplog.RedactURL(c.Request.URL), writer.Status(), time.Since(begin),
+			httplog.RedactHeader(c.Request.Header), reqCapture.String(c.Request.Header.Get("Content-Type")),
+			httplog.RedactHeader(writer.Header()), writer.capture.String(writer.Header().Get("Content-Type")))
+	}
+}
+
+// captureBody 记录handler读取的请求体
+type captureBody struct {
+	io.ReadCloser
+	capture *httplog.Capture
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		_, _ = b.capture.Write(p[:n])
+	}
+	return n, err
+}
+
+// captureWriter 记录写入的响应体
+type captureWriter struct {
+	gin.ResponseWriter
+	capture *httplog.Capture
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	_, _ = w.capture.Write(p[:n])
+	return n, err
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	n, err := w.ResponseWriter.WriteString(s)
+	_, _ = w.capture.Write([]byte(s[:n]))
+	return n, err
+}
+
+// Unwrap 供http.ResponseController使用 如sse设置写超时和flush
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func WithSentinel(resource string, invoke gin.HandlerFunc) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		entry, err := sentinel.Entry(resource, sentinel.WithTrafficType(base.Inbound))
@@ -57,22 +133,52 @@ func WithSentinel(resource string, invoke gin.HandlerFunc) gin.HandlerFunc {
 	}
 }
 
-// headerFilter 传递header
+// headerFilter 传递header 解析调用链并创建当前服务的span
+// 不可信来源的Z-header按rpcheader.TrustPolicy过滤 并从请求中删除 避免handler读到伪造的控制类header
 func headerFilter() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if c.Request.Header.Get(rpcheader.TraceId) == "" {
-			c.Request.Header.Set(rpcheader.TraceId, idutil.RandomUuid())
+		headers := CopyRequestHeader(c)
+		ctx := rpcheader.SetHeaders(c.Request.Context(), headers)
+		ctx = rpcheader.StartSpan(ctx, c.Request.Header.Get)
+		if !IsTrustedRequest(c) {
+			stripRequestHeader(c, headers)
 		}
-		clone := CopyRequestHeader(c)
-		ctx := rpcheader.SetHeaders(c.Request.Context(), clone)
-		ctx = logger.AppendToMDC(ctx, map[string]string{
-			logger.TraceId: clone.Get(rpcheader.TraceId),
-		})
+		c.Request.Header.Set(rpcheader.TraceId, rpcheader.GetHeaders(ctx).Get(rpcheader.TraceId))
 		c.Request = c.Request.WithContext(ctx)
 		c.Next()
 	}
 }
 
+// traceFilter 记录服务端span
+func traceFilter() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		ctx, span := tracing.StartCurrentSpan(c.Request.Context(), c.Request.Method+" "+route, tracing.ServerKind)
+		if span == nil {
+			c.Next()
+			return
+		}
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+		span.SetAttribute("http.method", c.Request.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.target", c.Request.URL.Path)
+		span.SetAttribute("net.peer.ip", c.ClientIP())
+		c.Next()
+		status := c.Writer.Status()
+		span.SetAttribute("http.status_code", strconv.Itoa(status))
+		if len(c.Errors) > 0 {
+			span.SetError(c.Errors.Last())
+		} else if status >= http.StatusInternalServerError {
+			span.SetError(fmt.Errorf("response code: %d", status))
+		}
+	}
+}
+
+// CopyRequestHeader 复制请求中的Z-header 来源不可信时删除控制类header
 func CopyRequestHeader(c *gin.Context) rpcheader.Header {
 	clone := make(rpcheader.Header, len(c.Request.Header))
 	for key := range c.Request.Header {
@@ -80,40 +186,69 @@ func CopyRequestHeader(c *gin.Context) rpcheader.Header {
 			clone[key] = c.Request.Header.Get(key)
 		}
 	}
-	return clone
+	return rpcheader.GetTrustPolicy().FilterHeaders(clone, IsTrustedRequest(c))
 }
 
-func ValidateClientAuthSign(getSecret func(string) string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		sign := c.GetHeader(rpcheader.AuthSign)
-		if sign == "" {
-			c.String(http.StatusUnauthorized, "empty sign")
-			c.Abort()
-			return
-		}
-		secret := getSecret(c.GetHeader(rpcheader.Source))
-		if secret == "" {
-			c.String(http.StatusInternalServerError, "get auth sign key failed")
-			c.Abort()
-			return
-		}
-		ts, _ := strconv.ParseInt(c.GetHeader(rpcheader.AuthTs), 10, 64)
-		if time.Since(time.Unix(ts, 0)) > time.Hour {
-			c.String(http.StatusUnauthorized, "timestamp is not within one hour from current time")
-			c.Abort()
-			return
+// IsTrustedRequest 请求来源是否可信 直连地址可信、mTLS校验通过或v2签名校验通过
+func IsTrustedRequest(c *gin.Context) bool {
+	if c.GetBool(trustedRequestKey) {
+		return true
+	}
+	policy := rpcheader.GetTrustPolicy()
+	if policy.TrustMTLS && c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	return policy.IsTrustedAddr(c.RemoteIP())
+}
+
+// stripRequestHeader 请求中的Z-header替换为过滤后的header 原始header保留在gin.Context中用于签名校验
+func stripRequestHeader(c *gin.Context, headers rpcheader.Header) {
+	raw := make(http.Header)
+	for key, values := range c.Request.Header {
+		if strings.HasPrefix(key, rpcheader.Prefix) {
+			raw[key] = values
+			c.Request.Header.Del(key)
 		}
-		newSign, err := common.GenAuthSign(secret, ts)
-		if err != nil {
-			c.String(http.StatusInternalServerError, "get auth sign key failed")
-			c.Abort()
-			return
+	}
+	for key, value := range headers {
+		c.Request.Header.Set(key, value)
+	}
+	c.Set(untrustedHeaderKey, raw)
+}
+
+// rawRequestHeader 获取请求原始的header 不受不可信来源过滤影响
+func rawRequestHeader(c *gin.Context, key string) string {
+	if raw, b := c.Get(untrustedHeaderKey); b {
+		return raw.(http.Header).Get(key)
+	}
+	return c.GetHeader(key)
+}
+
+// trustRequest v2签名校验通过后标记为可信 恢复原始header并重新复制
+func trustRequest(c *gin.Context) {
+	if IsTrustedRequest(c) {
+		return
+	}
+	c.Set(trustedRequestKey, true)
+	if raw, b := c.Get(untrustedHeaderKey); b {
+		for key := range c.Request.Header {
+			if strings.HasPrefix(key, rpcheader.Prefix) && key != rpcheader.TraceId {
+				c.Request.Header.Del(key)
+			}
 		}
-		if newSign != sign {
-			c.String(http.StatusUnauthorized, "auth failed")
-			c.Abort()
-			return
+		for key, values := range raw.(http.Header) {
+			if key != rpcheader.TraceId {
+				c.Request.Header[key] = values
+			}
 		}
-		c.Next()
 	}
+	ctx := rpcheader.AppendToHeaders(c.Request.Context(), CopyRequestHeader(c))
+	c.Request = c.Request.WithContext(ctx)
+}
+
+// ValidateClientAuthSign 校验服务间调用签名 v1和v2均支持
+func ValidateClientAuthSign(getSecret func(string) string, opts ...AuthSignOption) gin.HandlerFunc {
+	return ValidateClientAuthSignV2(func(source, _ string) string {
+		return getSecret(source)
+	}, opts...)
 }
@@ -0,0 +1,78 @@
+package httpserver
+
+import (
+	"github.com/LeeZXin/zsf/http/httplog"
+	"github.com/LeeZXin/zsf/logger/loggertest"
+	"github.com/LeeZXin/zsf/rpcheader"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHeaderFilter_Trust(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	e := gin.New()
+	e.Use(headerFilter())
+	var (
+		headers    rpcheader.Header
+		reqHeaders http.Header
+	)
+	e.GET("/", func(c *gin.Context) {
+		headers = rpcheader.GetHeaders(c.Request.Context())
+		reqHeaders = c.Request.Header.Clone()
+	})
+	tests := []struct {
+		remoteAddr string
+		trusted    bool
+	}{
+		{remoteAddr: "8.8.8.8:1234"},
+		{remoteAddr: "127.0.0.1:1234", trusted: true},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = test.remoteAddr
+		req.Header.Set(rpcheader.Source, "admin")
+		req.Header.Set(rpcheader.ApiVersion, "gray")
+		req.Header.Set(rpcheader.TraceId, "abc")
+		e.ServeHTTP(httptest.NewRecorder(), req)
+		if headers.Get(rpcheader.TraceId) != "abc" {
+			t.Fatalf("unexpected trace id: %s", headers.Get(rpcheader.TraceId))
+		}
+		if (headers.Get(rpcheader.ApiVersion) == "gray") != test.trusted || (headers.Get(rpcheader.Source) == "admin") != test.trusted {
+			t.Fatalf("unexpected headers from %s: %v", test.remoteAddr, headers)
+		}
+		if (reqHeaders.Get(rpcheader.ApiVersion) == "gray") != test.trusted || (reqHeaders.Get(rpcheader.Source) == "admin") != test.trusted {
+			t.Fatalf("unexpected request headers from %s: %v", test.remoteAddr, reqHeaders)
+		}
+		if reqHeaders.Get(rpcheader.TraceId) != "abc" {
+			t.Fatalf("unexpected request trace id: %s", reqHeaders.Get(rpcheader.TraceId))
+		}
+	}
+}
+
+func TestLogFilter_ResponseController(t *testing.T) {
+	loggertest.NewHook(t)
+	httplog.SetConfig(httplog.Config{Routes: []string{"/sse"}})
+	defer httplog.SetConfig(httplog.Config{})
+	gin.SetMode(gin.ReleaseMode)
+	e := gin.New()
+	e.Use(logFilter())
+	var deadlineErr error
+	e.GET("/sse", func(c *gin.Context) {
+		// 记录响应体时仍可通过ResponseController设置写超时
+		deadlineErr = http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(time.Second))
+		c.String(http.StatusOK, "data: x\n\n")
+	})
+	server := httptest.NewServer(e)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/sse?token=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if deadlineErr != nil {
+		t.Fatalf("unexpected set write deadline err: %v", deadlineErr)
+	}
+}
@@ -0,0 +1,154 @@
+package httpserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/LeeZXin/zsf/logger"
+	"math/big"
+)
+
+// jwks解析 支持RSA(RS256)、EC(P-256)、oct三种key
+// 身份提供方的jwks可能包含其他算法的key 不支持的key跳过 没有可用的key时加载失败
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// oct
+	K string `json:"k"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwtKey struct {
+	kid string
+	alg string
+	key any
+}
+
+type jwtKeySet struct {
+	keys []jwtKey
+}
+
+// find 根据kid和alg查找key kid为空时返回所有alg匹配的key
+func (s *jwtKeySet) find(kid, alg string) []jwtKey {
+	if s == nil {
+		return nil
+	}
+	ret := make([]jwtKey, 0, 1)
+	for _, k := range s.keys {
+		if k.alg != alg {
+			continue
+		}
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		ret = append(ret, k)
+	}
+	return ret
+}
+
+func parseJwks(content []byte) (*jwtKeySet, error) {
+	var set jwkSet
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, err
+	}
+	ret := &jwtKeySet{
+		keys: make([]jwtKey, 0, len(set.Keys)),
+	}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, alg, err := parseJwk(k)
+		if err != nil {
+			logger.Logger.Warnf("skip jwk %s: %v", k.Kid, err)
+			continue
+		}
+		if k.Alg != "" && k.Alg != alg {
+			logger.Logger.Warnf("skip jwk %s: unsupported alg %s for kty %s", k.Kid, k.Alg, k.Kty)
+			continue
+		}
+		ret.keys = append(ret.keys, jwtKey{
+			kid: k.Kid,
+			alg: alg,
+			key: key,
+		})
+	}
+	if len(ret.keys) == 0 {
+		return nil, errors.New("no usable jwk")
+	}
+	return ret, nil
+}
+
+func parseJwk(k jwk) (any, string, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBase64Url(k.N)
+		if err != nil {
+			return nil, "", err
+		}
+		e, err := decodeBase64Url(k.E)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(n) == 0 || len(e) == 0 {
+			return nil, "", errors.New("empty rsa modulus or exponent")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, JwtRS256, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, "", fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := decodeBase64Url(k.X)
+		if err != nil {
+			return nil, "", err
+		}
+		y, err := decodeBase64Url(k.Y)
+		if err != nil {
+			return nil, "", err
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, "", errors.New("invalid ec point")
+		}
+		return pub, JwtES256, nil
+	case "oct":
+		secret, err := decodeBase64Url(k.K)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(secret) == 0 {
+			return nil, "", errors.New("empty oct key")
+		}
+		return secret, JwtHS256, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported kty: %s", k.Kty)
+	}
+}
+
+func decodeBase64Url(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -0,0 +1,433 @@
+package httpserver
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"github.com/LeeZXin/zsf-utils/quit"
+	"github.com/LeeZXin/zsf-utils/taskutil"
+	"github.com/LeeZXin/zsf/common"
+	"github.com/LeeZXin/zsf/logger"
+	"github.com/LeeZXin/zsf/property/dynamic"
+	"github.com/gin-gonic/gin"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// jwt鉴权filter
+// 支持RS256、ES256、HS256 key来自本地jwks文件或动态配置
+
+const (
+	JwtRS256 = "RS256"
+	JwtES256 = "ES256"
+	JwtHS256 = "HS256"
+
+	defaultJwtTenantClaim = "tenant"
+)
+
+var (
+	ErrJwtMalformed       = errors.New("malformed jwt")
+	ErrJwtUnsupportedAlg  = errors.New("unsupported jwt alg")
+	ErrJwtKeyNotFound     = errors.New("jwt key not found")
+	ErrJwtInvalidSign     = errors.New("invalid jwt signature")
+	ErrJwtExpired         = errors.New("jwt is expired")
+	ErrJwtMissingExp      = errors.New("jwt exp is missing")
+	ErrJwtNotValidYet     = errors.New("jwt is not valid yet")
+	ErrJwtInvalidIssuer   = errors.New("invalid jwt issuer")
+	ErrJwtInvalidAudience = errors.New("invalid jwt audience")
+)
+
+type jwtClaimsKey struct{}
+
+// JwtAudience aud可以是字符串或字符串数组
+type JwtAudience []string
+
+func (a *JwtAudience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = JwtAudience{s}
+		return nil
+	}
+	var arr []string
+	if err := json.Unmarshal(data, &arr); err != nil {
+		return err
+	}
+	*a = arr
+	return nil
+}
+
+// JwtClaims jwt标准字段和scope、tenant
+type JwtClaims struct {
+	Issuer    string      `json:"iss"`
+	Subject   string      `json:"sub"`
+	Audience  JwtAudience `json:"aud"`
+	ExpiresAt int64       `json:"exp"`
+	NotBefore int64       `json:"nbf"`
+	IssuedAt  int64       `json:"iat"`
+	Id        string      `json:"jti"`
+	Scope     string      `json:"scope"`
+	Scp       []string    `json:"scp"`
+	// Tenant 租户 由tenantClaim决定字段名
+	Tenant string `json:"-"`
+	// Raw 原始claims
+	Raw map[string]any `json:"-"`
+}
+
+// Scopes 合并scope和scp
+func (c *JwtClaims) Scopes() []string {
+	ret := make([]string, 0, len(c.Scp))
+	ret = append(ret, strings.Fields(c.Scope)...)
+	ret = append(ret, c.Scp...)
+	return ret
+}
+
+// HasScope 是否有该scope
+func (c *JwtClaims) HasScope(scope string) bool {
+	for _, s := range c.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// GetJwtClaims 从context获取jwt claims
+func GetJwtClaims(ctx context.Context) (*JwtClaims, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	ret, b := ctx.Value(jwtClaimsKey{}).(*JwtClaims)
+	return ret, b
+}
+
+type jwtOption struct {
+	jwksFile        string
+	jwksDynamicKey  string
+	issuers         []string
+	audiences       []string
+	leeway          time.Duration
+	tenantClaim     string
+	refreshInterval time.Duration
+	allowMissingExp bool
+}
+
+type JwtOption func(*jwtOption)
+
+// WithJwksFile 本地jwks文件 相对resources目录
+func WithJwksFile(path string) JwtOption {
+	return func(o *jwtOption) {
+		o.jwksFile = path
+	}
+}
+
+// WithJwksDynamicKey 动态配置的key 内容为jwks json
+func WithJwksDynamicKey(key string) JwtOption {
+	return func(o *jwtOption) {
+		o.jwksDynamicKey = key
+	}
+}
+
+func WithJwtIssuers(issuers ...string) JwtOption {
+	return func(o *jwtOption) {
+		o.issuers = issuers
+	}
+}
+
+func WithJwtAudiences(audiences ...string) JwtOption {
+	return func(o *jwtOption) {
+		o.audiences = audiences
+	}
+}
+
+// WithJwtLeeway exp、nbf允许的时间偏差
+func WithJwtLeeway(leeway time.Duration) JwtOption {
+	return func(o *jwtOption) {
+		o.leeway = leeway
+	}
+}
+
+// WithJwtAllowMissingExp 允许没有exp的token 默认拒绝
+func WithJwtAllowMissingExp() JwtOption {
+	return func(o *jwtOption) {
+		o.allowMissingExp = true
+	}
+}
+
+// WithJwtTenantClaim 租户字段名 默认tenant
+func WithJwtTenantClaim(claim string) JwtOption {
+	return func(o *jwtOption) {
+		o.tenantClaim = claim
+	}
+}
+
+// WithJwksRefreshInterval 本地jwks文件检查更新间隔
+func WithJwksRefreshInterval(interval time.Duration) JwtOption {
+	return func(o *jwtOption) {
+		o.refreshInterval = interval
+	}
+}
+
+// JwtVerifier jwt校验
+type JwtVerifier struct {
+	opt     *jwtOption
+	keySet  atomic.Pointer[jwtKeySet]
+	modTime time.Time
+}
+
+// NewJwtVerifier 初始化jwt校验 加载jwks并监听变化
+func NewJwtVerifier(opts ...JwtOption) *JwtVerifier {
+	opt := new(jwtOption)
+	for _, apply := range opts {
+		apply(opt)
+	}
+	if opt.tenantClaim == "" {
+		opt.tenantClaim = defaultJwtTenantClaim
+	}
+	if opt.refreshInterval <= 0 {
+		opt.refreshInterval = time.Minute
+	}
+	v := &JwtVerifier{
+		opt: opt,
+	}
+	if opt.jwksFile != "" {
+		path := filepath.Join(common.ResourcesDir, opt.jwksFile)
+		v.loadJwksFile(path)
+		stopFunc, _ := taskutil.RunPeriodicalTask(opt.refreshInterval, opt.refreshInterval, func(context.Context) {
+			v.loadJwksFile(path)
+		})
+		quit.AddShutdownHook(func() {
+			stopFunc()
+		})
+	}
+	if opt.jwksDynamicKey != "" {
+		content, b := dynamic.GetRawContent(opt.jwksDynamicKey)
+		if b {
+			v.loadJwks([]byte(content.Content))
+		}
+		dynamic.RegisterListener(opt.jwksDynamicKey, func(eventType dynamic.EventType, content dynamic.Content) {
+			if eventType == dynamic.PutEventType {
+				v.loadJwks([]byte(content.Content))
+			}
+		})
+	}
+	return v
+}
+
+func (v *JwtVerifier) loadJwksFile(path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		logger.Logger.Errorf("read jwks file: %s failed with err: %v", path, err)
+		return
+	}
+	if info.ModTime().Equal(v.modTime) {
+		return
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		logger.Logger.Errorf("read jwks file: %s failed with err: %v", path, err)
+		return
+	}
+	if v.loadJwks(content) {
+		v.modTime = info.ModTime()
+	}
+}
+
+func (v *JwtVerifier) loadJwks(content []byte) bool {
+	set, err := parseJwks(content)
+	if err != nil {
+		logger.Logger.Errorf("parse jwks failed with err: %v", err)
+		return false
+	}
+	v.keySet.Store(set)
+	logger.Logger.Infof("load jwks successfully, key size: %d", len(set.keys))
+	return true
+}
+
+// Verify 校验token并返回claims
+func (v *JwtVerifier) Verify(token string) (*JwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJwtMalformed
+	}
+	headerBytes, err := decodeBase64Url(parts[0])
+	if err != nil {
+		return nil, ErrJwtMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err = json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, ErrJwtMalformed
+	}
+	switch header.Alg {
+	case JwtRS256, JwtES256, JwtHS256:
+	default:
+		return nil, ErrJwtUnsupportedAlg
+	}
+	sig, err := decodeBase64Url(parts[2])
+	if err != nil {
+		return nil, ErrJwtMalformed
+	}
+	keys := v.keySet.Load().find(header.Kid, header.Alg)
+	if len(keys) == 0 {
+		return nil, ErrJwtKeyNotFound
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, k := range keys {
+		if verifyJwtSign(header.Alg, k.key, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrJwtInvalidSign
+	}
+	payload, err := decodeBase64Url(parts[1])
+	if err != nil {
+		return nil, ErrJwtMalformed
+	}
+	claims := new(JwtClaims)
+	if err = json.Unmarshal(payload, claims); err != nil {
+		return nil, ErrJwtMalformed
+	}
+	if err = json.Unmarshal(payload, &claims.Raw); err != nil {
+		return nil, ErrJwtMalformed
+	}
+	if tenant, b := claims.Raw[v.opt.tenantClaim].(string); b {
+		claims.Tenant = tenant
+	}
+	if err = v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *JwtVerifier) validateClaims(claims *JwtClaims) error {
+	now := time.Now()
+	leeway := v.opt.leeway
+	if claims.ExpiresAt <= 0 {
+		if !v.opt.allowMissingExp {
+			return ErrJwtMissingExp
+		}
+	} else if now.After(time.Unix(claims.ExpiresAt, 0).Add(leeway)) {
+		return ErrJwtExpired
+	}
+	if claims.NotBefore > 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-leeway)) {
+		return ErrJwtNotValidYet
+	}
+	if len(v.opt.issuers) > 0 && !containsString(v.opt.issuers, claims.Issuer) {
+		return ErrJwtInvalidIssuer
+	}
+	if len(v.opt.audiences) > 0 {
+		found := false
+		for _, aud := range claims.Audience {
+			if containsString(v.opt.audiences, aud) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrJwtInvalidAudience
+		}
+	}
+	return nil
+}
+
+func verifyJwtSign(alg string, key any, signed, sig []byte) bool {
+	hash := sha256.Sum256(signed)
+	switch alg {
+	case JwtRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], sig) == nil
+	case JwtES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, hash[:], r, s)
+	case JwtHS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		h := hmac.New(sha256.New, secret)
+		h.Write(signed)
+		return hmac.Equal(h.Sum(nil), sig)
+	default:
+		return false
+	}
+}
+
+func containsString(arr []string, s string) bool {
+	for _, a := range arr {
+		if a == s {
+			return true
+		}
+	}
+	return false
+}
+
+// JwtAuth jwt鉴权filter 校验通过后claims放入context和MDC
+func JwtAuth(verifier *JwtVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auth := c.GetHeader("Authorization")
+		if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+			c.String(http.StatusUnauthorized, "empty token")
+			c.Abort()
+			return
+		}
+		claims, err := verifier.Verify(strings.TrimSpace(auth[7:]))
+		if err != nil {
+			c.String(http.StatusUnauthorized, err.Error())
+			c.Abort()
+			return
+		}
+		ctx := context.WithValue(c.Request.Context(), jwtClaimsKey{}, claims)
+		mdc := map[string]string{
+			logger.Subject: claims.Subject,
+		}
+		if claims.Tenant != "" {
+			mdc[logger.Tenant] = claims.Tenant
+		}
+		ctx = logger.AppendToMDC(ctx, mdc)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// RequireScopes 路由级别scope校验 需在JwtAuth之后
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, b := GetJwtClaims(c.Request.Context())
+		if !b {
+			c.String(http.StatusUnauthorized, "unauthorized")
+			c.Abort()
+			return
+		}
+		for _, scope := range scopes {
+			if !claims.HasScope(scope) {
+				c.String(http.StatusForbidden, "insufficient scope")
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,245 @@
+package httpserver
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/LeeZXin/zsf/logger"
+	"github.com/LeeZXin/zsf/logger/loggertest"
+	"github.com/gin-gonic/gin"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func signTestJwt(t *testing.T, header, claims map[string]any, sign func([]byte) []byte) string {
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func TestJwtVerifier_HS256(t *testing.T) {
+	loggertest.NewHook(t)
+	secret := []byte("zsf-secret")
+	v := NewJwtVerifier(WithJwtIssuers("zsf"), WithJwtAudiences("api"))
+	v.loadJwks([]byte(fmt.Sprintf(`{"keys":[{"kty":"oct","kid":"k1","k":"%s"}]}`, base64.RawURLEncoding.EncodeToString(secret))))
+	hs := func(b []byte) []byte {
+		h := hmac.New(sha256.New, secret)
+		h.Write(b)
+		return h.Sum(nil)
+	}
+	token := signTestJwt(t, map[string]any{"alg": "HS256", "kid": "k1"}, map[string]any{
+		"iss":    "zsf",
+		"aud":    []string{"api"},
+		"sub":    "u1",
+		"tenant": "t1",
+		"scope":  "read write",
+		"exp":    time.Now().Add(time.Minute).Unix(),
+	}, hs)
+	claims, err := v.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "u1" || claims.Tenant != "t1" || !claims.HasScope("write") {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+	expired := signTestJwt(t, map[string]any{"alg": "HS256", "kid": "k1"}, map[string]any{
+		"iss": "zsf",
+		"aud": "api",
+		"exp": time.Now().Add(-time.Minute).Unix(),
+	}, hs)
+	if _, err = v.Verify(expired); err != ErrJwtExpired {
+		t.Fatalf("expect expired but got: %v", err)
+	}
+}
+
+func TestJwtVerifier_ES256(t *testing.T) {
+	loggertest.NewHook(t)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	v := NewJwtVerifier()
+	v.loadJwks([]byte(fmt.Sprintf(`{"keys":[{"kty":"EC","crv":"P-256","kid":"e1","x":"%s","y":"%s"}]}`,
+		base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	)))
+	es := func(b []byte) []byte {
+		hash := sha256.Sum256(b)
+		r, s, _ := ecdsa.Sign(rand.Reader, key, hash[:])
+		return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	token := signTestJwt(t, map[string]any{"alg": "ES256", "kid": "e1"}, map[string]any{
+		"sub": "u2",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}, es)
+	if _, err := v.Verify(token); err != nil {
+		t.Fatal(err)
+	}
+	// 没有exp默认拒绝
+	noExp := signTestJwt(t, map[string]any{"alg": "ES256", "kid": "e1"}, map[string]any{"sub": "u2"}, es)
+	if _, err := v.Verify(noExp); err != ErrJwtMissingExp {
+		t.Fatalf("expect missing exp but got: %v", err)
+	}
+	allowed := NewJwtVerifier(WithJwtAllowMissingExp())
+	allowed.keySet.Store(v.keySet.Load())
+	if _, err := allowed.Verify(noExp); err != nil {
+		t.Fatal(err)
+	}
+	// 使用HS256伪造alg不能通过
+	forged := signTestJwt(t, map[string]any{"alg": "HS256", "kid": "e1"}, map[string]any{"sub": "u2"}, func(b []byte) []byte {
+		return b
+	})
+	if _, err := v.Verify(forged); err != ErrJwtKeyNotFound {
+		t.Fatalf("expect key not found but got: %v", err)
+	}
+}
+
+func rsaTestJwk(kid string, key *rsa.PrivateKey) string {
+	return fmt.Sprintf(`{"kty":"RSA","kid":"%s","use":"sig","n":"%s","e":"%s"}`, kid,
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	)
+}
+
+func signTestRS256(t *testing.T, kid string, key *rsa.PrivateKey, claims map[string]any) string {
+	return signTestJwt(t, map[string]any{"alg": "RS256", "kid": kid}, claims, func(b []byte) []byte {
+		hash := sha256.Sum256(b)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	})
+}
+
+func TestJwtVerifier_RS256(t *testing.T) {
+	loggertest.NewHook(t)
+	key1, _ := rsa.GenerateKey(rand.Reader, 2048)
+	key2, _ := rsa.GenerateKey(rand.Reader, 2048)
+	v := NewJwtVerifier()
+	if !v.loadJwks([]byte(`{"keys":[` + rsaTestJwk("r1", key1) + `]}`)) {
+		t.Fatal("load jwks failed")
+	}
+	claims := map[string]any{"sub": "u3", "exp": time.Now().Add(time.Minute).Unix()}
+	token1 := signTestRS256(t, "r1", key1, claims)
+	if _, err := v.Verify(token1); err != nil {
+		t.Fatal(err)
+	}
+	// kid对应的公钥不匹配
+	if _, err := v.Verify(signTestRS256(t, "r1", key2, claims)); err != ErrJwtInvalidSign {
+		t.Fatalf("expect invalid sign but got: %v", err)
+	}
+	token2 := signTestRS256(t, "r2", key2, claims)
+	if _, err := v.Verify(token2); err != ErrJwtKeyNotFound {
+		t.Fatalf("expect key not found but got: %v", err)
+	}
+	// 轮换密钥 新旧kid同时生效
+	if !v.loadJwks([]byte(`{"keys":[` + rsaTestJwk("r1", key1) + `,` + rsaTestJwk("r2", key2) + `]}`)) {
+		t.Fatal("load jwks failed")
+	}
+	for _, token := range []string{token1, token2} {
+		if _, err := v.Verify(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 移除旧kid
+	if !v.loadJwks([]byte(`{"keys":[` + rsaTestJwk("r2", key2) + `]}`)) {
+		t.Fatal("load jwks failed")
+	}
+	if _, err := v.Verify(token1); err != ErrJwtKeyNotFound {
+		t.Fatalf("expect key not found but got: %v", err)
+	}
+}
+
+func TestParseJwks_SkipUnsupported(t *testing.T) {
+	loggertest.NewHook(t)
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	v := NewJwtVerifier()
+	content := `{"keys":[
+		{"kty":"RSA","kid":"r384","alg":"RS384","n":"AQAB","e":"AQAB"},
+		{"kty":"OKP","kid":"ed","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+		{"kty":"EC","kid":"p384","crv":"P-384","x":"AQAB","y":"AQAB"},
+		` + rsaTestJwk("r1", key) + `
+	]}`
+	if !v.loadJwks([]byte(content)) {
+		t.Fatal("load jwks failed")
+	}
+	token := signTestRS256(t, "r1", key, map[string]any{"exp": time.Now().Add(time.Minute).Unix()})
+	if _, err := v.Verify(token); err != nil {
+		t.Fatal(err)
+	}
+	// 没有可用的key时保留原有的key
+	if v.loadJwks([]byte(`{"keys":[{"kty":"OKP","kid":"ed","crv":"Ed25519","x":"AQAB"}]}`)) {
+		t.Fatal("expect load jwks failed")
+	}
+	if _, err := v.Verify(token); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJwtAuth(t *testing.T) {
+	loggertest.NewHook(t)
+	gin.SetMode(gin.ReleaseMode)
+	secret := []byte("zsf-secret")
+	v := NewJwtVerifier()
+	v.loadJwks([]byte(fmt.Sprintf(`{"keys":[{"kty":"oct","kid":"k1","k":"%s"}]}`, base64.RawURLEncoding.EncodeToString(secret))))
+	token := signTestJwt(t, map[string]any{"alg": "HS256", "kid": "k1"}, map[string]any{
+		"sub":    "u1",
+		"tenant": "t1",
+		"scope":  "read",
+		"exp":    time.Now().Add(time.Minute).Unix(),
+	}, func(b []byte) []byte {
+		h := hmac.New(sha256.New, secret)
+		h.Write(b)
+		return h.Sum(nil)
+	})
+	e := gin.New()
+	e.Use(JwtAuth(v))
+	var (
+		subject string
+		mdc     logger.MDC
+	)
+	handler := func(c *gin.Context) {
+		claims, _ := GetJwtClaims(c.Request.Context())
+		subject = claims.Subject
+		mdc = logger.GetMDC(c.Request.Context())
+	}
+	e.GET("/read", RequireScopes("read"), handler)
+	e.GET("/write", RequireScopes("read", "write"), handler)
+	tests := []struct {
+		path  string
+		token string
+		code  int
+	}{
+		{path: "/read", code: http.StatusUnauthorized},
+		{path: "/read", token: token + "x", code: http.StatusUnauthorized},
+		{path: "/write", token: token, code: http.StatusForbidden},
+		{path: "/read", token: token, code: http.StatusOK},
+	}
+	for _, test := range tests {
+		subject, mdc = "", nil
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		if w.Code != test.code {
+			t.Fatalf("path: %s, expect code %d but got %d", test.path, test.code, w.Code)
+		}
+		if (subject != "") != (test.code == http.StatusOK) {
+			t.Fatalf("path: %s, unexpected handler call, subject: %s", test.path, subject)
+		}
+	}
+	if subject != "u1" || mdc.Get(logger.Subject) != "u1" || mdc.Get(logger.Tenant) != "t1" {
+		t.Fatalf("unexpected subject: %s, mdc: %v", subject, mdc)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"github.com/LeeZXin/zsf/common"
+	"github.com/LeeZXin/zsf/env"
+	"github.com/LeeZXin/zsf/http/openapi"
 	"github.com/LeeZXin/zsf/logger"
 	"github.com/LeeZXin/zsf/property/static"
 	"github.com/LeeZXin/zsf/services/registry"
@@ -319,6 +321,14 @@ func (s *Server) enableActuator(r *gin.Engine) {
 		}
 		c.String(http.StatusOK, "")
 	})
+	// openapi文档
+	r.GET("/actuator/openapi.json", func(c *gin.Context) {
+		title := s.opt.applicationName
+		if title == "" {
+			title = common.GetApplicationName()
+		}
+		c.JSON(http.StatusOK, openapi.Build(title, env.GetVersion(), r.Routes()))
+	})
 	r.Any("/actuator/v1/markAsDownServer", func(c *gin.Context) {
 		action := s.GetRegistryAction()
 		if action != nil {
@@ -426,7 +436,9 @@ func NewDefaultServer(opts ...Option) *Server {
 		AddFilters(
 			recoverFilter(),
 			headerFilter(),
+			traceFilter(),
 			promFilter(),
+			logFilter(),
 		),
 	}
 	opts = append(defaultOpts, opts...)
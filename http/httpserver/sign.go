@@ -0,0 +1,281 @@
+package httpserver
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/hmac"
+	"github.com/LeeZXin/zsf/common"
+	"github.com/LeeZXin/zsf/rpcheader"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 服务间调用签名校验
+// v1仅对时间戳签名 v2对source、method、path、query、body、时间戳和nonce签名
+// 迁移期间v1和v2同时支持
+
+const (
+	defaultAuthClockSkew     = 5 * time.Minute
+	defaultAuthMaxBodySize   = 10 * 1024 * 1024
+	defaultNonceCacheMaxSize = 100000
+)
+
+// NonceCache nonce缓存 用于防重放
+type NonceCache interface {
+	// Add nonce未出现过返回true
+	Add(nonce string, ttl time.Duration) bool
+}
+
+type nonceEntry struct {
+	nonce    string
+	expireAt time.Time
+}
+
+// memNonceCache 按写入顺序淘汰 缓存满时淘汰最早的nonce
+type memNonceCache struct {
+	sync.Mutex
+	cache   map[string]*list.Element
+	queue   *list.List
+	maxSize int
+}
+
+// NewMemNonceCache 内存nonce缓存 maxSize应大于时间窗口内的请求数 被淘汰的nonce无法判断重放
+func NewMemNonceCache(maxSize int) NonceCache {
+	if maxSize <= 0 {
+		maxSize = defaultNonceCacheMaxSize
+	}
+	return &memNonceCache{
+		cache:   make(map[string]*list.Element, 1024),
+		queue:   list.New(),
+		maxSize: maxSize,
+	}
+}
+
+func (c *memNonceCache) Add(nonce string, ttl time.Duration) bool {
+	c.Lock()
+	defer c.Unlock()
+	now := time.Now()
+	c.purge(now)
+	if elem, b := c.cache[nonce]; b {
+		if elem.Value.(*nonceEntry).expireAt.After(now) {
+			return false
+		}
+		c.remove(elem)
+	}
+	// 缓存满时淘汰最早的nonce 不能拒绝请求
+	for c.queue.Len() >= c.maxSize {
+		c.remove(c.queue.Front())
+	}
+	c.cache[nonce] = c.queue.PushBack(&nonceEntry{
+		nonce:    nonce,
+		expireAt: now.Add(ttl),
+	})
+	return true
+}
+
+// purge 从最早写入的开始删除过期nonce
+func (c *memNonceCache) purge(now time.Time) {
+	for elem := c.queue.Front(); elem != nil && !elem.Value.(*nonceEntry).expireAt.After(now); elem = c.queue.Front() {
+		c.remove(elem)
+	}
+}
+
+func (c *memNonceCache) remove(elem *list.Element) {
+	c.queue.Remove(elem)
+	delete(c.cache, elem.Value.(*nonceEntry).nonce)
+}
+
+type authSignOption struct {
+	clockSkew   time.Duration
+	nonceCache  NonceCache
+	disableV1   bool
+	maxBodySize int64
+}
+
+type AuthSignOption func(*authSignOption)
+
+// WithAuthClockSkew v2签名允许的时间偏差
+func WithAuthClockSkew(skew time.Duration) AuthSignOption {
+	return func(o *authSignOption) {
+		o.clockSkew = skew
+	}
+}
+
+// WithAuthNonceCache 自定义nonce缓存 多实例可共享存储
+func WithAuthNonceCache(cache NonceCache) AuthSignOption {
+	return func(o *authSignOption) {
+		o.nonceCache = cache
+	}
+}
+
+// WithAuthDisableV1 迁移完成后拒绝v1签名
+func WithAuthDisableV1() AuthSignOption {
+	return func(o *authSignOption) {
+		o.disableV1 = true
+	}
+}
+
+// WithAuthMaxBodySize v2签名可读取的最大body
+func WithAuthMaxBodySize(size int64) AuthSignOption {
+	return func(o *authSignOption) {
+		o.maxBodySize = size
+	}
+}
+
+// ValidateClientAuthSignV2 支持v1和v2签名 getSecret根据source和keyId获取密钥 可同时存在多个有效keyId用于轮换
+func ValidateClientAuthSignV2(getSecret func(string, string) string, opts ...AuthSignOption) gin.HandlerFunc {
+	opt := new(authSignOption)
+	for _, apply := range opts {
+		apply(opt)
+	}
+	if opt.clockSkew <= 0 {
+		opt.clockSkew = defaultAuthClockSkew
+	}
+	if opt.nonceCache == nil {
+		opt.nonceCache = NewMemNonceCache(defaultNonceCacheMaxSize)
+	}
+	if opt.maxBodySize <= 0 {
+		opt.maxBodySize = defaultAuthMaxBodySize
+	}
+	return func(c *gin.Context) {
+		sign := rawRequestHeader(c, rpcheader.AuthSign)
+		if sign == "" {
+			c.String(http.StatusUnauthorized, "empty sign")
+			c.Abort()
+			return
+		}
+		var pass, trusted bool
+		switch rawRequestHeader(c, rpcheader.AuthVersion) {
+		case "", common.AuthSignV1:
+			if opt.disableV1 {
+				c.String(http.StatusUnauthorized, "auth sign v1 is disabled")
+				c.Abort()
+				return
+			}
+			pass = validateAuthSignV1(c, sign, func(source string) string {
+				return getSecret(source, "")
+			})
+		case common.AuthSignV2:
+			pass = validateAuthSignV2(c, sign, getSecret, opt)
+			// v1签名不包含header且可重放 只有v2签名通过才可信
+			trusted = pass
+		default:
+			c.String(http.StatusUnauthorized, "unsupported auth sign version")
+			c.Abort()
+			return
+		}
+		if trusted {
+			trustRequest(c)
+		}
+		if pass {
+			c.Next()
+		}
+	}
+}
+
+func validateAuthSignV1(c *gin.Context, sign string, getSecret func(string) string) bool {
+	secret := getSecret(rawRequestHeader(c, rpcheader.Source))
+	if secret == "" {
+		c.String(http.StatusInternalServerError, "get auth sign key failed")
+		c.Abort()
+		return false
+	}
+	ts, _ := strconv.ParseInt(rawRequestHeader(c, rpcheader.AuthTs), 10, 64)
+	if time.Since(time.Unix(ts, 0)) > time.Hour {
+		c.String(http.StatusUnauthorized, "timestamp is not within one hour from current time")
+		c.Abort()
+		return false
+	}
+	newSign, err := common.GenAuthSign(secret, ts)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "get auth sign key failed")
+		c.Abort()
+		return false
+	}
+	if newSign != sign {
+		c.String(http.StatusUnauthorized, "auth failed")
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+func validateAuthSignV2(c *gin.Context, sign string, getSecret func(string, string) string, opt *authSignOption) bool {
+	source := rawRequestHeader(c, rpcheader.Source)
+	keyId := rawRequestHeader(c, rpcheader.AuthKeyId)
+	nonce := rawRequestHeader(c, rpcheader.AuthNonce)
+	if keyId == "" || nonce == "" {
+		c.String(http.StatusUnauthorized, "empty key id or nonce")
+		c.Abort()
+		return false
+	}
+	secret := getSecret(source, keyId)
+	if secret == "" {
+		c.String(http.StatusUnauthorized, "unknown auth key id")
+		c.Abort()
+		return false
+	}
+	ts, err := strconv.ParseInt(rawRequestHeader(c, rpcheader.AuthTs), 10, 64)
+	if err != nil {
+		c.String(http.StatusUnauthorized, "wrong timestamp")
+		c.Abort()
+		return false
+	}
+	skew := time.Since(time.Unix(ts, 0))
+	if skew > opt.clockSkew || skew < -opt.clockSkew {
+		c.String(http.StatusUnauthorized, "timestamp is out of clock skew window")
+		c.Abort()
+		return false
+	}
+	body, b := readAuthBody(c, opt.maxBodySize)
+	if !b {
+		c.String(http.StatusRequestEntityTooLarge, "request body error")
+		c.Abort()
+		return false
+	}
+	newSign, err := common.GenAuthSignV2(secret, common.AuthSignV2Content{
+		KeyId:     keyId,
+		Source:    source,
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.Path,
+		RawQuery:  c.Request.URL.RawQuery,
+		BodyHash:  common.HashAuthBody(body),
+		Timestamp: ts,
+		Nonce:     nonce,
+	})
+	if err != nil {
+		c.String(http.StatusInternalServerError, "get auth sign key failed")
+		c.Abort()
+		return false
+	}
+	if !hmac.Equal([]byte(newSign), []byte(sign)) {
+		c.String(http.StatusUnauthorized, "auth failed")
+		c.Abort()
+		return false
+	}
+	// 签名通过后再记录nonce 避免伪造请求占满缓存
+	if !opt.nonceCache.Add(source+"\n"+keyId+"\n"+nonce, 2*opt.clockSkew) {
+		c.String(http.StatusUnauthorized, "replayed request")
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// readAuthBody 读取body并重置 供后续handler使用
+func readAuthBody(c *gin.Context, maxBodySize int64) ([]byte, bool) {
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return nil, true
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBodySize+1))
+	c.Request.Body.Close()
+	if err != nil || int64(len(body)) > maxBodySize {
+		return nil, false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return body, true
+}
@@ -0,0 +1,183 @@
+package httpserver
+
+import (
+	"github.com/LeeZXin/zsf/common"
+	"github.com/LeeZXin/zsf/rpcheader"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSignEngine(opts ...AuthSignOption) *gin.Engine {
+	gin.SetMode(gin.ReleaseMode)
+	e := gin.New()
+	e.Use(newSignFilter(opts...))
+	e.POST("/api", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	return e
+}
+
+func newSignFilter(opts ...AuthSignOption) gin.HandlerFunc {
+	return ValidateClientAuthSignV2(func(source, keyId string) string {
+		switch {
+		case source == "app" && keyId == "k1":
+			return "secret"
+		case source == "other" && keyId == "k1":
+			return "secret"
+		case source == "app" && keyId == "":
+			return "v1secret"
+		}
+		return ""
+	}, opts...)
+}
+
+func newSignV2Request(t *testing.T, source, nonce string, ts int64) *http.Request {
+	body := `{"name":"zsf"}`
+	req := httptest.NewRequest(http.MethodPost, "/api?b=1&a=2", strings.NewReader(body))
+	sign, err := common.GenAuthSignV2("secret", common.AuthSignV2Content{
+		KeyId:     "k1",
+		Source:    source,
+		Method:    req.Method,
+		Path:      req.URL.Path,
+		RawQuery:  req.URL.RawQuery,
+		BodyHash:  common.HashAuthBody([]byte(body)),
+		Timestamp: ts,
+		Nonce:     nonce,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(rpcheader.Source, source)
+	req.Header.Set(rpcheader.AuthVersion, common.AuthSignV2)
+	req.Header.Set(rpcheader.AuthKeyId, "k1")
+	req.Header.Set(rpcheader.AuthNonce, nonce)
+	req.Header.Set(rpcheader.AuthTs, strconv.FormatInt(ts, 10))
+	req.Header.Set(rpcheader.AuthSign, sign)
+	return req
+}
+
+func newSignV1Request(t *testing.T) *http.Request {
+	ts := time.Now().Unix()
+	sign, err := common.GenAuthSign("v1secret", ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api", nil)
+	req.Header.Set(rpcheader.Source, "app")
+	req.Header.Set(rpcheader.AuthTs, strconv.FormatInt(ts, 10))
+	req.Header.Set(rpcheader.AuthSign, sign)
+	return req
+}
+
+func serveCode(e *gin.Engine, req *http.Request) int {
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestValidateClientAuthSignV2(t *testing.T) {
+	e := newSignEngine(WithAuthClockSkew(time.Minute))
+	now := time.Now().Unix()
+	if code := serveCode(e, newSignV2Request(t, "app", "n1", now)); code != http.StatusOK {
+		t.Fatalf("expected v2 pass, got %d", code)
+	}
+	// 重放
+	if code := serveCode(e, newSignV2Request(t, "app", "n1", now)); code != http.StatusUnauthorized {
+		t.Fatalf("expected replay rejected, got %d", code)
+	}
+	// 超出时间窗口
+	for _, ts := range []int64{now - 120, now + 120} {
+		if code := serveCode(e, newSignV2Request(t, "app", "n2", ts)); code != http.StatusUnauthorized {
+			t.Fatalf("expected clock skew rejected, got %d", code)
+		}
+	}
+	// 修改source
+	req := newSignV2Request(t, "app", "n3", now)
+	req.Header.Set(rpcheader.Source, "other")
+	if code := serveCode(e, req); code != http.StatusUnauthorized {
+		t.Fatalf("expected changed source rejected, got %d", code)
+	}
+	// 修改body
+	req = newSignV2Request(t, "app", "n4", now)
+	req.Body = http.NoBody
+	if code := serveCode(e, req); code != http.StatusUnauthorized {
+		t.Fatalf("expected changed body rejected, got %d", code)
+	}
+	// v1和v2同时支持
+	if code := serveCode(e, newSignV1Request(t)); code != http.StatusOK {
+		t.Fatalf("expected v1 pass, got %d", code)
+	}
+	req = newSignV1Request(t)
+	req.Header.Set(rpcheader.AuthVersion, "v3")
+	if code := serveCode(e, req); code != http.StatusUnauthorized {
+		t.Fatalf("expected unsupported version rejected, got %d", code)
+	}
+}
+
+func TestValidateClientAuthSignV2_DisableV1(t *testing.T) {
+	e := newSignEngine(WithAuthDisableV1())
+	if code := serveCode(e, newSignV1Request(t)); code != http.StatusUnauthorized {
+		t.Fatalf("expected v1 rejected, got %d", code)
+	}
+	if code := serveCode(e, newSignV2Request(t, "app", "n1", time.Now().Unix())); code != http.StatusOK {
+		t.Fatalf("expected v2 pass, got %d", code)
+	}
+}
+
+func TestMemNonceCache(t *testing.T) {
+	cache := NewMemNonceCache(2)
+	for _, nonce := range []string{"a", "b", "c"} {
+		if !cache.Add(nonce, time.Minute) {
+			t.Fatalf("expected %s added", nonce)
+		}
+	}
+	// 缓存满时淘汰最早的a 不拒绝新请求
+	if cache.Add("c", time.Minute) {
+		t.Fatal("expected c replayed")
+	}
+	if !cache.Add("a", time.Minute) {
+		t.Fatal("expected evicted a added")
+	}
+	if !cache.Add("d", time.Millisecond) {
+		t.Fatal("expected d added")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if !cache.Add("d", time.Minute) {
+		t.Fatal("expected expired d added")
+	}
+}
+
+func TestValidateClientAuthSignV2_Trust(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	e := gin.New()
+	e.Use(headerFilter(), newSignFilter())
+	var apiVersion, reqApiVersion string
+	e.POST("/api", func(c *gin.Context) {
+		apiVersion = rpcheader.GetHeaders(c.Request.Context()).Get(rpcheader.ApiVersion)
+		reqApiVersion = c.GetHeader(rpcheader.ApiVersion)
+	})
+	tests := []struct {
+		req     *http.Request
+		trusted bool
+	}{
+		// v1签名不包含header且可重放 不可信
+		{req: newSignV1Request(t)},
+		{req: newSignV2Request(t, "app", "n1", time.Now().Unix()), trusted: true},
+	}
+	for _, test := range tests {
+		apiVersion, reqApiVersion = "", ""
+		test.req.RemoteAddr = "8.8.8.8:1234"
+		test.req.Header.Set(rpcheader.ApiVersion, "gray")
+		if code := serveCode(e, test.req); code != http.StatusOK || (apiVersion == "gray") != test.trusted {
+			t.Fatalf("unexpected trust, version: %s, code: %d, api version: %s", test.req.Header.Get(rpcheader.AuthVersion), code, apiVersion)
+		}
+		if reqApiVersion != apiVersion {
+			t.Fatalf("unexpected request api version: %s", reqApiVersion)
+		}
+	}
+}
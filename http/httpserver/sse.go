@@ -0,0 +1,399 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"github.com/LeeZXin/zsf/logger"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server-Sent Events封装
+// 处理flush、心跳、客户端断开 以及基于Last-Event-ID的断点续传
+
+const (
+	LastEventIdHeader = "Last-Event-ID"
+
+	defaultSseHeartbeat = 15 * time.Second
+	defaultSseReplayTTL = 5 * time.Minute
+)
+
+var (
+	ErrSseClosed = errors.New("sse stream closed")
+
+	// sseLineReplacer id和event不能包含换行 否则可注入其他字段
+	sseLineReplacer = strings.NewReplacer("\r", "", "\n", "")
+	// sseDataReplacer data按\r\n、\r、\n拆分为多行
+	sseDataReplacer = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+)
+
+// SseEvent sse事件
+type SseEvent struct {
+	Id    string
+	Event string
+	Data  string
+	// Retry 客户端重连间隔 毫秒
+	Retry int
+}
+
+// SseStream 单个sse连接
+type SseStream struct {
+	mu          sync.Mutex
+	c           *gin.Context
+	ctx         context.Context
+	cancelFunc  context.CancelFunc
+	lastEventId string
+}
+
+// Context 客户端断开后done
+func (s *SseStream) Context() context.Context {
+	return s.ctx
+}
+
+// LastEventId 客户端重连时携带的Last-Event-ID
+func (s *SseStream) LastEventId() string {
+	return s.lastEventId
+}
+
+// Request 原始请求
+func (s *SseStream) Request() *http.Request {
+	return s.c.Request
+}
+
+// Send 发送事件并flush id和event中的换行会被删除
+func (s *SseStream) Send(event SseEvent) error {
+	var sb strings.Builder
+	if id := sseLineReplacer.Replace(event.Id); id != "" {
+		sb.WriteString("id: ")
+		sb.WriteString(id)
+		sb.WriteByte('\n')
+	}
+	if name := sseLineReplacer.Replace(event.Event); name != "" {
+		sb.WriteString("event: ")
+		sb.WriteString(name)
+		sb.WriteByte('\n')
+	}
+	if event.Retry > 0 {
+		sb.WriteString("retry: ")
+		sb.WriteString(strconv.Itoa(event.Retry))
+		sb.WriteByte('\n')
+	}
+	for _, line := range strings.Split(sseDataReplacer.Replace(event.Data), "\n") {
+		sb.WriteString("data: ")
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+	sb.WriteByte('\n')
+	return s.write(sb.String())
+}
+
+func (s *SseStream) heartbeat() error {
+	return s.write(": ping\n\n")
+}
+
+func (s *SseStream) write(content string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return ErrSseClosed
+	}
+	if _, err := s.c.Writer.WriteString(content); err != nil {
+		s.cancelFunc()
+		return err
+	}
+	s.c.Writer.Flush()
+	return nil
+}
+
+type sseOption struct {
+	heartbeat time.Duration
+}
+
+type SseOption func(*sseOption)
+
+// WithSseHeartbeat 心跳间隔 小于0不发心跳
+func WithSseHeartbeat(heartbeat time.Duration) SseOption {
+	return func(o *sseOption) {
+		o.heartbeat = heartbeat
+	}
+}
+
+// SseHandler sse handler fn返回后连接关闭
+// 会取消全局WriteTimeout
+func SseHandler(fn func(*SseStream), opts ...SseOption) gin.HandlerFunc {
+	opt := &sseOption{
+		heartbeat: defaultSseHeartbeat,
+	}
+	for _, apply := range opts {
+		apply(opt)
+	}
+	return func(c *gin.Context) {
+		setWriteDeadline(c, time.Time{})
+		header := c.Writer.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("Connection", "keep-alive")
+		header.Set("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		c.Writer.WriteHeaderNow()
+		c.Writer.Flush()
+		ctx, cancelFunc := context.WithCancel(c.Request.Context())
+		defer cancelFunc()
+		stream := &SseStream{
+			c:           c,
+			ctx:         ctx,
+			cancelFunc:  cancelFunc,
+			lastEventId: c.GetHeader(LastEventIdHeader),
+		}
+		if opt.heartbeat > 0 {
+			go func() {
+				ticker := time.NewTicker(opt.heartbeat)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+						if stream.heartbeat() != nil {
+							return
+						}
+					}
+				}
+			}()
+		}
+		fn(stream)
+		// 避免handler返回后心跳继续写
+		stream.mu.Lock()
+		cancelFunc()
+		stream.mu.Unlock()
+	}
+}
+
+// WithRouteTimeout 路由级别写超时 覆盖全局WriteTimeout 0表示不超时
+func WithRouteTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if timeout > 0 {
+			setWriteDeadline(c, time.Now().Add(timeout))
+		} else {
+			setWriteDeadline(c, time.Time{})
+		}
+		c.Next()
+	}
+}
+
+func setWriteDeadline(c *gin.Context, deadline time.Time) {
+	err := http.NewResponseController(c.Writer).SetWriteDeadline(deadline)
+	if err != nil {
+		logger.Logger.WithContext(c.Request.Context()).Debugf("set write deadline failed with err: %v", err)
+	}
+}
+
+type sseBufferedEvent struct {
+	seq   uint64
+	event SseEvent
+}
+
+type sseTopic struct {
+	seq         uint64
+	replay      []sseBufferedEvent
+	subscribers map[chan SseEvent]struct{}
+	// activeAt 最近一次发布或最后一个订阅者离开的时间
+	activeAt time.Time
+}
+
+// SseBroadcaster 按topic广播sse事件
+// 事件id由broadcaster按topic递增生成 保留最近replaySize条用于断点续传
+// 没有订阅者的topic超过replayTTL未发布事件时删除
+type SseBroadcaster struct {
+	mu         sync.RWMutex
+	topics     map[string]*sseTopic
+	replaySize int
+	bufferSize int
+	replayTTL  time.Duration
+	lastPurge  time.Time
+}
+
+type sseBroadcasterOption struct {
+	replayTTL time.Duration
+}
+
+type SseBroadcasterOption func(*sseBroadcasterOption)
+
+// WithSseReplayTTL 没有订阅者的topic保留断点续传事件的时间 默认5分钟
+func WithSseReplayTTL(ttl time.Duration) SseBroadcasterOption {
+	return func(o *sseBroadcasterOption) {
+		o.replayTTL = ttl
+	}
+}
+
+// NewSseBroadcaster replaySize断点续传缓存条数 bufferSize每个订阅者缓冲 缓冲满则断开慢订阅者
+func NewSseBroadcaster(replaySize, bufferSize int, opts ...SseBroadcasterOption) *SseBroadcaster {
+	opt := &sseBroadcasterOption{
+		replayTTL: defaultSseReplayTTL,
+	}
+	for _, apply := range opts {
+		apply(opt)
+	}
+	if opt.replayTTL <= 0 {
+		opt.replayTTL = defaultSseReplayTTL
+	}
+	if replaySize < 0 {
+		replaySize = 0
+	}
+	if bufferSize <= 0 {
+		bufferSize = 64
+	}
+	return &SseBroadcaster{
+		topics:     make(map[string]*sseTopic),
+		replaySize: replaySize,
+		bufferSize: bufferSize,
+		replayTTL:  opt.replayTTL,
+		lastPurge:  time.Now(),
+	}
+}
+
+// Publish 向topic的所有订阅者发送事件
+func (b *SseBroadcaster) Publish(topic string, event SseEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.purge(now)
+	t := b.getOrNewTopic(topic)
+	t.activeAt = now
+	t.seq++
+	event.Id = strconv.FormatUint(t.seq, 10)
+	if b.replaySize > 0 {
+		t.replay = append(t.replay, sseBufferedEvent{
+			seq:   t.seq,
+			event: event,
+		})
+		if len(t.replay) > b.replaySize {
+			t.replay = t.replay[len(t.replay)-b.replaySize:]
+		}
+	}
+	for ch := range t.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// 慢订阅者直接断开 客户端重连后可续传
+			delete(t.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// SubscriberCount topic订阅者数量
+func (b *SseBroadcaster) SubscriberCount(topic string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	t, ok := b.topics[topic]
+	if !ok {
+		return 0
+	}
+	return len(t.subscribers)
+}
+
+func (b *SseBroadcaster) getOrNewTopic(topic string) *sseTopic {
+	t, ok := b.topics[topic]
+	if !ok {
+		t = &sseTopic{
+			subscribers: make(map[chan SseEvent]struct{}),
+		}
+		b.topics[topic] = t
+	}
+	return t
+}
+
+// subscribe 订阅并返回需要续传的事件
+func (b *SseBroadcaster) subscribe(topic, lastEventId string) (chan SseEvent, []SseEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.purge(time.Now())
+	t := b.getOrNewTopic(topic)
+	ch := make(chan SseEvent, b.bufferSize)
+	t.subscribers[ch] = struct{}{}
+	if lastEventId == "" {
+		return ch, nil
+	}
+	last, err := strconv.ParseUint(lastEventId, 10, 64)
+	if err != nil {
+		return ch, nil
+	}
+	replay := make([]SseEvent, 0)
+	for _, e := range t.replay {
+		if e.seq > last {
+			replay = append(replay, e.event)
+		}
+	}
+	return ch, replay
+}
+
+func (b *SseBroadcaster) unsubscribe(topic string, ch chan SseEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.topics[topic]
+	if !ok {
+		return
+	}
+	if _, ok = t.subscribers[ch]; ok {
+		delete(t.subscribers, ch)
+		close(ch)
+	}
+	if len(t.subscribers) == 0 {
+		if len(t.replay) == 0 {
+			delete(b.topics, topic)
+		} else {
+			t.activeAt = time.Now()
+		}
+	}
+}
+
+// purge 删除没有订阅者且超过replayTTL未活跃的topic 最多每replayTTL执行一次
+func (b *SseBroadcaster) purge(now time.Time) {
+	if now.Sub(b.lastPurge) < b.replayTTL {
+		return
+	}
+	b.lastPurge = now
+	for name, t := range b.topics {
+		if len(t.subscribers) == 0 && now.Sub(t.activeAt) >= b.replayTTL {
+			delete(b.topics, name)
+		}
+	}
+}
+
+// Handler 订阅topic的sse handler topicFn从请求中获取topic
+func (b *SseBroadcaster) Handler(topicFn func(*gin.Context) string, opts ...SseOption) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		topic := topicFn(c)
+		if topic == "" {
+			c.String(http.StatusBadRequest, "empty topic")
+			return
+		}
+		SseHandler(func(stream *SseStream) {
+			ch, replay := b.subscribe(topic, stream.LastEventId())
+			defer b.unsubscribe(topic, ch)
+			for _, event := range replay {
+				if stream.Send(event) != nil {
+					return
+				}
+			}
+			for {
+				select {
+				case <-stream.Context().Done():
+					return
+				case event, ok := <-ch:
+					if !ok {
+						return
+					}
+					if stream.Send(event) != nil {
+						return
+					}
+				}
+			}
+		}, opts...)(c)
+	}
+}
@@ -0,0 +1,86 @@
+package httpserver
+
+import (
+	"bufio"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSseBroadcaster_Replay(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	b := NewSseBroadcaster(10, 8)
+	e := gin.New()
+	e.GET("/sse/:topic", b.Handler(func(c *gin.Context) string {
+		return c.Param("topic")
+	}, WithSseHeartbeat(-1)))
+	server := httptest.NewServer(e)
+	defer server.Close()
+	for i := 0; i < 3; i++ {
+		b.Publish("progress", SseEvent{Event: "step", Data: "done"})
+	}
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/sse/progress", nil)
+	req.Header.Set(LastEventIdHeader, "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("unexpected content type: %s", resp.Header.Get("Content-Type"))
+	}
+	go func() {
+		for b.SubscriberCount("progress") == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		b.Publish("progress", SseEvent{Data: "line1\nline2"})
+	}()
+	reader := bufio.NewReader(resp.Body)
+	ids := make([]string, 0)
+	for len(ids) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(line, "id: ") {
+			ids = append(ids, strings.TrimSpace(line[4:]))
+		}
+	}
+	if strings.Join(ids, ",") != "2,3,4" {
+		t.Fatalf("unexpected replay ids: %v", ids)
+	}
+}
+
+func TestSseBroadcaster_ReplayTTL(t *testing.T) {
+	b := NewSseBroadcaster(10, 8, WithSseReplayTTL(20*time.Millisecond))
+	b.Publish("job1", SseEvent{Data: "done"})
+	time.Sleep(30 * time.Millisecond)
+	// 没有订阅者的topic过期后删除
+	b.Publish("job2", SseEvent{Data: "done"})
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if _, ok := b.topics["job1"]; ok || len(b.topics) != 1 {
+		t.Fatalf("expected expired topic deleted, got %d topics", len(b.topics))
+	}
+}
+
+func TestSseStream_Send(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	e := gin.New()
+	e.GET("/sse", SseHandler(func(stream *SseStream) {
+		_ = stream.Send(SseEvent{
+			Id:    "1\nevent: admin",
+			Event: "step\r\n",
+			Data:  "line1\r\nline2\rline3\nline4",
+		})
+	}, WithSseHeartbeat(-1)))
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sse", nil))
+	expected := "id: 1event: admin\nevent: step\ndata: line1\ndata: line2\ndata: line3\ndata: line4\n\n"
+	if w.Body.String() != expected {
+		t.Fatalf("unexpected body: %q", w.Body.String())
+	}
+}
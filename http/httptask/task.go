@@ -1,8 +1,9 @@
 package httptask
 
 import (
-	"github.com/LeeZXin/zsf-utils/threadutil"
-	"github.com/LeeZXin/zsf/logger"
+	"context"
+	"github.com/LeeZXin/zsf/async"
+	"github.com/LeeZXin/zsf/http/openapi"
 	"github.com/gin-gonic/gin"
 	"io"
 	"net/http"
@@ -11,15 +12,52 @@ import (
 
 type Task func([]byte, url.Values)
 
+// TaskCtx 可获取调用链、MDC和rpcheader的task ctx不跟随请求取消
+type TaskCtx func(context.Context, []byte, url.Values)
+
+const (
+	// OpenApiTag httpTask文档tag
+	OpenApiTag = "httpTask"
+
+	taskPath = "/httpTask/v1/:taskName"
+)
+
 // WithHttpTask http task api
 func WithHttpTask(fns ...func() (string, Task)) gin.OptionFunc {
-	taskMap := make(map[string]Task)
+	ctxFns := make([]func() (string, TaskCtx), 0, len(fns))
+	for _, fn := range fns {
+		fn := fn
+		ctxFns = append(ctxFns, func() (string, TaskCtx) {
+			name, task := fn()
+			return name, func(_ context.Context, body []byte, query url.Values) {
+				task(body, query)
+			}
+		})
+	}
+	return WithHttpTaskCtx(ctxFns...)
+}
+
+// WithHttpTaskCtx http task api task可获取请求的上下文
+func WithHttpTaskCtx(fns ...func() (string, TaskCtx)) gin.OptionFunc {
+	taskMap := make(map[string]TaskCtx)
+	docs := make([]openapi.Route, 0, len(fns))
 	for _, fn := range fns {
 		name, task := fn()
 		taskMap[name] = task
+		docs = append(docs, openapi.Route{
+			Method:              openapi.MethodAny,
+			Path:                "/httpTask/v1/" + name,
+			Summary:             "trigger http task: " + name,
+			Tags:                []string{OpenApiTag},
+			ResponseContentType: "text/plain",
+			ResponseExample:     "ok",
+		})
 	}
+	openapi.Register(docs...)
+	// 每个task已单独生成文档
+	openapi.Exclude(taskPath)
 	return func(e *gin.Engine) {
-		e.Any("/httpTask/v1/:taskName", func(c *gin.Context) {
+		e.Any(taskPath, func(c *gin.Context) {
 			taskName := c.Param("taskName")
 			task, b := taskMap[taskName]
 			if !b {
@@ -31,13 +69,11 @@ func WithHttpTask(fns ...func() (string, Task)) gin.OptionFunc {
 					c.String(http.StatusInternalServerError, "read body failed")
 					return
 				}
-				go func() {
-					if err := threadutil.RunSafe(func() {
-						task(body, c.Request.URL.Query())
-					}); err != nil {
-						logger.Logger.Errorf("httpTask: %s err: %s", taskName, err)
-					}
-				}()
+				query := c.Request.URL.Query()
+				// 保留调用链和MDC 不跟随请求取消
+				async.Go(c.Request.Context(), func(ctx context.Context) {
+					task(ctx, body, query)
+				}, async.WithName("httpTask-"+taskName))
 				c.String(http.StatusOK, "ok")
 			}
 		})
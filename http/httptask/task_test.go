@@ -0,0 +1,58 @@
+package httptask
+
+import (
+	"context"
+	"github.com/LeeZXin/zsf/rpcheader"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithHttpTaskCtx(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	type result struct {
+		traceId string
+		err     error
+		body    string
+		query   string
+	}
+	done := make(chan result, 1)
+	e := gin.New()
+	e.Use(func(c *gin.Context) {
+		ctx := rpcheader.AppendToHeaders(c.Request.Context(), map[string]string{
+			rpcheader.TraceId: c.GetHeader(rpcheader.TraceId),
+		})
+		c.Request = c.Request.WithContext(ctx)
+	})
+	WithHttpTaskCtx(func() (string, TaskCtx) {
+		return "sync", func(ctx context.Context, body []byte, query url.Values) {
+			// 等待请求取消 ctx不跟随请求取消
+			time.Sleep(10 * time.Millisecond)
+			done <- result{
+				traceId: rpcheader.GetHeaders(ctx).Get(rpcheader.TraceId),
+				err:     ctx.Err(),
+				body:    string(body),
+				query:   query.Get("id"),
+			}
+		}
+	})(e)
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest(method, "/httpTask/v1/sync?id=1", strings.NewReader("data")).WithContext(ctx)
+		req.Header.Set(rpcheader.TraceId, "trace-"+method)
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		cancel()
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected code: %d", w.Code)
+		}
+		ret := <-done
+		if ret.traceId != "trace-"+method || ret.err != nil || ret.body != "data" || ret.query != "1" {
+			t.Fatalf("unexpected task result: %+v", ret)
+		}
+	}
+}
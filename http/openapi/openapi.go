@@ -0,0 +1,390 @@
+package openapi
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// openapi3文档生成
+// 路由描述来自Register注册或Provider提供 未描述的gin路由也会生成基础文档
+
+const (
+	Version = "3.0.3"
+	// MethodAny 匹配任意method
+	MethodAny = "ANY"
+)
+
+var (
+	routes    = make([]Route, 0)
+	providers = make([]*Provider, 0)
+	rmu       sync.RWMutex
+
+	anyMethods = []string{
+		http.MethodGet,
+		http.MethodPost,
+		http.MethodPut,
+		http.MethodDelete,
+		http.MethodPatch,
+	}
+
+	// 不生成文档的路由前缀
+	excludedPrefixes = []string{
+		"/actuator/",
+		"/debug/pprof/",
+		"/metrics",
+		"/static/",
+	}
+	// 不生成文档的gin路由 由Exclude添加
+	excludedPaths = make(map[string]struct{})
+)
+
+// Route 路由描述
+type Route struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Tags        []string
+	// Request 请求结构体 根据json、form、uri、header、binding标签生成参数
+	Request any
+	// Response 响应结构体
+	Response any
+	// ResponseStatus 响应码 默认200
+	ResponseStatus int
+	// ResponseContentType 默认application/json
+	ResponseContentType string
+	// ResponseExample 响应示例
+	ResponseExample string
+}
+
+// Provider 动态提供路由描述 如httptask、apigw mock路由
+type Provider func() []Route
+
+// Register 注册路由描述
+func Register(rs ...Route) {
+	rmu.Lock()
+	defer rmu.Unlock()
+	routes = append(routes, rs...)
+}
+
+// RegisterProvider 注册路由描述提供者 返回注销函数
+func RegisterProvider(p Provider) func() {
+	if p == nil {
+		return func() {}
+	}
+	ptr := &p
+	rmu.Lock()
+	defer rmu.Unlock()
+	providers = append(providers, ptr)
+	return func() {
+		rmu.Lock()
+		defer rmu.Unlock()
+		for i, provider := range providers {
+			if provider == ptr {
+				providers = append(providers[:i:i], providers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Exclude 未描述的gin路由中不生成文档的路径 如已按具体路径注册描述的通配路由
+func Exclude(paths ...string) {
+	rmu.Lock()
+	defer rmu.Unlock()
+	for _, path := range paths {
+		excludedPaths[path] = struct{}{}
+	}
+}
+
+// Handle 注册gin路由并注册路由描述 可用于httpserver.AddRouters
+func Handle(route Route, handlers ...gin.HandlerFunc) gin.OptionFunc {
+	Register(route)
+	return func(e *gin.Engine) {
+		if route.Method == MethodAny {
+			e.Any(route.Path, handlers...)
+		} else {
+			e.Handle(route.Method, route.Path, handlers...)
+		}
+	}
+}
+
+func getRoutes() []Route {
+	rmu.RLock()
+	ret := make([]Route, len(routes))
+	copy(ret, routes)
+	ps := providers[:]
+	rmu.RUnlock()
+	for _, p := range ps {
+		ret = append(ret, (*p)()...)
+	}
+	return ret
+}
+
+type Document struct {
+	OpenApi    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Tags       []Tag                `json:"tags,omitempty"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Tag struct {
+	Name string `json:"name"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas,omitempty"`
+}
+
+type PathItem struct {
+	Get    *Operation `json:"get,omitempty"`
+	Post   *Operation `json:"post,omitempty"`
+	Put    *Operation `json:"put,omitempty"`
+	Delete *Operation `json:"delete,omitempty"`
+	Patch  *Operation `json:"patch,omitempty"`
+	Head   *Operation `json:"head,omitempty"`
+	Option *Operation `json:"options,omitempty"`
+}
+
+func (p *PathItem) set(method string, op *Operation) bool {
+	var target **Operation
+	switch method {
+	case http.MethodGet:
+		target = &p.Get
+	case http.MethodPost:
+		target = &p.Post
+	case http.MethodPut:
+		target = &p.Put
+	case http.MethodDelete:
+		target = &p.Delete
+	case http.MethodPatch:
+		target = &p.Patch
+	case http.MethodHead:
+		target = &p.Head
+	case http.MethodOptions:
+		target = &p.Option
+	default:
+		return false
+	}
+	if *target != nil {
+		return false
+	}
+	*target = op
+	return true
+}
+
+type Operation struct {
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema,omitempty"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema  *Schema `json:"schema,omitempty"`
+	Example any     `json:"example,omitempty"`
+}
+
+// Build 根据gin路由和注册的路由描述生成文档
+func Build(title, version string, ginRoutes gin.RoutesInfo) *Document {
+	doc := &Document{
+		OpenApi: Version,
+		Info: Info{
+			Title:   title,
+			Version: version,
+		},
+		Paths: make(map[string]*PathItem),
+		Components: Components{
+			Schemas: make(map[string]*Schema),
+		},
+	}
+	reflector := newSchemaReflector(doc.Components.Schemas)
+	tags := make(map[string]struct{})
+	for _, route := range getRoutes() {
+		methods := []string{strings.ToUpper(route.Method)}
+		if route.Method == MethodAny || route.Method == "" {
+			methods = anyMethods
+		}
+		for _, method := range methods {
+			if doc.addOperation(method, route.Path, buildOperation(reflector, method, route)) {
+				for _, tag := range route.Tags {
+					tags[tag] = struct{}{}
+				}
+			}
+		}
+	}
+	// 未描述的路由
+	for _, r := range ginRoutes {
+		if isExcluded(r.Path) {
+			continue
+		}
+		doc.addOperation(r.Method, r.Path, buildOperation(reflector, r.Method, Route{
+			Method: r.Method,
+			Path:   r.Path,
+		}))
+	}
+	tagNames := make([]string, 0, len(tags))
+	for tag := range tags {
+		tagNames = append(tagNames, tag)
+	}
+	sort.Strings(tagNames)
+	for _, tag := range tagNames {
+		doc.Tags = append(doc.Tags, Tag{Name: tag})
+	}
+	return doc
+}
+
+func (d *Document) addOperation(method, path string, op *Operation) bool {
+	p := ConvertPath(path)
+	item, b := d.Paths[p]
+	if !b {
+		item = new(PathItem)
+	}
+	if !item.set(method, op) {
+		return false
+	}
+	d.Paths[p] = item
+	return true
+}
+
+func buildOperation(reflector *schemaReflector, method string, route Route) *Operation {
+	op := &Operation{
+		Summary:     route.Summary,
+		Description: route.Description,
+		Tags:        route.Tags,
+		Responses:   make(map[string]*Response),
+	}
+	// path参数
+	pathParams := make(map[string]struct{})
+	for _, name := range pathParamNames(route.Path) {
+		pathParams[name] = struct{}{}
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: "string"},
+		})
+	}
+	if route.Request != nil {
+		params, body := reflector.requestOf(reflect.TypeOf(route.Request), hasBody(method))
+		for _, param := range params {
+			if param.In == "path" {
+				if _, b := pathParams[param.Name]; b {
+					continue
+				}
+			}
+			op.Parameters = append(op.Parameters, param)
+		}
+		if body != nil {
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content: map[string]*MediaType{
+					"application/json": {Schema: body},
+				},
+			}
+		}
+	}
+	status := route.ResponseStatus
+	if status <= 0 {
+		status = http.StatusOK
+	}
+	resp := &Response{
+		Description: http.StatusText(status),
+	}
+	if route.Response != nil || route.ResponseExample != "" {
+		contentType := route.ResponseContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		media := new(MediaType)
+		if route.Response != nil {
+			media.Schema = reflector.schemaOf(reflect.TypeOf(route.Response))
+		}
+		if route.ResponseExample != "" {
+			media.Example = route.ResponseExample
+		}
+		resp.Content = map[string]*MediaType{
+			contentType: media,
+		}
+	}
+	if resp.Description == "" {
+		resp.Description = "response"
+	}
+	op.Responses[strconv.Itoa(status)] = resp
+	return op
+}
+
+func hasBody(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodDelete, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+func isExcluded(path string) bool {
+	rmu.RLock()
+	_, b := excludedPaths[path]
+	rmu.RUnlock()
+	if b {
+		return true
+	}
+	for _, prefix := range excludedPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConvertPath gin路由转为openapi路径 /user/:id => /user/{id}
+func ConvertPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+			segments[i] = "{" + seg[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func pathParamNames(path string) []string {
+	ret := make([]string, 0)
+	for _, seg := range strings.Split(path, "/") {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+			ret = append(ret, seg[1:])
+		}
+	}
+	return ret
+}
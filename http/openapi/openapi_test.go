@@ -0,0 +1,91 @@
+package openapi
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"testing"
+)
+
+type testBase struct {
+	Code int `json:"code"`
+}
+
+type testUserReq struct {
+	Id    string `uri:"id"`
+	Token string `header:"Z-Token"`
+	Name  string `json:"name" binding:"required"`
+	Tags  []string
+	Next  *testUserReq `json:"next"`
+}
+
+type testUserResp struct {
+	testBase
+	Data testUserReq `json:"data"`
+}
+
+func TestBuild(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	e := gin.New()
+	Handle(Route{
+		Method:   http.MethodPost,
+		Path:     "/user/:id",
+		Tags:     []string{"user"},
+		Request:  testUserReq{},
+		Response: testUserResp{},
+	}, func(*gin.Context) {})(e)
+	e.GET("/hello", func(*gin.Context) {})
+	e.GET("/actuator/health", func(*gin.Context) {})
+	doc := Build("test", "v1", e.Routes())
+	if _, b := doc.Paths["/actuator/health"]; b {
+		t.Fatal("actuator should be excluded")
+	}
+	if doc.Paths["/hello"] == nil || doc.Paths["/hello"].Get == nil {
+		t.Fatal("undocumented route should be included")
+	}
+	op := doc.Paths["/user/{id}"].Post
+	if op == nil || op.RequestBody == nil || len(op.Parameters) != 2 {
+		out, _ := json.Marshal(doc)
+		t.Fatalf("unexpected doc: %s", out)
+	}
+	body := op.RequestBody.Content["application/json"].Schema
+	if body.Properties["Tags"] == nil || len(body.Required) != 1 || body.Required[0] != "name" {
+		t.Fatalf("unexpected body schema: %+v", body)
+	}
+	resp := doc.Components.Schemas["testUserResp"]
+	if resp == nil || resp.Properties["code"] == nil || resp.Properties["data"].Ref != "#/components/schemas/testUserReq" {
+		t.Fatalf("unexpected response schema: %+v", resp)
+	}
+}
+
+func TestRegisterProvider(t *testing.T) {
+	unregister := RegisterProvider(func() []Route {
+		return []Route{{Method: http.MethodPost, Path: "/task/:name"}}
+	})
+	doc := Build("test", "v1", nil)
+	item := doc.Paths["/task/{name}"]
+	if item == nil || item.Post == nil || item.Get != nil {
+		t.Fatalf("unexpected provider doc: %+v", item)
+	}
+	unregister()
+	unregister()
+	if _, b := Build("test", "v1", nil).Paths["/task/{name}"]; b {
+		t.Fatal("provider should be unregistered")
+	}
+}
+
+func TestExclude(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	e := gin.New()
+	e.Any("/job/:name", func(*gin.Context) {})
+	Register(Route{Method: MethodAny, Path: "/job/sync"})
+	Exclude("/job/:name")
+	doc := Build("test", "v1", e.Routes())
+	if _, b := doc.Paths["/job/{name}"]; b {
+		t.Fatal("excluded route should not be included")
+	}
+	item := doc.Paths["/job/sync"]
+	if item == nil || item.Get == nil || item.Post == nil {
+		t.Fatalf("unexpected any route doc: %+v", item)
+	}
+}
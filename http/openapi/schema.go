@@ -0,0 +1,206 @@
+package openapi
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 根据结构体反射生成schema
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	schemaNameReplace = regexp.MustCompile(`[^a-zA-Z0-9_.]+`)
+)
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+}
+
+type schemaReflector struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+func newSchemaReflector(schemas map[string]*Schema) *schemaReflector {
+	return &schemaReflector{
+		schemas: schemas,
+		names:   make(map[reflect.Type]string),
+	}
+}
+
+func (r *schemaReflector) schemaOf(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: r.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: r.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return r.structSchema(t)
+		}
+		name, b := r.names[t]
+		if !b {
+			name = r.schemaName(t)
+			r.names[t] = name
+			// 先占位 避免递归结构体死循环
+			r.schemas[name] = new(Schema)
+			*r.schemas[name] = *r.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	default:
+		return &Schema{}
+	}
+}
+
+func (r *schemaReflector) schemaName(t reflect.Type) string {
+	name := schemaNameReplace.ReplaceAllString(t.Name(), "_")
+	name = strings.Trim(name, "_")
+	if _, b := r.schemas[name]; !b {
+		return name
+	}
+	// 不同包同名结构体
+	pkg := t.PkgPath()
+	if i := strings.LastIndex(pkg, "/"); i >= 0 {
+		pkg = pkg[i+1:]
+	}
+	return pkg + "." + name
+}
+
+func (r *schemaReflector) structSchema(t reflect.Type) *Schema {
+	ret := &Schema{
+		Type:       "object",
+		Properties: make(map[string]*Schema),
+	}
+	r.eachField(t, func(f reflect.StructField) {
+		name, skip := fieldName(f, "json")
+		if skip {
+			return
+		}
+		ret.Properties[name] = r.schemaOf(f.Type)
+		if isRequired(f) {
+			ret.Required = append(ret.Required, name)
+		}
+	})
+	return ret
+}
+
+// requestOf 请求结构体生成参数和body
+// uri => path, header => header, form => query
+// 无body的method json字段作为query参数
+func (r *schemaReflector) requestOf(t reflect.Type, hasBody bool) ([]Parameter, *Schema) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		if hasBody {
+			return nil, r.schemaOf(t)
+		}
+		return nil, nil
+	}
+	params := make([]Parameter, 0)
+	body := &Schema{
+		Type:       "object",
+		Properties: make(map[string]*Schema),
+	}
+	r.eachField(t, func(f reflect.StructField) {
+		if name, skip := fieldName(f, "uri"); !skip && f.Tag.Get("uri") != "" {
+			params = append(params, Parameter{Name: name, In: "path", Required: true, Schema: r.schemaOf(f.Type)})
+			return
+		}
+		if name, skip := fieldName(f, "header"); !skip && f.Tag.Get("header") != "" {
+			params = append(params, Parameter{Name: name, In: "header", Required: isRequired(f), Schema: r.schemaOf(f.Type)})
+			return
+		}
+		if name, skip := fieldName(f, "form"); !skip && f.Tag.Get("form") != "" {
+			params = append(params, Parameter{Name: name, In: "query", Required: isRequired(f), Schema: r.schemaOf(f.Type)})
+			return
+		}
+		name, skip := fieldName(f, "json")
+		if skip {
+			return
+		}
+		if !hasBody {
+			params = append(params, Parameter{Name: name, In: "query", Required: isRequired(f), Schema: r.schemaOf(f.Type)})
+			return
+		}
+		body.Properties[name] = r.schemaOf(f.Type)
+		if isRequired(f) {
+			body.Required = append(body.Required, name)
+		}
+	})
+	if !hasBody || len(body.Properties) == 0 {
+		return params, nil
+	}
+	return params, body
+}
+
+// eachField 遍历导出字段 匿名结构体展开
+func (r *schemaReflector) eachField(t reflect.Type, fn func(reflect.StructField)) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			ft := f.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && f.Tag.Get("json") == "" {
+				r.eachField(ft, fn)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		fn(f)
+	}
+}
+
+func fieldName(f reflect.StructField, tag string) (string, bool) {
+	val := f.Tag.Get(tag)
+	if val == "-" {
+		return "", true
+	}
+	name := strings.Split(val, ",")[0]
+	if name == "" {
+		name = f.Name
+	}
+	return name, false
+}
+
+func isRequired(f reflect.StructField) bool {
+	for _, rule := range strings.Split(f.Tag.Get("binding"), ",") {
+		if rule == "required" {
+			return true
+		}
+	}
+	return false
+}
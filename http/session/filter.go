@@ -0,0 +1,384 @@
+package session
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/LeeZXin/zsf/logger"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// session filter
+// cookie内容为 base64(payload).base64(hmac) payload可选aes-gcm加密
+// 每次请求滚动续期 剩余有效期不足一半时刷新存储和cookie
+
+const (
+	DefaultCookieName     = "ZSF_SESSION"
+	DefaultCsrfHeaderName = "X-CSRF-Token"
+	DefaultCsrfFormName   = "_csrf"
+
+	defaultMaxAge = 30 * time.Minute
+)
+
+var (
+	errInvalidCookie = errors.New("invalid session cookie")
+)
+
+type cookiePayload struct {
+	Id       string         `json:"i"`
+	Values   map[string]any `json:"v,omitempty"`
+	ExpireAt int64          `json:"e"`
+}
+
+type option struct {
+	cookieName     string
+	secrets        [][]byte
+	encryptKey     []byte
+	maxAge         time.Duration
+	store          Store
+	path           string
+	domain         string
+	secure         bool
+	sameSite       http.SameSite
+	csrfEnabled    bool
+	csrfHeaderName string
+	csrfFormName   string
+}
+
+type Option func(*option)
+
+func WithCookieName(name string) Option {
+	return func(o *option) {
+		o.cookieName = name
+	}
+}
+
+// WithSecrets 签名密钥 使用第一个签名 所有密钥均可验签 用于密钥轮换
+func WithSecrets(secrets ...[]byte) Option {
+	return func(o *option) {
+		o.secrets = secrets
+	}
+}
+
+// WithEncryptKey aes密钥 16、24或32字节 设置后cookie内容加密
+func WithEncryptKey(key []byte) Option {
+	return func(o *option) {
+		o.encryptKey = key
+	}
+}
+
+// WithMaxAge session有效期 每次访问滚动续期
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(o *option) {
+		o.maxAge = maxAge
+	}
+}
+
+func WithStore(store Store) Option {
+	return func(o *option) {
+		o.store = store
+	}
+}
+
+func WithCookiePath(path string) Option {
+	return func(o *option) {
+		o.path = path
+	}
+}
+
+func WithCookieDomain(domain string) Option {
+	return func(o *option) {
+		o.domain = domain
+	}
+}
+
+func WithCookieSecure(secure bool) Option {
+	return func(o *option) {
+		o.secure = secure
+	}
+}
+
+func WithCookieSameSite(sameSite http.SameSite) Option {
+	return func(o *option) {
+		o.sameSite = sameSite
+	}
+}
+
+// WithCsrf 开启csrf校验 POST、PUT、PATCH、DELETE需携带header或表单token
+func WithCsrf(headerName, formName string) Option {
+	return func(o *option) {
+		o.csrfEnabled = true
+		o.csrfHeaderName = headerName
+		o.csrfFormName = formName
+	}
+}
+
+type manager struct {
+	opt  *option
+	aead cipher.AEAD
+}
+
+// Filter session filter
+func Filter(opts ...Option) gin.HandlerFunc {
+	opt := &option{
+		path:     "/",
+		sameSite: http.SameSiteLaxMode,
+	}
+	for _, apply := range opts {
+		apply(opt)
+	}
+	if len(opt.secrets) == 0 || len(opt.secrets[0]) == 0 {
+		logger.Logger.Fatal("session secrets is empty")
+	}
+	if opt.cookieName == "" {
+		opt.cookieName = DefaultCookieName
+	}
+	if opt.maxAge <= 0 {
+		opt.maxAge = defaultMaxAge
+	}
+	if opt.store == nil {
+		opt.store = NewMemStore(0)
+	}
+	if opt.csrfHeaderName == "" {
+		opt.csrfHeaderName = DefaultCsrfHeaderName
+	}
+	if opt.csrfFormName == "" {
+		opt.csrfFormName = DefaultCsrfFormName
+	}
+	m := &manager{
+		opt: opt,
+	}
+	if len(opt.encryptKey) > 0 {
+		block, err := aes.NewCipher(opt.encryptKey)
+		if err != nil {
+			logger.Logger.Fatalf("session encrypt key is invalid: %v", err)
+		}
+		m.aead, _ = cipher.NewGCM(block)
+	}
+	return m.handle
+}
+
+func (m *manager) handle(c *gin.Context) {
+	sess := m.load(c)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), sessionKey{}, sess))
+	if m.opt.csrfEnabled && !m.checkCsrf(c, sess) {
+		c.String(http.StatusForbidden, "invalid csrf token")
+		c.Abort()
+		return
+	}
+	w := &responseWriter{
+		ResponseWriter: c.Writer,
+	}
+	w.beforeWrite = func() {
+		m.save(c, sess)
+	}
+	c.Writer = w
+	c.Next()
+	// 没有写body的情况
+	w.once.Do(w.beforeWrite)
+}
+
+func (m *manager) load(c *gin.Context) *Session {
+	cookie, err := c.Request.Cookie(m.opt.cookieName)
+	if err != nil || cookie.Value == "" {
+		return newSession()
+	}
+	payload, err := m.decode(cookie.Value)
+	if err != nil || payload.ExpireAt <= time.Now().UnixMilli() {
+		return newSession()
+	}
+	sess := &Session{
+		id:       payload.Id,
+		expireAt: time.UnixMilli(payload.ExpireAt),
+	}
+	if _, b := m.opt.store.(*cookieStore); b {
+		sess.values = payload.Values
+	} else {
+		sess.values, err = m.opt.store.Load(c.Request.Context(), payload.Id)
+		if err != nil {
+			logger.Logger.WithContext(c.Request.Context()).Errorf("load session failed with err: %v", err)
+		}
+		if sess.values == nil {
+			return newSession()
+		}
+	}
+	if sess.values == nil {
+		sess.values = make(map[string]any)
+	}
+	return sess
+}
+
+func (m *manager) save(c *gin.Context, sess *Session) {
+	ctx := c.Request.Context()
+	sess.mu.RLock()
+	id, oldId, isNew, changed, destroyed, expireAt := sess.id, sess.oldId, sess.isNew, sess.changed, sess.destroyed, sess.expireAt
+	sess.mu.RUnlock()
+	if oldId != "" {
+		_ = m.opt.store.Delete(ctx, oldId)
+	}
+	if destroyed {
+		if !isNew {
+			_ = m.opt.store.Delete(ctx, id)
+			m.setCookie(c, "", -1)
+		}
+		return
+	}
+	// 匿名访问不创建session
+	if isNew && !changed {
+		return
+	}
+	now := time.Now()
+	if !changed && expireAt.Sub(now) > m.opt.maxAge/2 {
+		return
+	}
+	values := sess.copyValues()
+	newExpireAt := now.Add(m.opt.maxAge)
+	payload := cookiePayload{
+		Id:       id,
+		ExpireAt: newExpireAt.UnixMilli(),
+	}
+	if _, b := m.opt.store.(*cookieStore); b {
+		payload.Values = values
+	} else if err := m.opt.store.Save(ctx, id, values, m.opt.maxAge); err != nil {
+		logger.Logger.WithContext(ctx).Errorf("save session failed with err: %v", err)
+		return
+	}
+	value, err := m.encode(payload)
+	if err != nil {
+		logger.Logger.WithContext(ctx).Errorf("encode session cookie failed with err: %v", err)
+		return
+	}
+	m.setCookie(c, value, int(m.opt.maxAge.Seconds()))
+}
+
+func (m *manager) setCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     m.opt.cookieName,
+		Value:    value,
+		Path:     m.opt.path,
+		Domain:   m.opt.domain,
+		MaxAge:   maxAge,
+		Secure:   m.opt.secure,
+		HttpOnly: true,
+		SameSite: m.opt.sameSite,
+	})
+}
+
+func (m *manager) checkCsrf(c *gin.Context, sess *Session) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	expected := sess.csrfToken()
+	if expected == "" {
+		return false
+	}
+	token := c.GetHeader(m.opt.csrfHeaderName)
+	if token == "" {
+		token = c.PostForm(m.opt.csrfFormName)
+	}
+	return hmac.Equal([]byte(token), []byte(expected))
+}
+
+func (m *manager) encode(payload cookiePayload) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	if m.aead != nil {
+		nonce := make([]byte, m.aead.NonceSize())
+		if _, err = rand.Read(nonce); err != nil {
+			return "", err
+		}
+		data = m.aead.Seal(nonce, nonce, data, []byte(m.opt.cookieName))
+	}
+	value := base64.RawURLEncoding.EncodeToString(data)
+	return value + "." + base64.RawURLEncoding.EncodeToString(m.sign(m.opt.secrets[0], value)), nil
+}
+
+func (m *manager) decode(cookie string) (cookiePayload, error) {
+	var payload cookiePayload
+	value, sig, b := strings.Cut(cookie, ".")
+	if !b {
+		return payload, errInvalidCookie
+	}
+	sigBytes, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return payload, errInvalidCookie
+	}
+	verified := false
+	for _, secret := range m.opt.secrets {
+		if hmac.Equal(m.sign(secret, value), sigBytes) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return payload, errInvalidCookie
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return payload, errInvalidCookie
+	}
+	if m.aead != nil {
+		size := m.aead.NonceSize()
+		if len(data) < size {
+			return payload, errInvalidCookie
+		}
+		data, err = m.aead.Open(nil, data[:size], data[size:], []byte(m.opt.cookieName))
+		if err != nil {
+			return payload, errInvalidCookie
+		}
+	}
+	if err = json.Unmarshal(data, &payload); err != nil {
+		return payload, errInvalidCookie
+	}
+	return payload, nil
+}
+
+func (m *manager) sign(secret []byte, value string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(m.opt.cookieName + "|" + value))
+	return h.Sum(nil)
+}
+
+// responseWriter 写body之前保存session 保证cookie能写入header
+type responseWriter struct {
+	gin.ResponseWriter
+	once        sync.Once
+	beforeWrite func()
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *responseWriter) WriteHeaderNow() {
+	w.once.Do(w.beforeWrite)
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	w.once.Do(w.beforeWrite)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseWriter) WriteString(s string) (int, error) {
+	w.once.Do(w.beforeWrite)
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *responseWriter) Flush() {
+	w.once.Do(w.beforeWrite)
+	w.ResponseWriter.Flush()
+}
@@ -0,0 +1,86 @@
+package session
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestEngine(opts ...Option) *gin.Engine {
+	gin.SetMode(gin.ReleaseMode)
+	e := gin.New()
+	e.Use(Filter(opts...))
+	e.GET("/login", func(c *gin.Context) {
+		sess := From(c)
+		sess.Regenerate()
+		sess.Set("user", "zsf")
+		c.String(http.StatusOK, sess.CSRFToken())
+	})
+	e.GET("/me", func(c *gin.Context) {
+		c.String(http.StatusOK, From(c).GetString("user"))
+	})
+	e.POST("/logout", func(c *gin.Context) {
+		From(c).Destroy()
+		c.Status(http.StatusNoContent)
+	})
+	return e
+}
+
+func doTestRequest(e *gin.Engine, method, path string, cookies []*http.Cookie, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	return w
+}
+
+func testSessionFlow(t *testing.T, e *gin.Engine) {
+	w := doTestRequest(e, http.MethodGet, "/login", nil, nil)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expect session cookie but got: %v", cookies)
+	}
+	token := w.Body.String()
+	w = doTestRequest(e, http.MethodGet, "/me", cookies, nil)
+	if w.Body.String() != "zsf" {
+		t.Fatalf("unexpected session value: %s", w.Body.String())
+	}
+	w = doTestRequest(e, http.MethodPost, "/logout", cookies, nil)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expect csrf rejection but got: %d", w.Code)
+	}
+	w = doTestRequest(e, http.MethodPost, "/logout", cookies, map[string]string{
+		DefaultCsrfHeaderName: token,
+	})
+	if w.Code != http.StatusNoContent || len(w.Result().Cookies()) != 1 || w.Result().Cookies()[0].MaxAge >= 0 {
+		t.Fatalf("expect session destroyed but got: %d %v", w.Code, w.Result().Cookies())
+	}
+	// 篡改cookie
+	cookies[0].Value = "x" + cookies[0].Value
+	w = doTestRequest(e, http.MethodGet, "/me", cookies, nil)
+	if w.Body.String() != "" {
+		t.Fatal("tampered cookie should be rejected")
+	}
+}
+
+func TestFilter_MemStore(t *testing.T) {
+	testSessionFlow(t, newTestEngine(
+		WithSecrets([]byte("secret")),
+		WithCsrf("", ""),
+	))
+}
+
+func TestFilter_CookieStore(t *testing.T) {
+	testSessionFlow(t, newTestEngine(
+		WithSecrets([]byte("secret")),
+		WithEncryptKey([]byte("0123456789abcdef")),
+		WithStore(NewCookieStore()),
+		WithCsrf("", ""),
+	))
+}
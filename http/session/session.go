@@ -0,0 +1,151 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/gin-gonic/gin"
+	"sync"
+	"time"
+)
+
+// 服务端session
+// 值以json形式存储 读取后数字类型为float64
+
+const (
+	csrfKey = "_csrf"
+)
+
+type sessionKey struct{}
+
+type Session struct {
+	mu        sync.RWMutex
+	id        string
+	oldId     string
+	values    map[string]any
+	expireAt  time.Time
+	isNew     bool
+	changed   bool
+	destroyed bool
+}
+
+func newSession() *Session {
+	return &Session{
+		id:     randomId(),
+		values: make(map[string]any),
+		isNew:  true,
+	}
+}
+
+// From 从context获取session 未使用session filter时返回nil
+func From(ctx context.Context) *Session {
+	if ctx == nil {
+		return nil
+	}
+	if c, b := ctx.(*gin.Context); b && c.Request != nil {
+		ctx = c.Request.Context()
+	}
+	ret, _ := ctx.Value(sessionKey{}).(*Session)
+	return ret
+}
+
+func (s *Session) ID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.id
+}
+
+func (s *Session) IsNew() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.isNew
+}
+
+func (s *Session) Get(key string) (any, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ret, b := s.values[key]
+	return ret, b
+}
+
+func (s *Session) GetString(key string) string {
+	val, _ := s.Get(key)
+	ret, _ := val.(string)
+	return ret
+}
+
+func (s *Session) Set(key string, val any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = val
+	s.changed = true
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, b := s.values[key]; b {
+		delete(s.values, key)
+		s.changed = true
+	}
+}
+
+// Clear 清空数据 保留session
+func (s *Session) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = make(map[string]any)
+	s.changed = true
+}
+
+// Destroy 销毁session 删除存储和cookie
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = make(map[string]any)
+	s.destroyed = true
+}
+
+// Regenerate 更换session id 登录后调用 防止session固定攻击
+func (s *Session) Regenerate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.oldId == "" && !s.isNew {
+		s.oldId = s.id
+	}
+	s.id = randomId()
+	s.changed = true
+}
+
+// CSRFToken 获取csrf token 不存在则生成
+func (s *Session) CSRFToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, _ := s.values[csrfKey].(string)
+	if token == "" {
+		token = randomId()
+		s.values[csrfKey] = token
+		s.changed = true
+	}
+	return token
+}
+
+func (s *Session) csrfToken() string {
+	return s.GetString(csrfKey)
+}
+
+func (s *Session) copyValues() map[string]any {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ret := make(map[string]any, len(s.values))
+	for k, v := range s.values {
+		ret[k] = v
+	}
+	return ret
+}
+
+func randomId() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,118 @@
+package session
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Store session存储
+type Store interface {
+	// Load 不存在返回nil
+	Load(ctx context.Context, id string) (map[string]any, error)
+	Save(ctx context.Context, id string, values map[string]any, ttl time.Duration) error
+	Delete(ctx context.Context, id string) error
+}
+
+// cookieStore 数据直接存在cookie里 建议开启加密
+type cookieStore struct{}
+
+// NewCookieStore 仅使用cookie存储
+func NewCookieStore() Store {
+	return new(cookieStore)
+}
+
+func (*cookieStore) Load(context.Context, string) (map[string]any, error) {
+	return nil, nil
+}
+
+func (*cookieStore) Save(context.Context, string, map[string]any, time.Duration) error {
+	return nil
+}
+
+func (*cookieStore) Delete(context.Context, string) error {
+	return nil
+}
+
+type memEntry struct {
+	id       string
+	values   map[string]any
+	expireAt time.Time
+}
+
+// memStore 内存lru存储
+type memStore struct {
+	sync.Mutex
+	maxSize int
+	ll      *list.List
+	cache   map[string]*list.Element
+}
+
+// NewMemStore 内存lru存储 超过maxSize淘汰最久未使用的session
+func NewMemStore(maxSize int) Store {
+	if maxSize <= 0 {
+		maxSize = 10000
+	}
+	return &memStore{
+		maxSize: maxSize,
+		ll:      list.New(),
+		cache:   make(map[string]*list.Element, 1024),
+	}
+}
+
+func (s *memStore) Load(_ context.Context, id string) (map[string]any, error) {
+	s.Lock()
+	defer s.Unlock()
+	elem, b := s.cache[id]
+	if !b {
+		return nil, nil
+	}
+	entry := elem.Value.(*memEntry)
+	if !entry.expireAt.After(time.Now()) {
+		s.removeElement(elem)
+		return nil, nil
+	}
+	s.ll.MoveToFront(elem)
+	ret := make(map[string]any, len(entry.values))
+	for k, v := range entry.values {
+		ret[k] = v
+	}
+	return ret, nil
+}
+
+func (s *memStore) Save(_ context.Context, id string, values map[string]any, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	expireAt := time.Now().Add(ttl)
+	if elem, b := s.cache[id]; b {
+		entry := elem.Value.(*memEntry)
+		entry.values = values
+		entry.expireAt = expireAt
+		s.ll.MoveToFront(elem)
+		return nil
+	}
+	s.cache[id] = s.ll.PushFront(&memEntry{
+		id:       id,
+		values:   values,
+		expireAt: expireAt,
+	})
+	for s.ll.Len() > s.maxSize {
+		s.removeElement(s.ll.Back())
+	}
+	return nil
+}
+
+func (s *memStore) Delete(_ context.Context, id string) error {
+	s.Lock()
+	defer s.Unlock()
+	if elem, b := s.cache[id]; b {
+		s.removeElement(elem)
+	}
+	return nil
+}
+
+func (s *memStore) removeElement(elem *list.Element) {
+	s.ll.Remove(elem)
+	delete(s.cache, elem.Value.(*memEntry).id)
+}
@@ -0,0 +1,93 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/LeeZXin/zsf-utils/quit"
+	"github.com/LeeZXin/zsf-utils/taskutil"
+	"github.com/LeeZXin/zsf/logger"
+	"time"
+	"xorm.io/xorm"
+)
+
+const (
+	SessionTableName = "zsf_session"
+)
+
+type sessionModel struct {
+	Id       string `xorm:"pk varchar(64)"`
+	Data     string `xorm:"text"`
+	ExpireAt int64  `xorm:"index"`
+}
+
+func (*sessionModel) TableName() string {
+	return SessionTableName
+}
+
+// xormStore 数据库存储
+type xormStore struct {
+	engine *xorm.Engine
+}
+
+// NewXormStore 数据库存储 自动建表并定时清理过期数据
+func NewXormStore(engine *xorm.Engine) (Store, error) {
+	if err := engine.Sync2(new(sessionModel)); err != nil {
+		return nil, err
+	}
+	s := &xormStore{
+		engine: engine,
+	}
+	stopFunc, _ := taskutil.RunPeriodicalTask(time.Minute, 10*time.Minute, s.deleteExpired)
+	quit.AddShutdownHook(func() {
+		stopFunc()
+	})
+	return s, nil
+}
+
+func (s *xormStore) Load(ctx context.Context, id string) (map[string]any, error) {
+	var model sessionModel
+	b, err := s.engine.Context(ctx).Where("id = ?", id).Get(&model)
+	if err != nil || !b {
+		return nil, err
+	}
+	if model.ExpireAt <= time.Now().UnixMilli() {
+		return nil, nil
+	}
+	ret := make(map[string]any)
+	if err = json.Unmarshal([]byte(model.Data), &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (s *xormStore) Save(ctx context.Context, id string, values map[string]any, ttl time.Duration) error {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	model := sessionModel{
+		Id:       id,
+		Data:     string(data),
+		ExpireAt: time.Now().Add(ttl).UnixMilli(),
+	}
+	rows, err := s.engine.Context(ctx).Where("id = ?", id).Cols("data", "expire_at").Update(&model)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		_, err = s.engine.Context(ctx).Insert(&model)
+	}
+	return err
+}
+
+func (s *xormStore) Delete(ctx context.Context, id string) error {
+	_, err := s.engine.Context(ctx).Where("id = ?", id).Delete(new(sessionModel))
+	return err
+}
+
+func (s *xormStore) deleteExpired(ctx context.Context) {
+	_, err := s.engine.Context(ctx).Where("expire_at < ?", time.Now().UnixMilli()).Delete(new(sessionModel))
+	if err != nil {
+		logger.Logger.Errorf("delete expired session failed with err: %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/LeeZXin/zsf-utils/executor"
+	"github.com/LeeZXin/zsf/property/static"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
+	"sync"
+	"time"
+)
+
+// 文件输出
+// logger.appenders配置多个文件 每个文件有独立的路径、滚动策略、级别范围、格式和同步异步模式
+// 未配置时输出到./logs/application.log logger.file.enabled为false时不输出文件
+// 与kafka、nsq、loki等远程hook同时生效
+//
+// logger:
+//   appenders:
+//     - path: ./logs/application.log
+//       daily: true
+//     - path: ./logs/error.log
+//       minLevel: error
+//       async: true
+
+const (
+	defaultAppenderPath       = "./logs/application.log"
+	defaultAppenderMaxSize    = 100
+	defaultAppenderMaxBackups = 10
+	defaultAppenderMaxAge     = 20
+	defaultAsyncQueueSize     = 5000
+)
+
+type AppenderConfig struct {
+	// Path 日志文件位置
+	Path string `json:"path"`
+	// MaxSize 单文件最大容量 单位是MB
+	MaxSize int `json:"maxSize"`
+	// MaxBackups 最大保留过期文件个数
+	MaxBackups int `json:"maxBackups"`
+	// MaxAge 保留过期文件的最大时间间隔 单位是天
+	MaxAge int `json:"maxAge"`
+	// Compress 是否gzip压缩滚动日志 默认压缩
+	Compress *bool `json:"compress"`
+	// Daily 每天滚动一次
+	Daily bool `json:"daily"`
+	// MinLevel 输出的最低级别 如info 默认输出所有级别
+	MinLevel string `json:"minLevel"`
+	// MaxLevel 输出的最高级别 如warning
+	MaxLevel string `json:"maxLevel"`
+	// Pattern 文本格式 为空时使用logger.file.pattern
+	Pattern string `json:"pattern"`
+	// Format json或text 为空时使用logger.format
+	Format string `json:"format"`
+	// Async 异步写入
+	Async bool `json:"async"`
+	// QueueSize 异步队列大小
+	QueueSize int `json:"queueSize"`
+	// ExecutorNum 异步写入协程数
+	ExecutorNum int `json:"executorNum"`
+	// DiscardPolicy 队列满时的策略 abort丢弃 默认调用方写入
+	DiscardPolicy string `json:"discardPolicy"`
+}
+
+// appenderHook 按级别范围输出到文件
+type appenderHook struct {
+	levels    []logrus.Level
+	formatter logrus.Formatter
+	writer    io.Writer
+}
+
+func (h *appenderHook) Levels() []logrus.Level {
+	return h.levels
+}
+
+func (h *appenderHook) Fire(entry *logrus.Entry) error {
+	content, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = h.writer.Write(content)
+	return err
+}
+
+// newAppenders 读取logger.appenders 兼容logger.async配置
+func newAppenders() []logrus.Hook {
+	if static.Exists("logger.file.enabled") && !static.GetBool("logger.file.enabled") {
+		return nil
+	}
+	return buildAppenders(static.GetMapSlice("logger.appenders"), AppenderConfig{
+		Async:         static.GetBool("logger.async.enabled"),
+		QueueSize:     static.GetInt("logger.async.queueSize"),
+		ExecutorNum:   static.GetInt("logger.async.executorNum"),
+		DiscardPolicy: static.GetString("logger.async.discardPolicy"),
+	})
+}
+
+// buildAppenders 配置错误的appender跳过 没有可用的appender时使用默认配置
+func buildAppenders(items []map[string]any, defaultConfig AppenderConfig) []logrus.Hook {
+	ret := make([]logrus.Hook, 0, len(items))
+	for i, item := range items {
+		var cfg AppenderConfig
+		b, err := json.Marshal(item)
+		if err == nil {
+			err = json.Unmarshal(b, &cfg)
+		}
+		var hook logrus.Hook
+		if err == nil {
+			hook, err = NewAppender(cfg)
+		}
+		if err != nil {
+			printError("logger: invalid logger.appenders[%d], skip it: %v", i, err)
+			continue
+		}
+		ret = append(ret, hook)
+	}
+	if len(ret) == 0 {
+		if len(items) > 0 {
+			printError("logger: no valid logger.appenders, use default appender instead")
+		}
+		hook, _ := NewAppender(defaultConfig)
+		ret = append(ret, hook)
+	}
+	return ret
+}
+
+// NewAppender 创建文件输出hook
+func NewAppender(cfg AppenderConfig) (logrus.Hook, error) {
+	levels, err := levelRange(cfg.MinLevel, cfg.MaxLevel)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Path == "" {
+		cfg.Path = defaultAppenderPath
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = defaultAppenderMaxSize
+	}
+	if cfg.MaxBackups <= 0 {
+		cfg.MaxBackups = defaultAppenderMaxBackups
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = defaultAppenderMaxAge
+	}
+	pattern := cfg.Pattern
+	if pattern == "" {
+		pattern = static.GetString("logger.file.pattern")
+	}
+	formatter := buildFormatter(pattern, cfg.Format, false)
+	l := &lumberjack.Logger{
+		Filename:   cfg.Path,
+		MaxSize:    cfg.MaxSize,
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     cfg.MaxAge,
+		Compress:   cfg.Compress == nil || *cfg.Compress,
+	}
+	var writer io.Writer = l
+	if cfg.Daily {
+		writer = &dailyWriter{
+			l:   l,
+			day: dayOf(time.Now()),
+		}
+	}
+	if cfg.Async {
+		writer = newAsyncWrapper(writer, cfg)
+	}
+	return &appenderHook{
+		levels:    levels,
+		formatter: formatter,
+		writer:    writer,
+	}, nil
+}
+
+// levelRange logrus级别越严重值越小 minLevel为最低级别 maxLevel为最高级别
+func levelRange(minLevel, maxLevel string) ([]logrus.Level, error) {
+	least, most := logrus.TraceLevel, logrus.PanicLevel
+	if minLevel != "" {
+		level, err := logrus.ParseLevel(minLevel)
+		if err != nil {
+			return nil, err
+		}
+		least = level
+	}
+	if maxLevel != "" {
+		level, err := logrus.ParseLevel(maxLevel)
+		if err != nil {
+			return nil, err
+		}
+		most = level
+	}
+	ret := make([]logrus.Level, 0, len(logrus.AllLevels))
+	for _, level := range logrus.AllLevels {
+		if level >= most && level <= least {
+			ret = append(ret, level)
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("invalid level range: %s - %s", minLevel, maxLevel)
+	}
+	return ret, nil
+}
+
+// dailyWriter 跨天时先滚动文件
+type dailyWriter struct {
+	mu  sync.Mutex
+	l   *lumberjack.Logger
+	day int
+}
+
+func dayOf(t time.Time) int {
+	y, m, d := t.Date()
+	return y*10000 + int(m)*100 + d
+}
+
+func (w *dailyWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if day := dayOf(time.Now()); day != w.day {
+		w.day = day
+		if err := w.l.Rotate(); err != nil {
+			return 0, err
+		}
+	}
+	return w.l.Write(p)
+}
+
+type asyncWrapper struct {
+	l io.Writer
+	w *executor.Executor
+}
+
+func (w *asyncWrapper) Write(p []byte) (int, error) {
+	w.w.Execute(func() {
+		w.l.Write(p)
+	})
+	return len(p), nil
+}
+
+func newAsyncWrapper(writer io.Writer, cfg AppenderConfig) io.Writer {
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultAsyncQueueSize
+	}
+	var rejectStrategy executor.RejectStrategy
+	switch cfg.DiscardPolicy {
+	case "abort":
+		rejectStrategy = executor.AbortStrategy
+	default:
+		rejectStrategy = executor.CallerRunsStrategy
+	}
+	poolSize := cfg.ExecutorNum
+	if poolSize <= 0 {
+		poolSize = 1
+	}
+	w, _ := executor.NewExecutor(poolSize, queueSize, time.Minute, rejectStrategy)
+	return &asyncWrapper{
+		l: writer,
+		w: w,
+	}
+}
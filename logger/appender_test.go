@@ -0,0 +1,134 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAppender_LevelRange(t *testing.T) {
+	dir := t.TempDir()
+	appHook, err := NewAppender(AppenderConfig{
+		Path:     filepath.Join(dir, "application.log"),
+		MaxLevel: "warning",
+		Pattern:  "%level %msg%n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errHook, err := NewAppender(AppenderConfig{
+		Path:     filepath.Join(dir, "error.log"),
+		MinLevel: "error",
+		Pattern:  "%level %msg%n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	l.AddHook(appHook)
+	l.AddHook(errHook)
+	l.Info("a")
+	l.Warn("b")
+	l.Error("c")
+	app, _ := os.ReadFile(filepath.Join(dir, "application.log"))
+	if string(app) != "info a\nwarning b\n" {
+		t.Fatalf("unexpected application.log: %q", string(app))
+	}
+	e, _ := os.ReadFile(filepath.Join(dir, "error.log"))
+	if string(e) != "error c\n" {
+		t.Fatalf("unexpected error.log: %q", string(e))
+	}
+	if _, err = NewAppender(AppenderConfig{MinLevel: "error", MaxLevel: "info"}); err == nil {
+		t.Fatal("expected invalid level range")
+	}
+}
+
+func TestBuildAppenders_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	defaultConfig := AppenderConfig{
+		Path:    filepath.Join(dir, "default.log"),
+		Pattern: "%msg%n",
+	}
+	invalid := []map[string]any{
+		{"path": filepath.Join(dir, "a.log"), "minLevel": "unknown"},
+		{"path": filepath.Join(dir, "b.log"), "maxSize": "10m"},
+	}
+	// 没有可用的appender时使用默认配置
+	hooks := buildAppenders(invalid, defaultConfig)
+	if len(hooks) != 1 {
+		t.Fatalf("unexpected hooks: %d", len(hooks))
+	}
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	l.AddHook(hooks[0])
+	l.Info("a")
+	if content, _ := os.ReadFile(defaultConfig.Path); string(content) != "a\n" {
+		t.Fatalf("unexpected default.log: %q", string(content))
+	}
+	valid := map[string]any{"path": filepath.Join(dir, "c.log")}
+	if hooks = buildAppenders(append(invalid, valid), defaultConfig); len(hooks) != 1 {
+		t.Fatalf("unexpected hooks: %d", len(hooks))
+	}
+}
+
+func TestAppender_Daily(t *testing.T) {
+	dir := t.TempDir()
+	compress := false
+	hook, err := NewAppender(AppenderConfig{
+		Path:     filepath.Join(dir, "application.log"),
+		Daily:    true,
+		Compress: &compress,
+		Pattern:  "%msg%n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := hook.(*appenderHook).writer.(*dailyWriter)
+	_, _ = writer.Write([]byte("day1\n"))
+	// 模拟跨天
+	writer.day--
+	_, _ = writer.Write([]byte("day2\n"))
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Fatalf("expected rotated file, got %d files", len(entries))
+	}
+	for _, entry := range entries {
+		content, _ := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if entry.Name() == "application.log" && string(content) != "day2\n" {
+			t.Fatalf("unexpected content: %q", string(content))
+		}
+		if entry.Name() != "application.log" && (!strings.HasPrefix(entry.Name(), "application-") || string(content) != "day1\n") {
+			t.Fatalf("unexpected backup: %s %q", entry.Name(), string(content))
+		}
+	}
+}
+
+type errHook struct{}
+
+func (errHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (errHook) Fire(*logrus.Entry) error {
+	return errors.New("disk full")
+}
+
+func TestSafeHook(t *testing.T) {
+	buf := &bytes.Buffer{}
+	formatter, _ := NewPatternFormatter("%msg%n", false)
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	// 前面的hook失败不影响后续输出
+	l.AddHook(&safeHook{Hook: errHook{}})
+	l.AddHook(&safeHook{Hook: newWriterHook(buf, formatter)})
+	l.Info("a")
+	if buf.String() != "a\n" {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+}
@@ -15,9 +15,11 @@ import (
 	"github.com/segmentio/kafka-go"
 	"github.com/segmentio/kafka-go/sasl/plain"
 	"github.com/sirupsen/logrus"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -40,6 +42,9 @@ type LogContent struct {
 	Content    string `json:"content"`
 	TraceId    string `json:"traceId"`
 	InstanceId string `json:"instanceId"`
+	Caller     string `json:"caller,omitempty"`
+	// Fields entry.Data、MDC和错误堆栈
+	Fields map[string]any `json:"fields,omitempty"`
 }
 
 func newKafkaHook() logrus.Hook {
@@ -76,12 +81,13 @@ func newKafkaHook() logrus.Hook {
 	})
 	ret := &kafkaHook{
 		writer:    kw,
-		formatter: defaultFormatter,
+		formatter: newHookFormatter("logger.kafka.pattern"),
 	}
 	return ret
 }
 
 type kafkaHook struct {
+	// formatter 为nil时content为日志内容
 	formatter logrus.Formatter
 	writer    *kafka.Writer
 }
@@ -91,13 +97,15 @@ func (*kafkaHook) Levels() []logrus.Level {
 }
 
 func (k *kafkaHook) Fire(entry *logrus.Entry) error {
-	content, err := k.formatter.Format(entry)
+	content, err := formatContent(k.formatter, entry)
 	if err != nil {
 		return err
 	}
 	t, _ := time.Now().MarshalBinary()
-	v := newLogContent(string(content), "kafka", entry)
-	value, _ := json.Marshal(v)
+	value, err := marshalLogContent(newLogContent(content, "kafka", entry))
+	if err != nil {
+		return err
+	}
 	_ = k.writer.WriteMessages(context.Background(), kafka.Message{
 		Key:   t,
 		Value: value,
@@ -138,13 +146,14 @@ func newNsqHook() logrus.Hook {
 	ret := &nsqHook{
 		topic:            topic,
 		chunkExecuteFunc: chunkExecuteFunc,
-		formatter:        defaultFormatter,
+		formatter:        newHookFormatter("logger.nsq.pattern"),
 	}
 	return ret
 }
 
 type nsqHook struct {
-	topic            string
+	topic string
+	// formatter 为nil时content为日志内容
 	formatter        logrus.Formatter
 	chunkExecuteFunc taskutil.ChunkTaskExecuteFunc[[]byte]
 }
@@ -154,12 +163,14 @@ func (*nsqHook) Levels() []logrus.Level {
 }
 
 func (k *nsqHook) Fire(entry *logrus.Entry) error {
-	content, err := k.formatter.Format(entry)
+	content, err := formatContent(k.formatter, entry)
+	if err != nil {
+		return err
+	}
+	value, err := marshalLogContent(newLogContent(content, "nsq", entry))
 	if err != nil {
 		return err
 	}
-	v := newLogContent(string(content), "nsq", entry)
-	value, _ := json.Marshal(v)
 	k.chunkExecuteFunc(value, len(value))
 	return nil
 }
@@ -172,16 +183,18 @@ func (l *nsqLogger) Output(int, string) error {
 }
 
 type lokiHook struct {
-	pushUrl          string
-	httpClient       *http.Client
-	formatter        logrus.Formatter
-	chunkExecuteFunc taskutil.ChunkTaskExecuteFunc[LogContent]
-	flusher          *executor.Executor
+	pushUrl string
+	// structuredMetadata 字段作为loki structured metadata上报 需loki 3.0以上
+	structuredMetadata bool
+	httpClient         *http.Client
+	formatter          logrus.Formatter
+	chunkExecuteFunc   taskutil.ChunkTaskExecuteFunc[LogContent]
+	flusher            *executor.Executor
 }
 
 type lokiStream struct {
 	Stream map[string]string `json:"stream"`
-	Values [][]string        `json:"values"`
+	Values [][]any           `json:"values"`
 }
 
 type lokiHttpRequest struct {
@@ -203,11 +216,20 @@ func newLokiHook() logrus.Hook {
 		queueSize = 1024
 	}
 	flusher, _ := executor.NewExecutor(poolSize, queueSize, time.Minute, executor.CallerRunsStrategy)
+	formatter := newHookFormatter("logger.loki.pattern")
+	if formatter == nil {
+		if static.GetString("logger.format") == JsonFormat {
+			formatter = &jsonLogFormatter{disableTime: true}
+		} else {
+			formatter = &lokiLogFormatter{}
+		}
+	}
 	h := &lokiHook{
-		pushUrl:    pushUrl,
-		formatter:  &lokiLogFormatter{},
-		httpClient: httputil.NewHttpClient(),
-		flusher:    flusher,
+		pushUrl:            pushUrl,
+		structuredMetadata: static.GetBool("logger.loki.structuredMetadata"),
+		formatter:          formatter,
+		httpClient:         httputil.NewHttpClient(),
+		flusher:            flusher,
 	}
 	chunkExecuteFunc, chunkFlushFunc, chunkStopFunc, _ := taskutil.RunChunkTask[LogContent](1024, func(logList []taskutil.Chunk[LogContent]) {
 		h.flusher.Execute(func() {
@@ -234,7 +256,7 @@ func newLokiHook() logrus.Hook {
 	return h
 }
 
-func (*lokiHook) convert2Stream(logs []LogContent) lokiStream {
+func (k *lokiHook) convert2Stream(logs []LogContent) lokiStream {
 	stream := map[string]string{
 		"env":        logs[0].Env,
 		"region":     logs[0].Region,
@@ -243,11 +265,15 @@ func (*lokiHook) convert2Stream(logs []LogContent) lokiStream {
 		"appId":      logs[0].AppId,
 		"instanceId": logs[0].InstanceId,
 	}
-	values := listutil.MapNe(logs, func(t LogContent) []string {
-		return []string{
+	values := listutil.MapNe(logs, func(t LogContent) []any {
+		ret := []any{
 			strconv.FormatInt(time.UnixMilli(t.Timestamp).UnixNano(), 10),
 			t.Content,
 		}
+		if k.structuredMetadata && len(t.Fields) > 0 {
+			ret = append(ret, lokiMetadata(t.Fields))
+		}
+		return ret
 	})
 	return lokiStream{
 		Stream: stream,
@@ -255,6 +281,21 @@ func (*lokiHook) convert2Stream(logs []LogContent) lokiStream {
 	}
 }
 
+// lokiMetadata structured metadata的key需符合label命名规则
+func lokiMetadata(fields map[string]any) map[string]string {
+	ret := make(map[string]string, len(fields))
+	for k, v := range StringFields(fields) {
+		key := []byte(k)
+		for i, c := range key {
+			if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+				key[i] = '_'
+			}
+		}
+		ret[string(key)] = v
+	}
+	return ret
+}
+
 func (*lokiHook) Levels() []logrus.Level {
 	return logrus.AllLevels
 }
@@ -268,6 +309,64 @@ func (k *lokiHook) Fire(entry *logrus.Entry) error {
 	return nil
 }
 
+// newHookFormatter 配置了pattern时使用pattern格式化 否则返回nil
+func newHookFormatter(patternKey string) logrus.Formatter {
+	if pattern := static.GetString(patternKey); pattern != "" {
+		return newPatternFormatter(pattern, false)
+	}
+	return nil
+}
+
+func formatContent(formatter logrus.Formatter, entry *logrus.Entry) (string, error) {
+	if formatter == nil {
+		return entry.Message, nil
+	}
+	content, err := formatter.Format(entry)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// writerHook 以独立的格式输出到writer 如控制台
+type writerHook struct {
+	mu        sync.Mutex
+	writer    io.Writer
+	formatter logrus.Formatter
+}
+
+func newWriterHook(writer io.Writer, formatter logrus.Formatter) logrus.Hook {
+	return &writerHook{
+		writer:    writer,
+		formatter: formatter,
+	}
+}
+
+func (*writerHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *writerHook) Fire(entry *logrus.Entry) error {
+	content, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err = h.writer.Write(content)
+	return err
+}
+
+// marshalLogContent 字段无法序列化时转为字符串
+func marshalLogContent(content LogContent) ([]byte, error) {
+	ret, err := json.Marshal(content)
+	if err != nil {
+		content.Fields = stringAnyFields(content.Fields)
+		return json.Marshal(content)
+	}
+	return ret, nil
+}
+
 func newLogContent(content, sourceType string, entry *logrus.Entry) LogContent {
 	return LogContent{
 		Timestamp:  entry.Time.UnixMilli(),
@@ -282,5 +381,7 @@ func newLogContent(content, sourceType string, entry *logrus.Entry) LogContent {
 		Content:    content,
 		TraceId:    GetTraceId(entry.Context),
 		InstanceId: common.GetInstanceId(),
+		Caller:     entryCaller(entry),
+		Fields:     EntryFields(entry),
 	}
 }
@@ -0,0 +1,161 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+)
+
+// json格式日志
+// logger.format为json时使用 entry.Data、MDC和错误堆栈作为顶层字段输出
+// 与固定字段重名的字段加上fields.前缀 错误堆栈输出为<字段名>.stack
+
+const (
+	TextFormat = "text"
+	JsonFormat = "json"
+
+	timeKey   = "time"
+	levelKey  = "level"
+	callerKey = "caller"
+	msgKey    = "msg"
+
+	stackSuffix = ".stack"
+)
+
+type jsonLogFormatter struct {
+	// disableTime loki默认展示时间戳
+	disableTime bool
+}
+
+// Format 格式化
+func (l *jsonLogFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	buffer := entry.Buffer
+	if buffer == nil {
+		buffer = &bytes.Buffer{}
+	}
+	fields := EntryFields(entry)
+	data := make(map[string]any, len(fields)+4)
+	for k, v := range fields {
+		switch k {
+		case timeKey, levelKey, callerKey, msgKey:
+			data["fields."+k] = v
+		default:
+			data[k] = v
+		}
+	}
+	if !l.disableTime {
+		data[timeKey] = entry.Time.Format(defaultTimeFormat)
+	}
+	data[levelKey] = entry.Level.String()
+	if caller := entryCaller(entry); caller != "" {
+		data[callerKey] = caller
+	}
+	data[msgKey] = entry.Message
+	b, err := marshalJson(data)
+	if err != nil {
+		// 字段无法序列化时转为字符串
+		b, err = marshalJson(stringAnyFields(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal log fields: %w", err)
+		}
+	}
+	buffer.Write(b)
+	return buffer.Bytes(), nil
+}
+
+func marshalJson(v any) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func stringAnyFields(fields map[string]any) map[string]any {
+	ret := make(map[string]any, len(fields))
+	for k, v := range StringFields(fields) {
+		ret[k] = v
+	}
+	return ret
+}
+
+// EntryFields 日志的结构化字段 包括MDC、entry.Data 错误拆分为错误信息和<字段名>.stack
+func EntryFields(entry *logrus.Entry) map[string]any {
+	ret := make(map[string]any, len(entry.Data)+4)
+	for k, v := range getEntryMDC(entry.Context) {
+		ret[k] = v
+	}
+	for k, v := range entry.Data {
+		err, b := v.(error)
+		if !b {
+			ret[k] = v
+			continue
+		}
+		msg, stack := errorStack(err)
+		ret[k] = msg
+		if stack != "" {
+			ret[k+stackSuffix] = stack
+		}
+	}
+	return ret
+}
+
+// StringFields 结构化字段转为字符串 用于loki structured metadata等只支持字符串的场景
+func StringFields(fields map[string]any) map[string]string {
+	ret := make(map[string]string, len(fields))
+	for k, v := range fields {
+		switch t := v.(type) {
+		case string:
+			ret[k] = t
+		case fmt.Stringer:
+			ret[k] = t.String()
+		case int:
+			ret[k] = strconv.Itoa(t)
+		case int64:
+			ret[k] = strconv.FormatInt(t, 10)
+		case bool:
+			ret[k] = strconv.FormatBool(t)
+		default:
+			if b, err := json.Marshal(v); err == nil {
+				ret[k] = string(b)
+			} else {
+				ret[k] = fmt.Sprint(v)
+			}
+		}
+	}
+	return ret
+}
+
+func getEntryMDC(ctx context.Context) MDC {
+	if ctx == nil {
+		return nil
+	}
+	return GetMDC(ctx)
+}
+
+func entryCaller(entry *logrus.Entry) string {
+	if entry.Caller == nil {
+		return ""
+	}
+	return splitFilePath(entry.Caller.File) + ":" + strconv.Itoa(entry.Caller.Line)
+}
+
+// errorStack 拆分错误信息和堆栈 支持%+v输出堆栈的错误和错误信息中带调用栈的错误
+func errorStack(err error) (string, string) {
+	msg := err.Error()
+	if _, b := err.(fmt.Formatter); b {
+		if verbose := fmt.Sprintf("%+v", err); verbose != msg {
+			return msg, verbose
+		}
+	}
+	if i := strings.IndexByte(msg, '\n'); i >= 0 {
+		return msg[:i], strings.TrimSpace(msg[i+1:])
+	}
+	return msg, ""
+}
@@ -0,0 +1,70 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"testing"
+)
+
+func TestJsonLogFormatter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := logrus.New()
+	l.SetOutput(buf)
+	l.SetReportCaller(true)
+	l.SetFormatter(&jsonLogFormatter{})
+	ctx := AppendToMDC(context.Background(), map[string]string{TraceId: "abc", Tenant: "t1"})
+	l.WithContext(ctx).
+		WithField("orderId", 12).
+		WithField("level", "custom").
+		WithField("stack", "user").
+		WithError(errors.New("failed\n\tmain.go:10")).
+		WithField("cause", errors.New("timeout\n\tdb.go:20")).
+		Error("create order")
+	var ret map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &ret); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{
+		"msg":          "create order",
+		"level":        "error",
+		"fields.level": "custom",
+		"orderId":      float64(12),
+		TraceId:        "abc",
+		Tenant:         "t1",
+		"error":        "failed",
+		"error.stack":  "main.go:10",
+		"cause":        "timeout",
+		"cause.stack":  "db.go:20",
+		"stack":        "user",
+	}
+	for k, v := range expected {
+		if ret[k] != v {
+			t.Fatalf("expected %s=%v, got %v", k, v, ret[k])
+		}
+	}
+	if !strings.HasPrefix(ret["caller"].(string), "logger/json_test.go:") {
+		t.Fatalf("unexpected caller: %v", ret["caller"])
+	}
+	// 无法序列化的字段转为字符串
+	buf.Reset()
+	l.WithField("ch", make(chan int)).Info("x")
+	if err := json.Unmarshal(buf.Bytes(), &ret); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDefaultLogFormatter_Fields(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := logrus.New()
+	l.SetOutput(buf)
+	l.SetReportCaller(true)
+	l.SetFormatter(defaultFormatter)
+	l.WithField("b", 2).WithField("a", "x").Info("hello")
+	if !strings.HasSuffix(buf.String(), "hello a=x b=2\n") {
+		t.Fatalf("unexpected log: %s", buf.String())
+	}
+}
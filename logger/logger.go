@@ -3,16 +3,14 @@ package logger
 import (
 	"bytes"
 	"fmt"
-	"github.com/LeeZXin/zsf-utils/executor"
 	"github.com/LeeZXin/zsf/env"
 	"github.com/LeeZXin/zsf/property/static"
 	"github.com/sirupsen/logrus"
-	"gopkg.in/natefinch/lumberjack.v2"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
-	"time"
 )
 
 // 日志logrus格式封装
@@ -42,8 +40,10 @@ func (l *defaultLogFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 		}
 	}
 	ts := entry.Time.Format(defaultTimeFormat)
-	logStr := fmt.Sprintf("%s [%s] [%s:%d] [%s] %s\n", ts, entry.Level, splitFilePath(entry.Caller.File), entry.Caller.Line, traceId, entry.Message)
+	logStr := fmt.Sprintf("%s [%s] [%s:%d] [%s] %s", ts, entry.Level, splitFilePath(entry.Caller.File), entry.Caller.Line, traceId, entry.Message)
 	buffer.WriteString(logStr)
+	writeFields(buffer, entry)
+	buffer.WriteByte('\n')
 	return buffer.Bytes(), nil
 }
 
@@ -65,11 +65,38 @@ func (l *lokiLogFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 		}
 	}
 	// 去掉时间戳 loki默认展示时间戳
-	logStr := fmt.Sprintf("[%s:%d] [level=%s] [traceId=%s] %s\n", splitFilePath(entry.Caller.File), entry.Caller.Line, entry.Level, traceId, entry.Message)
+	logStr := fmt.Sprintf("[%s:%d] [level=%s] [traceId=%s] %s", splitFilePath(entry.Caller.File), entry.Caller.Line, entry.Level, traceId, entry.Message)
 	buffer.WriteString(logStr)
+	writeFields(buffer, entry)
+	buffer.WriteByte('\n')
 	return buffer.Bytes(), nil
 }
 
+// writeFields 追加entry.Data 错误堆栈另起一行
+func writeFields(buffer *bytes.Buffer, entry *logrus.Entry) {
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	stacks := make([]string, 0, 1)
+	for _, k := range keys {
+		v := entry.Data[k]
+		if err, b := v.(error); b {
+			var stack string
+			v, stack = errorStack(err)
+			if stack != "" {
+				stacks = append(stacks, stack)
+			}
+		}
+		fmt.Fprintf(buffer, " %s=%v", k, v)
+	}
+	for _, stack := range stacks {
+		buffer.WriteByte('\n')
+		buffer.WriteString(stack)
+	}
+}
+
 func splitFilePath(path string) string {
 	split := strings.Split(path, string(os.PathSeparator))
 	if len(split) < 2 {
@@ -82,79 +109,93 @@ func splitFilePath(path string) string {
 func init() {
 	Logger = logrus.New()
 	Logger.SetReportCaller(true)
-	Logger.SetFormatter(defaultFormatter)
+	// 所有输出都通过hook 各自格式化
+	Logger.SetFormatter(nopFormatter{})
+	Logger.SetOutput(io.Discard)
 	Logger.SetLevel(logrus.InfoLevel)
+	// 控制台最先输出 单独格式化 可使用不同的pattern和颜色
+	if env.GetEnv() != "prd" {
+		addHook(newWriterHook(os.Stdout, newFormatter("logger.console.pattern", static.GetBool("logger.console.color"))))
+	}
 	if static.GetBool("logger.kafka.enabled") {
-		Logger.AddHook(newKafkaHook())
+		addHook(newKafkaHook())
 	}
 	if static.GetBool("logger.nsq.enabled") {
-		Logger.AddHook(newNsqHook())
+		addHook(newNsqHook())
 	}
 	if static.GetBool("logger.loki.enabled") {
-		Logger.AddHook(newLokiHook())
+		addHook(newLokiHook())
 	}
-	switch env.GetEnv() {
-	case "prd":
-		Logger.SetOutput(newLogWriter())
-	default:
-		Logger.SetOutput(io.MultiWriter(os.Stdout, newLogWriter()))
+	for _, appender := range newAppenders() {
+		addHook(appender)
 	}
 }
 
-type asyncWrapper struct {
-	l *lumberjack.Logger
-	w *executor.Executor
+// addHook logrus遇到hook返回错误时不再执行后续hook 单个输出失败不能影响其他输出
+func addHook(hook logrus.Hook) {
+	Logger.AddHook(&safeHook{Hook: hook})
 }
 
-func (w *asyncWrapper) Write(p []byte) (int, error) {
-	w.w.Execute(func() {
-		w.l.Write(p)
-	})
-	return len(p), nil
+// safeHook 自行输出错误 始终返回nil
+type safeHook struct {
+	logrus.Hook
 }
 
-func newLogWriter() io.Writer {
-	if static.GetBool("logger.loki.enabled") {
-		return io.Discard
-	}
-	if static.GetBool("logger.async.enabled") {
-		return newAsyncWrapper()
+func (h *safeHook) Fire(entry *logrus.Entry) error {
+	if err := h.Hook.Fire(entry); err != nil {
+		printError("logger: failed to fire hook: %v", err)
 	}
-	return newLumberjackLogger()
+	return nil
 }
 
-func newLumberjackLogger() *lumberjack.Logger {
-	return &lumberjack.Logger{
-		Filename:   "./logs/application.log", //日志文件位置
-		MaxSize:    100,                      // 单文件最大容量,单位是MB
-		MaxBackups: 10,                       // 最大保留过期文件个数
-		MaxAge:     20,                       // 保留过期文件的最大时间间隔,单位是天
-		Compress:   true,                     // 是否需要压缩滚动日志, 使用的 gzip 压缩
-	}
+// nopFormatter logger本身不输出
+type nopFormatter struct{}
+
+func (nopFormatter) Format(*logrus.Entry) ([]byte, error) {
+	return nil, nil
 }
 
-func newAsyncWrapper() io.Writer {
-	queueSize := static.GetInt("logger.async.queueSize")
-	if queueSize <= 0 {
-		queueSize = 5000
-	}
-	var rejectStrategy executor.RejectStrategy
-	discardPolicy := static.GetString("logger.async.discardPolicy")
-	switch discardPolicy {
-	case "abort":
-		rejectStrategy = executor.AbortStrategy
-		break
-	default:
-		rejectStrategy = executor.CallerRunsStrategy
-		break
-	}
-	poolSize := static.GetInt("logger.async.executorNum")
-	if poolSize <= 0 {
-		poolSize = 1
-	}
-	w, _ := executor.NewExecutor(poolSize, queueSize, time.Minute, rejectStrategy)
-	return &asyncWrapper{
-		l: newLumberjackLogger(),
-		w: w,
+func newFormatter(patternKey string, color bool) logrus.Formatter {
+	return buildFormatter(static.GetString(patternKey), "", color)
+}
+
+// buildFormatter 输出的格式 优先使用该输出的pattern
+// 其次format或logger.format为json时输出json 再次使用logger.pattern 默认文本格式
+func buildFormatter(pattern, format string, color bool) logrus.Formatter {
+	if pattern != "" {
+		return newPatternFormatter(pattern, color)
+	}
+	if format == "" {
+		format = static.GetString("logger.format")
+	}
+	if format == JsonFormat {
+		return &jsonLogFormatter{}
+	}
+	if pattern = static.GetString("logger.pattern"); pattern != "" {
+		return newPatternFormatter(pattern, color)
 	}
+	if color {
+		return newPatternFormatter(DefaultColorPattern, true)
+	}
+	return defaultFormatter
+}
+
+// newPatternFormatter pattern有误时输出错误并使用默认pattern 避免在init中panic
+func newPatternFormatter(pattern string, color bool) logrus.Formatter {
+	ret, err := NewPatternFormatter(pattern, color)
+	if err == nil {
+		return ret
+	}
+	printError("logger: invalid pattern, use default pattern instead: %v", err)
+	if color {
+		ret, _ = NewPatternFormatter(DefaultColorPattern, true)
+	} else {
+		ret, _ = NewPatternFormatter(DefaultPattern, false)
+	}
+	return ret
+}
+
+// printError 输出到标准错误 logger自身出错时不能再通过logger输出
+func printError(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
 }
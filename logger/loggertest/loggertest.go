@@ -0,0 +1,22 @@
+package loggertest
+
+import (
+	"github.com/LeeZXin/zsf/logger"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"testing"
+)
+
+// 单元测试使用
+// 默认的文件输出写到当前目录的logs下 测试时会在源码目录生成日志文件
+
+// NewHook 替换logger.Logger的所有输出 返回的hook记录日志用于断言 测试结束后恢复
+func NewHook(t testing.TB) *test.Hook {
+	hook := new(test.Hook)
+	hooks := logger.Logger.ReplaceHooks(make(logrus.LevelHooks))
+	logger.Logger.AddHook(hook)
+	t.Cleanup(func() {
+		logger.Logger.ReplaceHooks(hooks)
+	})
+	return hook
+}
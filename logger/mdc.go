@@ -22,6 +22,9 @@ func (h MDC) Set(key, val string) {
 
 const (
 	TraceId = "z-trace-id"
+	SpanId  = "z-span-id"
+	Subject = "z-subject"
+	Tenant  = "z-tenant"
 )
 
 func GetMDC(ctx context.Context) MDC {
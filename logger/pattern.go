@@ -0,0 +1,335 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/LeeZXin/zsf/common"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 类logback的pattern布局
+// %d{2006-01-02 15:04:05.000} 时间 格式为go时间格式 %date同义
+// %level 日志级别 %LEVEL大写
+// %caller 文件:行号 %file 文件 %line 行号 %func 函数名
+// %X{key} MDC中的值 %X 全部MDC
+// %field{key} logrus字段 %fields 全部logrus字段和错误堆栈
+// %traceId 调用链id 为空时输出- %app 应用名 %instance 实例id
+// %msg 日志内容 %n 换行 %% 百分号
+// %-5level 左对齐补齐宽度 %5level 右对齐
+// %highlight(...) 按日志级别着色 %red(...) %green(...) %yellow(...) %blue(...) %magenta(...) %cyan(...) %gray(...) 固定颜色
+// 关闭颜色时只输出括号内的内容
+
+const (
+	DefaultPattern      = "%d [%level] [%caller] [%traceId] %msg%fields%n"
+	DefaultColorPattern = "%gray(%d) %highlight([%level]) %cyan([%caller]) [%traceId] %msg%fields%n"
+)
+
+var (
+	ansiColors = map[string]string{
+		"red":     "\x1b[31m",
+		"green":   "\x1b[32m",
+		"yellow":  "\x1b[33m",
+		"blue":    "\x1b[34m",
+		"magenta": "\x1b[35m",
+		"cyan":    "\x1b[36m",
+		"gray":    "\x1b[90m",
+	}
+	ansiReset = "\x1b[0m"
+)
+
+type patternNode func(*bytes.Buffer, *logrus.Entry)
+
+// PatternFormatter 按pattern格式化文本日志
+type PatternFormatter struct {
+	nodes []patternNode
+}
+
+// NewPatternFormatter color为true时输出ansi颜色 一般只用于控制台
+func NewPatternFormatter(pattern string, color bool) (*PatternFormatter, error) {
+	p := &patternParser{
+		pattern: pattern,
+		color:   color,
+	}
+	nodes, err := p.parse(false)
+	if err != nil {
+		return nil, err
+	}
+	return &PatternFormatter{
+		nodes: nodes,
+	}, nil
+}
+
+// Format 格式化
+func (f *PatternFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	buffer := entry.Buffer
+	if buffer == nil {
+		buffer = &bytes.Buffer{}
+	}
+	for _, node := range f.nodes {
+		node(buffer, entry)
+	}
+	return buffer.Bytes(), nil
+}
+
+type patternParser struct {
+	pattern string
+	pos     int
+	color   bool
+}
+
+// parse 解析到结尾 group为true时解析到右括号
+func (p *patternParser) parse(group bool) ([]patternNode, error) {
+	nodes := make([]patternNode, 0, 8)
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			s := literal.String()
+			nodes = append(nodes, func(buffer *bytes.Buffer, _ *logrus.Entry) {
+				buffer.WriteString(s)
+			})
+			literal.Reset()
+		}
+	}
+	for p.pos < len(p.pattern) {
+		c := p.pattern[p.pos]
+		switch {
+		case c == ')' && group:
+			p.pos++
+			flush()
+			return nodes, nil
+		case c == '\\' && p.pos+1 < len(p.pattern):
+			literal.WriteByte(p.pattern[p.pos+1])
+			p.pos += 2
+		case c == '%':
+			if p.pos+1 < len(p.pattern) && p.pattern[p.pos+1] == '%' {
+				literal.WriteByte('%')
+				p.pos += 2
+				continue
+			}
+			flush()
+			node, err := p.parseToken()
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, node)
+		default:
+			literal.WriteByte(c)
+			p.pos++
+		}
+	}
+	if group {
+		return nil, fmt.Errorf("pattern: %s missing )", p.pattern)
+	}
+	flush()
+	return nodes, nil
+}
+
+func (p *patternParser) parseToken() (patternNode, error) {
+	start := p.pos
+	// 跳过%
+	p.pos++
+	leftAlign := false
+	if p.pos < len(p.pattern) && p.pattern[p.pos] == '-' {
+		leftAlign = true
+		p.pos++
+	}
+	width := 0
+	for p.pos < len(p.pattern) && p.pattern[p.pos] >= '0' && p.pattern[p.pos] <= '9' {
+		width = width*10 + int(p.pattern[p.pos]-'0')
+		p.pos++
+	}
+	nameStart := p.pos
+	for p.pos < len(p.pattern) && isLetter(p.pattern[p.pos]) {
+		p.pos++
+	}
+	name := p.pattern[nameStart:p.pos]
+	var (
+		arg    string
+		hasArg bool
+	)
+	if p.pos < len(p.pattern) && p.pattern[p.pos] == '{' {
+		end := strings.IndexByte(p.pattern[p.pos:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("pattern: %s missing } at %d", p.pattern, p.pos)
+		}
+		arg, hasArg = p.pattern[p.pos+1:p.pos+end], true
+		p.pos += end + 1
+	}
+	var node patternNode
+	if _, b := ansiColors[name]; b || name == "highlight" {
+		if p.pos >= len(p.pattern) || p.pattern[p.pos] != '(' {
+			return nil, fmt.Errorf("pattern: %s missing ( after %%%s", p.pattern, name)
+		}
+		p.pos++
+		children, err := p.parse(true)
+		if err != nil {
+			return nil, err
+		}
+		node = p.colorNode(name, children)
+	} else {
+		var err error
+		node, err = tokenNode(name, arg, hasArg)
+		if err != nil {
+			return nil, fmt.Errorf("pattern: %s at %d: %w", p.pattern, start, err)
+		}
+	}
+	if width > 0 {
+		return padNode(node, width, leftAlign), nil
+	}
+	return node, nil
+}
+
+func (p *patternParser) colorNode(name string, children []patternNode) patternNode {
+	render := func(buffer *bytes.Buffer, entry *logrus.Entry) {
+		for _, child := range children {
+			child(buffer, entry)
+		}
+	}
+	if !p.color {
+		return render
+	}
+	return func(buffer *bytes.Buffer, entry *logrus.Entry) {
+		color := ansiColors[name]
+		if name == "highlight" {
+			color = levelColor(entry.Level)
+		}
+		buffer.WriteString(color)
+		render(buffer, entry)
+		buffer.WriteString(ansiReset)
+	}
+}
+
+func levelColor(level logrus.Level) string {
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel:
+		return ansiColors["red"]
+	case logrus.WarnLevel:
+		return ansiColors["yellow"]
+	case logrus.InfoLevel:
+		return ansiColors["blue"]
+	default:
+		return ansiColors["gray"]
+	}
+}
+
+func padNode(node patternNode, width int, leftAlign bool) patternNode {
+	return func(buffer *bytes.Buffer, entry *logrus.Entry) {
+		tmp := &bytes.Buffer{}
+		node(tmp, entry)
+		pad := width - tmp.Len()
+		if pad > 0 && !leftAlign {
+			buffer.WriteString(strings.Repeat(" ", pad))
+		}
+		buffer.Write(tmp.Bytes())
+		if pad > 0 && leftAlign {
+			buffer.WriteString(strings.Repeat(" ", pad))
+		}
+	}
+}
+
+func tokenNode(name, arg string, hasArg bool) (patternNode, error) {
+	switch name {
+	case "d", "date":
+		layout := defaultTimeFormat
+		if hasArg && arg != "" {
+			layout = arg
+		}
+		return func(buffer *bytes.Buffer, entry *logrus.Entry) {
+			buffer.WriteString(entry.Time.Format(layout))
+		}, nil
+	case "level", "p":
+		return func(buffer *bytes.Buffer, entry *logrus.Entry) {
+			buffer.WriteString(entry.Level.String())
+		}, nil
+	case "LEVEL":
+		return func(buffer *bytes.Buffer, entry *logrus.Entry) {
+			buffer.WriteString(strings.ToUpper(entry.Level.String()))
+		}, nil
+	case "caller":
+		return func(buffer *bytes.Buffer, entry *logrus.Entry) {
+			buffer.WriteString(entryCaller(entry))
+		}, nil
+	case "file":
+		return func(buffer *bytes.Buffer, entry *logrus.Entry) {
+			if entry.Caller != nil {
+				buffer.WriteString(splitFilePath(entry.Caller.File))
+			}
+		}, nil
+	case "line":
+		return func(buffer *bytes.Buffer, entry *logrus.Entry) {
+			if entry.Caller != nil {
+				buffer.WriteString(strconv.Itoa(entry.Caller.Line))
+			}
+		}, nil
+	case "func", "M":
+		return func(buffer *bytes.Buffer, entry *logrus.Entry) {
+			if entry.Caller != nil {
+				fn := entry.Caller.Function
+				buffer.WriteString(fn[strings.LastIndexByte(fn, '/')+1:])
+			}
+		}, nil
+	case "X":
+		if hasArg {
+			return func(buffer *bytes.Buffer, entry *logrus.Entry) {
+				buffer.WriteString(getEntryMDC(entry.Context).Get(arg))
+			}, nil
+		}
+		return func(buffer *bytes.Buffer, entry *logrus.Entry) {
+			mdc := getEntryMDC(entry.Context)
+			keys := make([]string, 0, len(mdc))
+			for k := range mdc {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for i, k := range keys {
+				if i > 0 {
+					buffer.WriteByte(' ')
+				}
+				buffer.WriteString(k + "=" + mdc[k])
+			}
+		}, nil
+	case "field":
+		if !hasArg {
+			return nil, fmt.Errorf("%%field requires {key}")
+		}
+		return func(buffer *bytes.Buffer, entry *logrus.Entry) {
+			if v, b := entry.Data[arg]; b {
+				if err, b := v.(error); b {
+					v, _ = errorStack(err)
+				}
+				fmt.Fprint(buffer, v)
+			}
+		}, nil
+	case "fields":
+		return writeFields, nil
+	case "traceId":
+		return func(buffer *bytes.Buffer, entry *logrus.Entry) {
+			buffer.WriteString(GetTraceId(entry.Context))
+		}, nil
+	case "app":
+		return func(buffer *bytes.Buffer, _ *logrus.Entry) {
+			buffer.WriteString(common.GetApplicationName())
+		}, nil
+	case "instance":
+		return func(buffer *bytes.Buffer, _ *logrus.Entry) {
+			buffer.WriteString(common.GetInstanceId())
+		}, nil
+	case "msg", "m", "message":
+		return func(buffer *bytes.Buffer, entry *logrus.Entry) {
+			buffer.WriteString(entry.Message)
+		}, nil
+	case "n":
+		return func(buffer *bytes.Buffer, _ *logrus.Entry) {
+			buffer.WriteByte('\n')
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown token %%%s", name)
+	}
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
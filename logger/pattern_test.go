@@ -0,0 +1,82 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"testing"
+	"time"
+)
+
+func TestPatternFormatter(t *testing.T) {
+	ctx := AppendToMDC(context.Background(), map[string]string{TraceId: "abc", Tenant: "t1"})
+	entry := &logrus.Entry{
+		Context: ctx,
+		Time:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Level:   logrus.WarnLevel,
+		Message: "hello",
+		Data:    logrus.Fields{"orderId": 12, "error": errors.New("failed\n\tmain.go:10")},
+	}
+	tests := []struct {
+		pattern  string
+		color    bool
+		expected string
+	}{
+		{
+			pattern:  "%d{2006-01-02} [%-7level] [%X{z-tenant}] [%traceId] 100%% %msg%n",
+			expected: "2024-01-02 [warning] [t1] [abc] 100% hello\n",
+		},
+		{
+			pattern:  "[%5LEVEL] %field{orderId} %field{error}|%field{none}",
+			expected: "[WARNING] 12 failed|",
+		},
+		{
+			pattern:  "%msg%fields",
+			expected: "hello error=failed orderId=12\nmain.go:10",
+		},
+		{
+			pattern:  "%highlight([%level]) %msg",
+			color:    true,
+			expected: "\x1b[33m[warning]\x1b[0m hello",
+		},
+		{
+			pattern:  "%highlight([%level]) %msg",
+			expected: "[warning] hello",
+		},
+	}
+	for _, test := range tests {
+		f, err := NewPatternFormatter(test.pattern, test.color)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Buffer = &bytes.Buffer{}
+		ret, _ := f.Format(entry)
+		if string(ret) != test.expected {
+			t.Fatalf("pattern %q expected %q, got %q", test.pattern, test.expected, string(ret))
+		}
+	}
+	for _, pattern := range []string{"%unknown", "%red(abc", "%field", "%X{abc"} {
+		if _, err := NewPatternFormatter(pattern, false); err == nil {
+			t.Fatalf("expected err for pattern %q", pattern)
+		}
+	}
+}
+
+func TestNewPatternFormatter_Fallback(t *testing.T) {
+	entry := &logrus.Entry{
+		Time:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Level:   logrus.InfoLevel,
+		Message: "hello",
+	}
+	// 配置错误时不panic 使用默认pattern
+	content, err := newPatternFormatter("%unknown %msg", false).Format(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := NewPatternFormatter(DefaultPattern, false)
+	want, _ := expected.Format(&logrus.Entry{Time: entry.Time, Level: entry.Level, Message: entry.Message})
+	if string(content) != string(want) {
+		t.Fatalf("expected %q, got %q", string(want), string(content))
+	}
+}
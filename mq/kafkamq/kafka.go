@@ -3,11 +3,15 @@ package kafkamq
 import (
 	"context"
 	"errors"
-	"github.com/LeeZXin/zsf-utils/idutil"
 	"github.com/LeeZXin/zsf-utils/threadutil"
+	"github.com/LeeZXin/zsf/async"
 	"github.com/LeeZXin/zsf/logger"
+	"github.com/LeeZXin/zsf/rpcheader"
+	"github.com/LeeZXin/zsf/tracing"
 	"github.com/segmentio/kafka-go"
 	"github.com/segmentio/kafka-go/sasl/scram"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -123,14 +127,15 @@ func (c *Consumer) Consume(consumer func(context.Context, int64, []byte) error,
 	}
 	c.startOnce.Do(func() {
 		for i := 0; i < o.ExecutorsNum; i++ {
-			go func() {
+			// 消费协程计入async.InFlight
+			async.Go(context.Background(), func(context.Context) {
 				logger.Logger.Infof("start consume topic: %s, autoCommit: %v, groupId: %s", c.config.Topic, o.AutoCommit, c.config.GroupId)
 				if o.AutoCommit {
 					c.consumeAutoCommit(consumer)
 				} else {
 					c.consumeNotAutoCommit(consumer)
 				}
-			}()
+			}, async.WithName("kafka-"+c.config.Topic))
 		}
 	})
 }
@@ -150,12 +155,11 @@ func (c *Consumer) consumeAutoCommit(consumer func(context.Context, int64, []byt
 			time.Sleep(time.Second)
 			continue
 		}
-		mdcCtx := logger.AppendToMDC(context.Background(), map[string]string{
-			logger.TraceId: idutil.RandomUuid(),
-		})
+		mdcCtx, span := c.startSpan(m)
 		fatal := threadutil.RunSafe(func() {
-			_ = consumer(mdcCtx, m.Offset, m.Value)
+			err = consumer(mdcCtx, m.Offset, m.Value)
 		})
+		endSpan(span, err, fatal)
 		if fatal != nil {
 			logger.Logger.WithContext(mdcCtx).Error("failed to consume message:", err)
 			time.Sleep(100 * time.Millisecond)
@@ -177,12 +181,11 @@ func (c *Consumer) consumeNotAutoCommit(consumer func(context.Context, int64, []
 			time.Sleep(time.Second)
 			continue
 		}
-		mdcCtx := logger.AppendToMDC(context.Background(), map[string]string{
-			logger.TraceId: idutil.RandomUuid(),
-		})
+		mdcCtx, span := c.startSpan(m)
 		fatal := threadutil.RunSafe(func() {
 			err = consumer(mdcCtx, m.Offset, m.Value)
 		})
+		endSpan(span, err, fatal)
 		if fatal == nil && err == nil {
 			if err2 := c.reader.CommitMessages(c.ctx, m); err2 != nil {
 				logger.Logger.WithContext(mdcCtx).Error("failed to commit messages:", err)
@@ -195,6 +198,69 @@ func (c *Consumer) consumeNotAutoCommit(consumer func(context.Context, int64, []
 	}
 }
 
+// startSpan 解析消息头中的调用链和rpcheader并记录消费span
+func (c *Consumer) startSpan(m kafka.Message) (context.Context, *tracing.Span) {
+	headers := make(map[string]string, len(m.Headers))
+	for _, header := range m.Headers {
+		if isPropagatedHeader(header.Key) {
+			headers[header.Key] = string(header.Value)
+		}
+	}
+	ctx := rpcheader.AppendToHeaders(context.Background(), headers)
+	ctx = rpcheader.StartSpan(ctx, headerGetter(m.Headers))
+	ctx, span := tracing.StartCurrentSpan(ctx, m.Topic+" process", tracing.ConsumerKind)
+	span.SetAttribute("messaging.system", "kafka")
+	span.SetAttribute("messaging.destination", m.Topic)
+	span.SetAttribute("messaging.kafka.consumer_group", c.config.GroupId)
+	span.SetAttribute("messaging.kafka.partition", strconv.Itoa(m.Partition))
+	span.SetAttribute("messaging.kafka.offset", strconv.FormatInt(m.Offset, 10))
+	return ctx, span
+}
+
+func endSpan(span *tracing.Span, err, fatal error) {
+	if fatal != nil {
+		span.SetError(fatal)
+	} else {
+		span.SetError(err)
+	}
+	span.End()
+}
+
+// headerGetter 从消息头解析调用链
+func headerGetter(headers []kafka.Header) func(string) string {
+	return func(key string) string {
+		for _, header := range headers {
+			if strings.EqualFold(header.Key, key) {
+				return string(header.Value)
+			}
+		}
+		return ""
+	}
+}
+
+// TraceHeaders 生产消息时传递调用链和rpcheader的消息头
+func TraceHeaders(ctx context.Context) []kafka.Header {
+	ret := make([]kafka.Header, 0, 8)
+	set := func(key, value string) {
+		ret = append(ret, kafka.Header{
+			Key:   key,
+			Value: []byte(value),
+		})
+	}
+	for k, v := range rpcheader.GetHeaders(ctx) {
+		if isPropagatedHeader(k) && k != rpcheader.TraceId {
+			set(k, v)
+		}
+	}
+	rpcheader.InjectSpanContext(ctx, set)
+	return ret
+}
+
+// isPropagatedHeader 通过消息头传递的Z-header 签名信息不传递
+func isPropagatedHeader(key string) bool {
+	return strings.HasPrefix(key, rpcheader.Prefix) && !strings.HasPrefix(key, rpcheader.AuthPrefix)
+}
+
 func (c *Consumer) Stop() {
 	c.stopOnce.Do(func() {
 		logger.Logger.Infof("stop consume topic: %s, groupId: %s", c.config.Topic, c.config.GroupId)
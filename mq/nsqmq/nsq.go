@@ -3,8 +3,9 @@ package nsqmq
 import (
 	"context"
 	"errors"
-	"github.com/LeeZXin/zsf-utils/idutil"
 	"github.com/LeeZXin/zsf/logger"
+	"github.com/LeeZXin/zsf/rpcheader"
+	"github.com/LeeZXin/zsf/tracing"
 	"github.com/nsqio/go-nsq"
 	"sync"
 	"time"
@@ -93,10 +94,19 @@ func (c *NsqConsumer) ConsumeLookupds(consumer func(context.Context, *nsq.Messag
 
 func (c *NsqConsumer) connect(consumer func(context.Context, *nsq.Message) error, targetType int) {
 	c.consumer.AddConcurrentHandlers(nsq.HandlerFunc(func(message *nsq.Message) error {
-		mdcCtx := logger.AppendToMDC(context.Background(), map[string]string{
-			logger.TraceId: idutil.RandomUuid(),
+		// nsq消息没有消息头 每条消息创建新的调用链
+		mdcCtx := rpcheader.StartSpan(context.Background(), func(string) string {
+			return ""
 		})
-		return consumer(mdcCtx, message)
+		mdcCtx, span := tracing.StartCurrentSpan(mdcCtx, c.config.Topic+" process", tracing.ConsumerKind)
+		defer span.End()
+		span.SetAttribute("messaging.system", "nsq")
+		span.SetAttribute("messaging.destination", c.config.Topic)
+		span.SetAttribute("messaging.nsq.channel", c.config.Channel)
+		span.SetAttribute("messaging.message_id", string(message.ID[:]))
+		err := consumer(mdcCtx, message)
+		span.SetError(err)
+		return err
 	}), c.config.ExecutorNums)
 	var err error
 	if targetType == 0 {
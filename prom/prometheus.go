@@ -3,7 +3,7 @@ package prom
 import "github.com/prometheus/client_golang/prometheus"
 
 // prometheus请求监控
-// httpServer、httpClient
+// httpServer、httpClient、async
 
 var (
 	HttpClientRequestTotal = prometheus.NewSummaryVec(prometheus.SummaryOpts{
@@ -11,13 +11,73 @@ var (
 		Help: "http client request summary",
 	}, []string{"target", "request", "code"})
 
+	HttpClientRetryTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_client_retry_total",
+		Help: "http client retry counter",
+	}, []string{"target", "reason", "attempt"})
+
+	HttpClientHedgeTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_client_hedge_total",
+		Help: "http client hedged request counter",
+	}, []string{"target", "event"})
+
+	HttpClientCacheTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_client_cache_total",
+		Help: "http client response cache counter",
+	}, []string{"target", "result"})
+
+	HttpClientBulkheadActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_client_bulkhead_active",
+		Help: "http client bulkhead concurrent calls",
+	}, []string{"target"})
+
+	HttpClientBulkheadQueued = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_client_bulkhead_queued",
+		Help: "http client bulkhead queued calls",
+	}, []string{"target"})
+
+	HttpClientBulkheadRejectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_client_bulkhead_rejected_total",
+		Help: "http client bulkhead rejected counter",
+	}, []string{"target", "reason"})
+
+	LbOutlierEjectionTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lb_outlier_ejection_total",
+		Help: "load balancer outlier ejection counter",
+	}, []string{"target", "instance"})
+
+	LbOutlierEjectedHosts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lb_outlier_ejected_hosts",
+		Help: "load balancer currently ejected hosts",
+	}, []string{"target"})
+
 	HttpServerRequestTotal = prometheus.NewSummaryVec(prometheus.SummaryOpts{
 		Name: "http_server_request_total",
 		Help: "http server request summary",
 	}, []string{"request", "code"})
+
+	AsyncInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "async_in_flight",
+		Help: "async goroutines in flight",
+	}, []string{"name"})
+
+	AsyncPanicTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "async_panic_total",
+		Help: "async goroutine panic counter",
+	}, []string{"name"})
 )
 
 func init() {
 	prometheus.MustRegister(HttpClientRequestTotal)
+	prometheus.MustRegister(HttpClientRetryTotal)
+	prometheus.MustRegister(HttpClientHedgeTotal)
+	prometheus.MustRegister(HttpClientCacheTotal)
+	prometheus.MustRegister(HttpClientBulkheadActive)
+	prometheus.MustRegister(HttpClientBulkheadQueued)
+	prometheus.MustRegister(HttpClientBulkheadRejectedTotal)
+	prometheus.MustRegister(LbOutlierEjectionTotal)
+	prometheus.MustRegister(LbOutlierEjectedHosts)
 	prometheus.MustRegister(HttpServerRequestTotal)
+	prometheus.MustRegister(AsyncInFlight)
+	prometheus.MustRegister(AsyncPanicTotal)
 }
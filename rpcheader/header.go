@@ -7,15 +7,19 @@ import (
 )
 
 const (
-	TraceId    = "Z-Trace-Id"
-	ApiVersion = "Z-Api-Version"
-	Prefix     = "Z-"
-	Source     = "Z-Source"
-	Target     = "Z-Target"
-	AuthSign   = "Z-Auth-Sign"
-	AuthTs     = "Z-Auth-Ts"
-	Region     = "Z-Region"
-	Zone       = "Z-Zone"
+	TraceId     = "Z-Trace-Id"
+	ApiVersion  = "Z-Api-Version"
+	Prefix      = "Z-"
+	Source      = "Z-Source"
+	Target      = "Z-Target"
+	AuthPrefix  = "Z-Auth-"
+	AuthSign    = "Z-Auth-Sign"
+	AuthTs      = "Z-Auth-Ts"
+	AuthVersion = "Z-Auth-Version"
+	AuthKeyId   = "Z-Auth-Key-Id"
+	AuthNonce   = "Z-Auth-Nonce"
+	Region      = "Z-Region"
+	Zone        = "Z-Zone"
 )
 
 type headerKey struct{}
@@ -91,11 +95,18 @@ func NewCtxFromOldCtx(ctx context.Context) (context.Context, string) {
 	if uuid == "" {
 		uuid = idutil.RandomUuid()
 	}
-	ctx = logger.AppendToMDC(context.Background(), map[string]string{
+	mdc := map[string]string{
 		logger.TraceId: uuid,
-	})
-	ctx = AppendToHeaders(ctx, map[string]string{
+	}
+	newCtx := context.Background()
+	// 保留调用链
+	if sc, b := GetSpanContext(ctx); b {
+		newCtx = WithSpanContext(newCtx, sc)
+		mdc[logger.SpanId] = sc.SpanId
+	}
+	newCtx = logger.AppendToMDC(newCtx, mdc)
+	newCtx = AppendToHeaders(newCtx, map[string]string{
 		TraceId: uuid,
 	})
-	return ctx, uuid
+	return newCtx, uuid
 }
@@ -0,0 +1,248 @@
+package rpcheader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/LeeZXin/zsf/logger"
+	"strings"
+)
+
+// 调用链传递
+// 兼容W3C Trace Context(traceparent/tracestate)、B3和Z-Trace-Id
+// 解析优先级 traceparent > b3 > X-B3-* > Z-Trace-Id 向下游同时传递W3C、B3和Z-Trace-Id
+// 每一跳创建新的spanId 上游的spanId作为parentSpanId
+
+const (
+	TraceParent    = "traceparent"
+	TraceState     = "tracestate"
+	B3             = "b3"
+	B3TraceId      = "X-B3-TraceId"
+	B3SpanId       = "X-B3-SpanId"
+	B3ParentSpanId = "X-B3-ParentSpanId"
+	B3Sampled      = "X-B3-Sampled"
+
+	traceParentVersion = "00"
+)
+
+type spanContextKey struct{}
+
+// SpanContext 调用链上下文
+type SpanContext struct {
+	// TraceId 32位16进制
+	TraceId string
+	// SpanId 16位16进制
+	SpanId       string
+	ParentSpanId string
+	Sampled      bool
+	// TraceState W3C tracestate 原样传递
+	TraceState string
+}
+
+func (s SpanContext) IsValid() bool {
+	return isValidId(s.TraceId, 32) && isValidId(s.SpanId, 16)
+}
+
+// Child 创建子span
+func (s SpanContext) Child() SpanContext {
+	return SpanContext{
+		TraceId:      s.TraceId,
+		SpanId:       NewSpanId(),
+		ParentSpanId: s.SpanId,
+		Sampled:      s.Sampled,
+		TraceState:   s.TraceState,
+	}
+}
+
+// TraceParent W3C traceparent格式
+func (s SpanContext) TraceParent() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+	return traceParentVersion + "-" + s.TraceId + "-" + s.SpanId + "-" + flags
+}
+
+// NewRootSpanContext 创建新的调用链 traceId为空时随机生成
+func NewRootSpanContext(traceId string) SpanContext {
+	if !isValidId(traceId, 32) {
+		traceId = NewTraceId()
+	}
+	return SpanContext{
+		TraceId: traceId,
+		SpanId:  NewSpanId(),
+		Sampled: true,
+	}
+}
+
+func NewTraceId() string {
+	return randomHex(16)
+}
+
+func NewSpanId() string {
+	return randomHex(8)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// isValidId 小写16进制且不全为0
+func isValidId(id string, size int) bool {
+	if len(id) != size {
+		return false
+	}
+	zero := true
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+		if c != '0' {
+			zero = false
+		}
+	}
+	return !zero
+}
+
+// normalizeTraceId 兼容64位的B3 traceId和uuid格式的Z-Trace-Id
+func normalizeTraceId(id string) string {
+	id = strings.ToLower(strings.ReplaceAll(id, "-", ""))
+	if len(id) == 16 {
+		id = strings.Repeat("0", 16) + id
+	}
+	return id
+}
+
+func GetSpanContext(ctx context.Context) (SpanContext, bool) {
+	if ctx == nil {
+		return SpanContext{}, false
+	}
+	ret, b := ctx.Value(spanContextKey{}).(SpanContext)
+	return ret, b
+}
+
+func WithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// ExtractSpanContext 从请求头解析上游调用链 返回的SpanId为上游的spanId
+func ExtractSpanContext(get func(string) string) (SpanContext, bool) {
+	if sc, b := parseTraceParent(get(TraceParent)); b {
+		sc.TraceState = get(TraceState)
+		return sc, true
+	}
+	if sc, b := parseB3Single(get(B3)); b {
+		return sc, true
+	}
+	sc := SpanContext{
+		TraceId:      normalizeTraceId(get(B3TraceId)),
+		SpanId:       strings.ToLower(get(B3SpanId)),
+		ParentSpanId: strings.ToLower(get(B3ParentSpanId)),
+		Sampled:      get(B3Sampled) != "0",
+	}
+	if sc.IsValid() {
+		return sc, true
+	}
+	return SpanContext{}, false
+}
+
+func parseTraceParent(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	// 版本00只有4段
+	if parts[0] == traceParentVersion && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc := SpanContext{
+		TraceId: parts[1],
+		SpanId:  parts[2],
+		Sampled: flags[0]&1 == 1,
+	}
+	return sc, sc.IsValid()
+}
+
+// parseB3Single b3: {traceId}-{spanId}-{sampled}-{parentSpanId}
+func parseB3Single(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 2 {
+		return SpanContext{}, false
+	}
+	sc := SpanContext{
+		TraceId: normalizeTraceId(parts[0]),
+		SpanId:  strings.ToLower(parts[1]),
+		Sampled: true,
+	}
+	if len(parts) > 2 {
+		sc.Sampled = parts[2] != "0"
+	}
+	if len(parts) > 3 {
+		sc.ParentSpanId = strings.ToLower(parts[3])
+	}
+	return sc, sc.IsValid()
+}
+
+// StartSpan 解析上游调用链并创建当前服务的span
+// 写入Z-Trace-Id和日志MDC 有traceparent或B3时Z-Trace-Id与traceId一致 否则沿用上游的Z-Trace-Id
+func StartSpan(ctx context.Context, get func(string) string) context.Context {
+	var (
+		sc       SpanContext
+		zTraceId string
+	)
+	if parent, b := ExtractSpanContext(get); b {
+		sc = parent.Child()
+		zTraceId = sc.TraceId
+	} else {
+		zTraceId = get(TraceId)
+		sc = NewRootSpanContext(normalizeTraceId(zTraceId))
+		if zTraceId == "" {
+			zTraceId = sc.TraceId
+		}
+	}
+	ctx = WithSpanContext(ctx, sc)
+	ctx = AppendToHeaders(ctx, map[string]string{
+		TraceId: zTraceId,
+	})
+	return logger.AppendToMDC(ctx, map[string]string{
+		logger.TraceId: zTraceId,
+		logger.SpanId:  sc.SpanId,
+	})
+}
+
+// InjectSpanContext 向下游传递调用链 ctx中没有调用链时根据Z-Trace-Id创建
+func InjectSpanContext(ctx context.Context, set func(string, string)) {
+	zTraceId := GetHeaders(ctx).Get(TraceId)
+	sc, b := GetSpanContext(ctx)
+	if !b {
+		sc = NewRootSpanContext(normalizeTraceId(zTraceId))
+	}
+	if zTraceId == "" {
+		zTraceId = sc.TraceId
+	}
+	set(TraceId, zTraceId)
+	set(TraceParent, sc.TraceParent())
+	if sc.TraceState != "" {
+		set(TraceState, sc.TraceState)
+	}
+	set(B3TraceId, sc.TraceId)
+	set(B3SpanId, sc.SpanId)
+	if sc.ParentSpanId != "" {
+		set(B3ParentSpanId, sc.ParentSpanId)
+	}
+	if sc.Sampled {
+		set(B3Sampled, "1")
+	} else {
+		set(B3Sampled, "0")
+	}
+}
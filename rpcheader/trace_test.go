@@ -0,0 +1,86 @@
+package rpcheader
+
+import (
+	"context"
+	"github.com/LeeZXin/zsf/logger"
+	"net/http"
+	"testing"
+)
+
+func TestExtractSpanContext(t *testing.T) {
+	tests := []struct {
+		header  map[string]string
+		traceId string
+		spanId  string
+		sampled bool
+	}{
+		{
+			header:  map[string]string{TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", TraceState: "a=1"},
+			traceId: "4bf92f3577b34da6a3ce929d0e0e4736",
+			spanId:  "00f067aa0ba902b7",
+			sampled: true,
+		},
+		{
+			header:  map[string]string{B3: "a3ce929d0e0e4736-00f067aa0ba902b7-0"},
+			traceId: "0000000000000000a3ce929d0e0e4736",
+			spanId:  "00f067aa0ba902b7",
+		},
+		{
+			header:  map[string]string{B3TraceId: "4bf92f3577b34da6a3ce929d0e0e4736", B3SpanId: "00f067aa0ba902b7", B3Sampled: "1"},
+			traceId: "4bf92f3577b34da6a3ce929d0e0e4736",
+			spanId:  "00f067aa0ba902b7",
+			sampled: true,
+		},
+	}
+	for _, test := range tests {
+		header := make(http.Header)
+		for k, v := range test.header {
+			header.Set(k, v)
+		}
+		sc, b := ExtractSpanContext(header.Get)
+		if !b || sc.TraceId != test.traceId || sc.SpanId != test.spanId || sc.Sampled != test.sampled {
+			t.Fatalf("unexpected span context: %+v for %v", sc, test.header)
+		}
+	}
+	invalid := []string{
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+	}
+	for _, value := range invalid {
+		header := make(http.Header)
+		header.Set(TraceParent, value)
+		if _, b := ExtractSpanContext(header.Get); b {
+			t.Fatalf("expected invalid traceparent: %s", value)
+		}
+	}
+}
+
+func TestStartSpan(t *testing.T) {
+	header := make(http.Header)
+	header.Set(TraceParent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	header.Set(TraceId, "a1c9b1f4-0000-4000-8000-000000000001")
+	ctx := StartSpan(context.Background(), header.Get)
+	sc, _ := GetSpanContext(ctx)
+	if sc.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.ParentSpanId != "00f067aa0ba902b7" || !sc.IsValid() {
+		t.Fatalf("unexpected span context: %+v", sc)
+	}
+	if GetHeaders(ctx).Get(TraceId) != sc.TraceId || logger.GetTraceId(ctx) != sc.TraceId {
+		t.Fatalf("unexpected trace id: %s", GetHeaders(ctx).Get(TraceId))
+	}
+	out := make(http.Header)
+	InjectSpanContext(ctx, out.Set)
+	if out.Get(TraceParent) != "00-4bf92f3577b34da6a3ce929d0e0e4736-"+sc.SpanId+"-01" ||
+		out.Get(B3SpanId) != sc.SpanId || out.Get(B3ParentSpanId) != "00f067aa0ba902b7" {
+		t.Fatalf("unexpected injected header: %v", out)
+	}
+	// 只有Z-Trace-Id时沿用
+	header = make(http.Header)
+	header.Set(TraceId, "a1c9b1f4-0000-4000-8000-000000000001")
+	ctx = StartSpan(context.Background(), header.Get)
+	sc, _ = GetSpanContext(ctx)
+	if sc.TraceId != "a1c9b1f4000040008000000000000001" || GetHeaders(ctx).Get(TraceId) != "a1c9b1f4-0000-4000-8000-000000000001" {
+		t.Fatalf("unexpected span context: %+v", sc)
+	}
+}
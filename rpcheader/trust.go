@@ -0,0 +1,242 @@
+package rpcheader
+
+import (
+	"context"
+	"github.com/LeeZXin/zsf/logger"
+	"github.com/LeeZXin/zsf/property/static"
+	"net"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// header信任边界
+// 控制类header(Z-Source、Z-Api-Version等)影响路由和鉴权 只允许可信来源设置
+// 可信来源 直连地址在rpcheader.trust.cidrs内、通过mTLS校验或v2签名校验通过
+// 默认只信任本机地址 经过网关或负载均衡时直连地址为其内网地址 需按部署环境配置可信网段
+// 不可信来源只保留Z-Trace-Id等允许的header和白名单内的Z-Baggage 其余Z-header被删除或按rewrites改写
+// 不可信来源的Z-header同时从请求中删除 handler读不到被过滤的header
+// Z-Baggage格式 k1=v1,k2=v2 用于传递业务上下文
+//
+// 升级说明
+// 旧版本信任所有来源的Z-header 升级后未配置rpcheader.trust.cidrs时只信任本机地址 启动时会打印告警
+// 服务间直连或经过网关、sidecar调用时 需将调用方或网关所在网段配置到rpcheader.trust.cidrs
+// 例如 rpcheader.trust.cidrs: ["10.0.0.0/8", "127.0.0.0/8"]
+// 无法确定网段时可开启rpcheader.trust.mtls或改用v2签名 否则Z-Source、Z-Api-Version等header会被丢弃
+
+const (
+	Baggage = "Z-Baggage"
+
+	defaultMaxBaggageSize  = 1024
+	defaultMaxBaggageItems = 16
+)
+
+var (
+	defaultTrustedCIDRs = []string{"127.0.0.0/8", "::1/128"}
+
+	trustPolicy atomic.Pointer[TrustPolicy]
+)
+
+type TrustPolicy struct {
+	// TrustedCIDRs 可信的直连地址 默认只有本机地址
+	TrustedCIDRs []string
+	// TrustMTLS 客户端证书校验通过时可信
+	TrustMTLS bool
+	// UntrustedHeaders 除Z-Trace-Id外允许不可信来源设置的header
+	UntrustedHeaders []string
+	// Rewrites 不可信来源的header改写为固定值 如Z-Source改写为external
+	Rewrites map[string]string
+	// BaggageKeys 允许不可信来源传递的baggage 可信来源不限制
+	BaggageKeys []string
+	// MaxBaggageSize baggage最大字节数 超出的项被丢弃
+	MaxBaggageSize int
+	// MaxBaggageItems baggage最大项数
+	MaxBaggageItems int
+
+	nets        []*net.IPNet
+	allowed     map[string]struct{}
+	baggageKeys map[string]struct{}
+}
+
+func init() {
+	policy := TrustPolicy{
+		TrustedCIDRs:    defaultTrustedCIDRs,
+		TrustMTLS:       static.GetBool("rpcheader.trust.mtls"),
+		Rewrites:        static.GetStringMapString("rpcheader.trust.rewrites"),
+		BaggageKeys:     static.GetStringSlice("rpcheader.trust.baggageKeys"),
+		MaxBaggageSize:  static.GetInt("rpcheader.trust.maxBaggageSize"),
+		MaxBaggageItems: static.GetInt("rpcheader.trust.maxBaggageItems"),
+	}
+	if static.Exists("rpcheader.trust.cidrs") {
+		policy.TrustedCIDRs = static.GetStringSlice("rpcheader.trust.cidrs")
+	} else {
+		logger.Logger.Warn("rpcheader.trust.cidrs is not configured, only loopback is trusted and Z-headers from other addresses will be dropped, please configure rpcheader.trust.cidrs")
+	}
+	if static.Exists("rpcheader.trust.untrustedHeaders") {
+		policy.UntrustedHeaders = static.GetStringSlice("rpcheader.trust.untrustedHeaders")
+	}
+	SetTrustPolicy(policy)
+}
+
+// SetTrustPolicy 替换信任策略
+func SetTrustPolicy(policy TrustPolicy) {
+	if policy.MaxBaggageSize <= 0 {
+		policy.MaxBaggageSize = defaultMaxBaggageSize
+	}
+	if policy.MaxBaggageItems <= 0 {
+		policy.MaxBaggageItems = defaultMaxBaggageItems
+	}
+	policy.nets = make([]*net.IPNet, 0, len(policy.TrustedCIDRs))
+	for _, cidr := range policy.TrustedCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			logger.Logger.Errorf("rpcheader parse trusted cidr: %s failed with err: %v", cidr, err)
+			continue
+		}
+		policy.nets = append(policy.nets, ipNet)
+	}
+	policy.allowed = map[string]struct{}{TraceId: {}}
+	for _, h := range policy.UntrustedHeaders {
+		policy.allowed[canonicalKey(h)] = struct{}{}
+	}
+	rewrites := make(map[string]string, len(policy.Rewrites))
+	for k, v := range policy.Rewrites {
+		rewrites[canonicalKey(k)] = v
+	}
+	policy.Rewrites = rewrites
+	policy.baggageKeys = make(map[string]struct{}, len(policy.BaggageKeys))
+	for _, k := range policy.BaggageKeys {
+		policy.baggageKeys[k] = struct{}{}
+	}
+	trustPolicy.Store(&policy)
+}
+
+func GetTrustPolicy() *TrustPolicy {
+	return trustPolicy.Load()
+}
+
+// IsTrustedAddr 直连地址是否可信
+func (p *TrustPolicy) IsTrustedAddr(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, ipNet := range p.nets {
+		if ipNet.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterHeaders 按来源是否可信过滤入口header 返回新的Header
+func (p *TrustPolicy) FilterHeaders(headers Header, trusted bool) Header {
+	ret := make(Header, len(headers))
+	for k, v := range headers {
+		key := canonicalKey(k)
+		if key == Baggage {
+			if baggage := p.FilterBaggage(v, trusted); baggage != "" {
+				ret[key] = baggage
+			}
+			continue
+		}
+		if _, b := p.allowed[key]; trusted || b {
+			ret[key] = v
+		}
+	}
+	if !trusted {
+		for k, v := range p.Rewrites {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+// FilterBaggage 限制baggage的项数和大小 不可信来源只保留白名单内的key
+func (p *TrustPolicy) FilterBaggage(value string, trusted bool) string {
+	items := ParseBaggage(value)
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		if trusted {
+			keys = append(keys, k)
+		} else if _, b := p.baggageKeys[k]; b {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	ret := make(map[string]string, len(keys))
+	for _, k := range keys {
+		if len(ret) >= p.MaxBaggageItems {
+			break
+		}
+		ret[k] = items[k]
+		if len(FormatBaggage(ret)) > p.MaxBaggageSize {
+			delete(ret, k)
+		}
+	}
+	return FormatBaggage(ret)
+}
+
+// ParseBaggage 解析Z-Baggage 值经过url编码
+func ParseBaggage(value string) map[string]string {
+	ret := make(map[string]string, 8)
+	for _, item := range strings.Split(value, ",") {
+		k, v, b := strings.Cut(strings.TrimSpace(item), "=")
+		if !b {
+			continue
+		}
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(strings.TrimSpace(v)); err == nil {
+			ret[k] = unescaped
+		}
+	}
+	return ret
+}
+
+// FormatBaggage 按key排序输出
+func FormatBaggage(baggage map[string]string) string {
+	keys := make([]string, 0, len(baggage))
+	for k := range baggage {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	items := make([]string, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, k+"="+url.QueryEscape(baggage[k]))
+	}
+	return strings.Join(items, ",")
+}
+
+// GetBaggage 获取上游传递的业务上下文
+func GetBaggage(ctx context.Context) map[string]string {
+	return ParseBaggage(GetHeaders(ctx).Get(Baggage))
+}
+
+// GetBaggageItem 获取单个业务上下文
+func GetBaggageItem(ctx context.Context, key string) string {
+	return GetBaggage(ctx)[key]
+}
+
+// WithBaggage 添加业务上下文 向下游传递
+func WithBaggage(ctx context.Context, items map[string]string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	baggage := GetBaggage(ctx)
+	for k, v := range items {
+		baggage[k] = v
+	}
+	return AppendToHeaders(ctx, map[string]string{
+		Baggage: FormatBaggage(baggage),
+	})
+}
+
+// canonicalKey 统一header大小写 与http.Header一致
+func canonicalKey(key string) string {
+	return textproto.CanonicalMIMEHeaderKey(key)
+}
@@ -0,0 +1,70 @@
+package rpcheader
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestTrustPolicy_Default(t *testing.T) {
+	// 默认只信任本机 网关、负载均衡的内网地址需要配置
+	policy := GetTrustPolicy()
+	if !policy.IsTrustedAddr("127.0.0.1") || !policy.IsTrustedAddr("::1") {
+		t.Fatal("expected loopback trusted")
+	}
+	for _, ip := range []string{"10.1.2.3", "172.16.0.1", "192.168.1.1", "fd00::1"} {
+		if policy.IsTrustedAddr(ip) {
+			t.Fatalf("expected %s untrusted", ip)
+		}
+	}
+}
+
+func TestTrustPolicy_FilterHeaders(t *testing.T) {
+	policy := GetTrustPolicy()
+	defer SetTrustPolicy(*policy)
+	SetTrustPolicy(TrustPolicy{
+		TrustedCIDRs: []string{"10.0.0.0/8"},
+		Rewrites:     map[string]string{Source: "external"},
+		BaggageKeys:  []string{"tenant"},
+	})
+	policy = GetTrustPolicy()
+	if !policy.IsTrustedAddr("10.1.2.3") || policy.IsTrustedAddr("8.8.8.8") {
+		t.Fatal("unexpected trusted addr")
+	}
+	headers := Header{
+		TraceId:    "abc",
+		Source:     "admin",
+		ApiVersion: "gray",
+		"Z-Custom": "x",
+		Baggage:    "tenant=t1,role=admin",
+	}
+	ret := policy.FilterHeaders(headers, false)
+	if len(ret) != 3 || ret[TraceId] != "abc" || ret[Source] != "external" || ret[Baggage] != "tenant=t1" {
+		t.Fatalf("unexpected untrusted headers: %v", ret)
+	}
+	ret = policy.FilterHeaders(headers, true)
+	if len(ret) != 5 || ret[ApiVersion] != "gray" || ret[Baggage] != "role=admin,tenant=t1" {
+		t.Fatalf("unexpected trusted headers: %v", ret)
+	}
+}
+
+func TestTrustPolicy_BaggageLimit(t *testing.T) {
+	policy := GetTrustPolicy()
+	defer SetTrustPolicy(*policy)
+	SetTrustPolicy(TrustPolicy{
+		MaxBaggageSize:  32,
+		MaxBaggageItems: 2,
+	})
+	policy = GetTrustPolicy()
+	if ret := policy.FilterBaggage("a=1,b=2,c=3", true); ret != "a=1,b=2" {
+		t.Fatalf("unexpected baggage: %s", ret)
+	}
+	if ret := policy.FilterBaggage("a="+strings.Repeat("x", 40)+",b=2", true); ret != "b=2" {
+		t.Fatalf("unexpected baggage: %s", ret)
+	}
+	ctx := WithBaggage(context.Background(), map[string]string{"order": "a,b=c"})
+	ctx = WithBaggage(ctx, map[string]string{"tenant": "t1"})
+	if GetBaggageItem(ctx, "order") != "a,b=c" || GetBaggageItem(ctx, "tenant") != "t1" {
+		t.Fatalf("unexpected baggage: %v", GetBaggage(ctx))
+	}
+}
@@ -0,0 +1,62 @@
+package discovery
+
+import (
+	"context"
+	"github.com/LeeZXin/zsf/services/lb"
+	"net"
+	"strconv"
+)
+
+// hostsDiscovery 固定实例列表 不区分服务名
+type hostsDiscovery struct {
+	servers  []lb.Server
+	balancer lb.LoadBalancer
+}
+
+// NewHostsDiscovery 使用固定的host:port列表 通过lb负载均衡选择实例
+func NewHostsDiscovery(name string, hosts []string, lbPolicy lb.Policy) Discovery {
+	servers := make([]lb.Server, 0, len(hosts))
+	for _, host := range hosts {
+		h, p, err := net.SplitHostPort(host)
+		if err != nil {
+			continue
+		}
+		port, err := strconv.Atoi(p)
+		if err != nil || h == "" {
+			continue
+		}
+		servers = append(servers, lb.Server{
+			Name:   name,
+			Host:   h,
+			Port:   port,
+			Weight: 1,
+		})
+	}
+	balancer := &lb.NearbyLoadBalancer{
+		LbPolicy: lbPolicy,
+	}
+	balancer.SetServers(servers)
+	return &hostsDiscovery{
+		servers:  servers,
+		balancer: balancer,
+	}
+}
+
+func (d *hostsDiscovery) Discover(context.Context, string) ([]lb.Server, error) {
+	if len(d.servers) == 0 {
+		return nil, lb.ServerNotFound
+	}
+	return d.servers, nil
+}
+
+func (d *hostsDiscovery) DiscoverWithZone(ctx context.Context, _ string, name string) ([]lb.Server, error) {
+	return d.Discover(ctx, name)
+}
+
+func (d *hostsDiscovery) ChooseServer(ctx context.Context, _ string) (lb.Server, error) {
+	return d.balancer.ChooseServer(ctx)
+}
+
+func (d *hostsDiscovery) ChooseServerWithZone(ctx context.Context, _ string, name string) (lb.Server, error) {
+	return d.ChooseServer(ctx, name)
+}
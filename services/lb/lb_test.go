@@ -2,8 +2,11 @@ package lb
 
 import (
 	"fmt"
+	"github.com/LeeZXin/zsf/logger/loggertest"
 	"github.com/LeeZXin/zsf/rpcheader"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestRoundRobinLoadBalancer_ChooseServer(t *testing.T) {
@@ -24,6 +27,33 @@ func TestRoundRobinLoadBalancer_ChooseServer(t *testing.T) {
 	}
 }
 
+func TestRoundRobinLoadBalancer_Concurrent(t *testing.T) {
+	lb := new(roundRobinLoadBalancer)
+	lb.SetServers([]Server{{Name: "1"}, {Name: "2"}, {Name: "3"}})
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		counts = make(map[string]int)
+	)
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				server, _ := lb.ChooseServer(nil)
+				mu.Lock()
+				counts[server.Name]++
+				mu.Unlock()
+			}
+			lb.SetServers(lb.GetServers())
+		}()
+	}
+	wg.Wait()
+	if len(counts) != 3 {
+		t.Fatalf("unexpected counts: %v", counts)
+	}
+}
+
 func TestWeightedRoundRobinLoadBalancer_ChooseServer(t *testing.T) {
 	lb := new(weightRoundRobinLoadBalancer)
 	lb.SetServers([]Server{
@@ -132,3 +162,76 @@ func TestNearbyRoundRobinLoadBalancer_ChooseServer(t *testing.T) {
 		fmt.Println(lb.ChooseServer(ctx))
 	}
 }
+
+func TestOutlierDetector(t *testing.T) {
+	loggertest.NewHook(t)
+	detector := NewOutlierDetector(OutlierConfig{
+		ConsecutiveFailures: 3,
+		BaseEjectionTime:    50 * time.Millisecond,
+		MaxEjectionPercent:  50,
+	})
+	servers := []Server{
+		{Name: "svc", Host: "127.0.0.1", Port: 1},
+		{Name: "svc", Host: "127.0.0.1", Port: 2},
+		{Name: "svc", Host: "127.0.0.1", Port: 3},
+		{Name: "svc", Host: "127.0.0.1", Port: 4},
+	}
+	lb := &NearbyLoadBalancer{
+		LbPolicy: RoundRobin,
+		Outlier:  detector,
+	}
+	lb.SetServers(servers)
+	for i := 0; i < 3; i++ {
+		detector.ReportFailure(servers[0])
+	}
+	if !detector.IsEjected(servers[0]) {
+		t.Fatal("server should be ejected")
+	}
+	// 摘除前已发出的请求成功不恢复实例
+	detector.ReportSuccess(servers[0])
+	if !detector.IsEjected(servers[0]) {
+		t.Fatal("in-flight success should not recover server")
+	}
+	for i := 0; i < 20; i++ {
+		server, err := lb.ChooseServer(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if server.Port == 1 {
+			t.Fatal("ejected server should not be chosen")
+		}
+	}
+	// 最多摘除50%
+	for _, server := range servers[1:] {
+		for i := 0; i < 3; i++ {
+			detector.ReportFailure(server)
+		}
+	}
+	ejected := 0
+	for _, server := range servers {
+		if detector.IsEjected(server) {
+			ejected++
+		}
+	}
+	if ejected != 2 {
+		t.Fatalf("unexpected ejected count: %d", ejected)
+	}
+	// 半开探测 只放行一个请求
+	time.Sleep(60 * time.Millisecond)
+	if !detector.Allow(servers[0]) || detector.Allow(servers[0]) {
+		t.Fatal("half open should allow exactly one probe")
+	}
+	detector.ReportFailure(servers[0])
+	time.Sleep(60 * time.Millisecond)
+	if detector.Allow(servers[0]) {
+		t.Fatal("ejection time should be doubled after probe failure")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if !detector.Allow(servers[0]) {
+		t.Fatal("probe should be allowed")
+	}
+	detector.ReportSuccess(servers[0])
+	if detector.IsEjected(servers[0]) {
+		t.Fatal("server should be recovered")
+	}
+}
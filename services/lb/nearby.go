@@ -24,6 +24,8 @@ type NearbyLoadBalancer struct {
 	LbPolicy   Policy
 	lb         nearbyLb
 	smu        sync.RWMutex
+	// Outlier 异常实例检测 为空时使用全局检测器
+	Outlier *OutlierDetector
 }
 
 func (r *NearbyLoadBalancer) SetServers(servers []Server) {
@@ -34,6 +36,14 @@ func (r *NearbyLoadBalancer) SetServers(servers []Server) {
 	defer r.smu.Unlock()
 	r.allServers = servers
 	r.lb = r.initNearbyLb(servers)
+	r.outlier().updateServers(servers)
+}
+
+func (r *NearbyLoadBalancer) outlier() *OutlierDetector {
+	if r.Outlier != nil {
+		return r.Outlier
+	}
+	return DefaultOutlierDetector()
 }
 
 func (r *NearbyLoadBalancer) GetServers() []Server {
@@ -110,12 +120,31 @@ func (r *NearbyLoadBalancer) ChooseServer(ctx context.Context) (Server, error) {
 	region := common.GetRegion()
 	rlb, b := r.lb.rlb[region]
 	if !b {
-		return r.lb.alb.ChooseServer(ctx)
+		return r.chooseAllowed(ctx, r.lb.alb)
 	}
 	zone := common.GetZone()
 	lb, b := rlb.zlb[zone]
 	if !b {
-		return rlb.alb.ChooseServer(ctx)
+		return r.chooseAllowed(ctx, rlb.alb)
+	}
+	return r.chooseAllowed(ctx, lb)
+}
+
+// chooseAllowed 跳过被摘除的实例 全部被摘除时返回首次选择的实例
+func (r *NearbyLoadBalancer) chooseAllowed(ctx context.Context, balancer LoadBalancer) (Server, error) {
+	outlier := r.outlier()
+	first, err := balancer.ChooseServer(ctx)
+	if err != nil || outlier.Allow(first) {
+		return first, err
+	}
+	for i := 1; i < len(balancer.GetServers()); i++ {
+		server, err := balancer.ChooseServer(ctx)
+		if err != nil {
+			break
+		}
+		if outlier.Allow(server) {
+			return server, nil
+		}
 	}
-	return lb.ChooseServer(ctx)
+	return first, nil
 }
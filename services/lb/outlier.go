@@ -0,0 +1,279 @@
+package lb
+
+import (
+	"fmt"
+	"github.com/LeeZXin/zsf/logger"
+	"github.com/LeeZXin/zsf/prom"
+	"github.com/LeeZXin/zsf/property/static"
+	"sync"
+	"time"
+)
+
+// 被动异常实例摘除
+// 调用方上报请求结果 连续失败达到阈值后将实例摘除一段时间
+// 摘除时间到期后进入半开状态 放行一个探测请求 成功则恢复 失败则加倍摘除时间
+
+const (
+	DefaultConsecutiveFailures = 5
+	DefaultBaseEjectionTime    = 30 * time.Second
+	DefaultMaxEjectionTime     = 5 * time.Minute
+	DefaultMaxEjectionPercent  = 50
+)
+
+var (
+	defaultOutlier     *OutlierDetector
+	defaultOutlierOnce sync.Once
+)
+
+// DefaultOutlierDetector 全局异常实例检测器
+// 通过discovery.outlier配置 discovery.outlier.enabled=false时关闭
+func DefaultOutlierDetector() *OutlierDetector {
+	defaultOutlierOnce.Do(func() {
+		if static.Exists("discovery.outlier.enabled") && !static.GetBool("discovery.outlier.enabled") {
+			return
+		}
+		defaultOutlier = NewOutlierDetector(OutlierConfig{
+			ConsecutiveFailures: static.GetInt("discovery.outlier.consecutiveFailures"),
+			BaseEjectionTime:    time.Duration(static.GetInt("discovery.outlier.baseEjectionSec")) * time.Second,
+			MaxEjectionTime:     time.Duration(static.GetInt("discovery.outlier.maxEjectionSec")) * time.Second,
+			MaxEjectionPercent:  static.GetInt("discovery.outlier.maxEjectionPercent"),
+		})
+	})
+	return defaultOutlier
+}
+
+type OutlierConfig struct {
+	// ConsecutiveFailures 连续失败次数阈值
+	ConsecutiveFailures int
+	// BaseEjectionTime 首次摘除时间 每次连续摘除加倍
+	BaseEjectionTime time.Duration
+	// MaxEjectionTime 最大摘除时间
+	MaxEjectionTime time.Duration
+	// MaxEjectionPercent 同一服务最多摘除实例百分比
+	MaxEjectionPercent int
+}
+
+type outlierHost struct {
+	name      string
+	addr      string
+	failures  int
+	ejections int
+	ejected   bool
+	// ejectedAt 最近一次摘除时间
+	ejectedAt time.Time
+	// until 摘除到期时间
+	until time.Time
+	// probing 半开状态下已放行探测请求
+	probing   bool
+	probingAt time.Time
+}
+
+// OutlierDetector 异常实例检测器 并发安全 nil时不做任何检测
+type OutlierDetector struct {
+	config OutlierConfig
+	mu     sync.Mutex
+	hosts  map[string]*outlierHost
+	// services 服务名 -> 注册实例地址 用于计算摘除比例
+	services map[string]map[string]struct{}
+}
+
+func NewOutlierDetector(config OutlierConfig) *OutlierDetector {
+	if config.ConsecutiveFailures <= 0 {
+		config.ConsecutiveFailures = DefaultConsecutiveFailures
+	}
+	if config.BaseEjectionTime <= 0 {
+		config.BaseEjectionTime = DefaultBaseEjectionTime
+	}
+	if config.MaxEjectionTime < config.BaseEjectionTime {
+		config.MaxEjectionTime = DefaultMaxEjectionTime
+		if config.MaxEjectionTime < config.BaseEjectionTime {
+			config.MaxEjectionTime = config.BaseEjectionTime
+		}
+	}
+	if config.MaxEjectionPercent <= 0 || config.MaxEjectionPercent > 100 {
+		config.MaxEjectionPercent = DefaultMaxEjectionPercent
+	}
+	return &OutlierDetector{
+		config:   config,
+		hosts:    make(map[string]*outlierHost, 8),
+		services: make(map[string]map[string]struct{}, 8),
+	}
+}
+
+// Report 上报请求结果 failed包括请求错误、超时和5xx
+func (d *OutlierDetector) Report(server Server, failed bool) {
+	if failed {
+		d.ReportFailure(server)
+	} else {
+		d.ReportSuccess(server)
+	}
+}
+
+func (d *OutlierDetector) ReportSuccess(server Server) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	host, b := d.hosts[hostKey(server)]
+	if !b {
+		return
+	}
+	if host.ejected {
+		// 摘除前已发出的请求成功不恢复 只有半开探测成功才恢复
+		if !host.probing {
+			return
+		}
+		host.failures = 0
+		host.ejected = false
+		host.probing = false
+		prom.LbOutlierEjectedHosts.WithLabelValues(host.name).Dec()
+		logger.Logger.Infof("outlier instance recovered target: %s, instance: %s", host.name, host.addr)
+		return
+	}
+	host.failures = 0
+	if host.ejections > 0 && time.Since(host.ejectedAt) > d.config.MaxEjectionTime {
+		host.ejections = 0
+	}
+}
+
+func (d *OutlierDetector) ReportFailure(server Server) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := hostKey(server)
+	host, b := d.hosts[key]
+	if !b {
+		host = &outlierHost{
+			name: server.Name,
+			addr: serverAddr(server),
+		}
+		d.hosts[key] = host
+	}
+	if host.ejected {
+		// 半开探测失败 重新摘除
+		if host.probing {
+			host.probing = false
+			d.eject(host)
+		}
+		return
+	}
+	host.failures++
+	if host.failures >= d.config.ConsecutiveFailures && d.canEject(host.name) {
+		d.eject(host)
+	}
+}
+
+// Allow 实例是否可被选择 半开状态下只放行一个探测请求
+func (d *OutlierDetector) Allow(server Server) bool {
+	if d == nil {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	host, b := d.hosts[hostKey(server)]
+	if !b || !host.ejected {
+		return true
+	}
+	now := time.Now()
+	if now.Before(host.until) {
+		return false
+	}
+	// 探测请求未上报结果时 超过首次摘除时间重新放行
+	if host.probing && now.Sub(host.probingAt) < d.config.BaseEjectionTime {
+		return false
+	}
+	host.probing = true
+	host.probingAt = now
+	return true
+}
+
+// IsEjected 实例是否处于摘除状态
+func (d *OutlierDetector) IsEjected(server Server) bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	host, b := d.hosts[hostKey(server)]
+	return b && host.ejected
+}
+
+// updateServers 更新服务实例列表 清理已下线实例状态
+func (d *OutlierDetector) updateServers(servers []Server) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	groups := make(map[string]map[string]struct{}, 1)
+	for _, server := range servers {
+		addrs, b := groups[server.Name]
+		if !b {
+			addrs = make(map[string]struct{}, len(servers))
+			groups[server.Name] = addrs
+		}
+		addrs[serverAddr(server)] = struct{}{}
+	}
+	for name, addrs := range groups {
+		d.services[name] = addrs
+		for key, host := range d.hosts {
+			if host.name != name {
+				continue
+			}
+			if _, b := addrs[host.addr]; !b {
+				if host.ejected {
+					prom.LbOutlierEjectedHosts.WithLabelValues(host.name).Dec()
+				}
+				delete(d.hosts, key)
+			}
+		}
+	}
+}
+
+func (d *OutlierDetector) canEject(name string) bool {
+	total := len(d.services[name])
+	ejected := 0
+	known := 0
+	for _, host := range d.hosts {
+		if host.name != name {
+			continue
+		}
+		known++
+		if host.ejected {
+			ejected++
+		}
+	}
+	if known > total {
+		total = known
+	}
+	return ejected*100 < d.config.MaxEjectionPercent*total
+}
+
+func (d *OutlierDetector) eject(host *outlierHost) {
+	ejectionTime := d.config.BaseEjectionTime << host.ejections
+	if ejectionTime <= 0 || ejectionTime > d.config.MaxEjectionTime {
+		ejectionTime = d.config.MaxEjectionTime
+	} else {
+		host.ejections++
+	}
+	host.failures = 0
+	if !host.ejected {
+		host.ejected = true
+		prom.LbOutlierEjectedHosts.WithLabelValues(host.name).Inc()
+	}
+	host.ejectedAt = time.Now()
+	host.until = host.ejectedAt.Add(ejectionTime)
+	prom.LbOutlierEjectionTotal.WithLabelValues(host.name, host.addr).Inc()
+	logger.Logger.Warnf("outlier instance ejected target: %s, instance: %s, duration: %v", host.name, host.addr, ejectionTime)
+}
+
+func hostKey(server Server) string {
+	return server.Name + "#" + serverAddr(server)
+}
+
+func serverAddr(server Server) string {
+	return fmt.Sprintf("%s:%d", server.Host, server.Port)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"github.com/LeeZXin/zsf-utils/listutil"
+	"sync"
 )
 
 var (
@@ -11,6 +12,7 @@ var (
 )
 
 type roundRobinLoadBalancer struct {
+	sync.Mutex
 	allServers []Server
 	incr       uint64
 }
@@ -19,15 +21,21 @@ func (r *roundRobinLoadBalancer) SetServers(servers []Server) {
 	if len(servers) == 0 {
 		return
 	}
+	r.Lock()
+	defer r.Unlock()
 	r.allServers = listutil.Shuffle(servers)
 	r.incr = 0
 }
 
 func (r *roundRobinLoadBalancer) GetServers() []Server {
+	r.Lock()
+	defer r.Unlock()
 	return r.allServers
 }
 
 func (r *roundRobinLoadBalancer) ChooseServer(_ context.Context) (Server, error) {
+	r.Lock()
+	defer r.Unlock()
 	if len(r.allServers) == 0 {
 		return Server{}, ServerNotFound
 	}
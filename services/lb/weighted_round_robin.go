@@ -2,9 +2,11 @@ package lb
 
 import (
 	"context"
+	"sync"
 )
 
 type weightRoundRobinLoadBalancer struct {
+	sync.Mutex
 	allServers []Server
 	maxWeight  int
 	current    int
@@ -16,6 +18,8 @@ func (w *weightRoundRobinLoadBalancer) SetServers(servers []Server) {
 	if len(servers) == 0 {
 		return
 	}
+	w.Lock()
+	defer w.Unlock()
 	w.allServers = servers
 	weights := make([]int, len(w.allServers))
 	for i := range w.allServers {
@@ -33,10 +37,14 @@ func (w *weightRoundRobinLoadBalancer) SetServers(servers []Server) {
 }
 
 func (w *weightRoundRobinLoadBalancer) GetServers() []Server {
+	w.Lock()
+	defer w.Unlock()
 	return w.allServers
 }
 
 func (w *weightRoundRobinLoadBalancer) ChooseServer(_ context.Context) (Server, error) {
+	w.Lock()
+	defer w.Unlock()
 	if len(w.allServers) == 0 {
 		return Server{}, ServerNotFound
 	}
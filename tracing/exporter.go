@@ -0,0 +1,400 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/LeeZXin/zsf-utils/quit"
+	"github.com/LeeZXin/zsf/common"
+	"github.com/LeeZXin/zsf/logger"
+	"github.com/LeeZXin/zsf/property/static"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// span批量导出
+// tracing.enabled开启 tracing.endpoint为collector地址
+// tracing.format为otlp时使用OTLP/HTTP json格式 如http://collector:4318/v1/traces
+// 为zipkin时使用Zipkin v2 json格式 如http://zipkin:9411/api/v2/spans
+// 队列满时丢弃span
+
+const (
+	OtlpFormat   = "otlp"
+	ZipkinFormat = "zipkin"
+
+	defaultQueueSize     = 4096
+	defaultBatchSize     = 512
+	defaultFlushInterval = 5 * time.Second
+)
+
+var (
+	currentExporter atomic.Pointer[Exporter]
+)
+
+type ExporterConfig struct {
+	// Endpoint collector地址
+	Endpoint string
+	// Format otlp或zipkin 默认otlp
+	Format        string
+	ServiceName   string
+	QueueSize     int
+	BatchSize     int
+	FlushInterval time.Duration
+	Timeout       time.Duration
+	// Headers 请求头 如鉴权信息
+	Headers map[string]string
+}
+
+type Exporter struct {
+	cfg       ExporterConfig
+	queue     chan *Span
+	client    *http.Client
+	flushC    chan chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func initExporter() {
+	if !static.GetBool("tracing.enabled") {
+		return
+	}
+	endpoint := static.GetString("tracing.endpoint")
+	if endpoint == "" {
+		logger.Logger.Error("tracing.endpoint is empty")
+		return
+	}
+	exporter := NewExporter(ExporterConfig{
+		Endpoint:      endpoint,
+		Format:        static.GetString("tracing.format"),
+		QueueSize:     static.GetInt("tracing.queueSize"),
+		BatchSize:     static.GetInt("tracing.batchSize"),
+		FlushInterval: time.Duration(static.GetInt("tracing.flushIntervalMs")) * time.Millisecond,
+		Timeout:       time.Duration(static.GetInt("tracing.timeoutMs")) * time.Millisecond,
+		Headers:       static.GetStringMapString("tracing.headers"),
+	})
+	SetExporter(exporter)
+	quit.AddShutdownHook(exporter.Close)
+}
+
+// SetExporter 设置导出器 为nil时关闭链路追踪
+func SetExporter(exporter *Exporter) {
+	currentExporter.Store(exporter)
+}
+
+// Enabled 是否开启链路追踪
+func Enabled() bool {
+	return currentExporter.Load() != nil
+}
+
+func export(span *Span) {
+	if exporter := currentExporter.Load(); exporter != nil {
+		exporter.Export(span)
+	}
+}
+
+func NewExporter(cfg ExporterConfig) *Exporter {
+	if cfg.Format == "" {
+		cfg.Format = OtlpFormat
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = common.GetApplicationName()
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultFlushInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	ret := &Exporter{
+		cfg:   cfg,
+		queue: make(chan *Span, cfg.QueueSize),
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+		},
+		flushC: make(chan chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go ret.run()
+	return ret
+}
+
+// Export 加入导出队列 队列满时丢弃
+func (e *Exporter) Export(span *Span) {
+	select {
+	case e.queue <- span:
+	default:
+	}
+}
+
+// Flush 导出队列中的span
+func (e *Exporter) Flush() {
+	ch := make(chan struct{})
+	select {
+	case e.flushC <- ch:
+		<-ch
+	case <-e.done:
+	}
+}
+
+// Close 导出剩余span并停止
+func (e *Exporter) Close() {
+	e.closeOnce.Do(func() {
+		e.Flush()
+		close(e.done)
+	})
+}
+
+func (e *Exporter) run() {
+	ticker := time.NewTicker(e.cfg.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, e.cfg.BatchSize)
+	send := func() {
+		if len(batch) > 0 {
+			e.send(batch)
+			batch = make([]*Span, 0, e.cfg.BatchSize)
+		}
+	}
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= e.cfg.BatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case ch := <-e.flushC:
+			for n := len(e.queue); n > 0; n-- {
+				batch = append(batch, <-e.queue)
+				if len(batch) >= e.cfg.BatchSize {
+					send()
+				}
+			}
+			send()
+			close(ch)
+		case <-e.done:
+			return
+		}
+	}
+}
+
+func (e *Exporter) send(spans []*Span) {
+	var (
+		body []byte
+		err  error
+	)
+	if e.cfg.Format == ZipkinFormat {
+		body, err = json.Marshal(encodeZipkin(e.cfg.ServiceName, spans))
+	} else {
+		body, err = json.Marshal(encodeOtlp(e.cfg.ServiceName, spans))
+	}
+	if err != nil {
+		logger.Logger.Errorf("tracing encode spans failed with err: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.Timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		logger.Logger.Errorf("tracing export spans failed with err: %v", err)
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for k, v := range e.cfg.Headers {
+		request.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(request)
+	if err != nil {
+		logger.Logger.Errorf("tracing export spans failed with err: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		logger.Logger.Errorf("tracing export spans failed with code: %d", resp.StatusCode)
+	}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	TraceState        string          `json:"traceState,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+func otlpAttributes(attrs map[string]string) []otlpAttribute {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	ret := make([]otlpAttribute, 0, len(keys))
+	for _, k := range keys {
+		ret = append(ret, otlpAttribute{
+			Key:   k,
+			Value: otlpValue{StringValue: attrs[k]},
+		})
+	}
+	return ret
+}
+
+// encodeOtlp OTLP/HTTP json traceId和spanId使用16进制字符串
+func encodeOtlp(serviceName string, spans []*Span) otlpRequest {
+	var resourceSpans otlpResourceSpans
+	resourceSpans.Resource.Attributes = otlpAttributes(map[string]string{
+		"service.name":        serviceName,
+		"service.instance.id": common.GetInstanceId(),
+		"host.ip":             common.GetLocalIP(),
+	})
+	var scopeSpans otlpScopeSpans
+	scopeSpans.Scope.Name = "zsf"
+	scopeSpans.Spans = make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		status := otlpStatus{}
+		if msg := span.Error(); msg != "" {
+			status = otlpStatus{Code: 2, Message: msg}
+		}
+		scopeSpans.Spans = append(scopeSpans.Spans, otlpSpan{
+			TraceId:           span.TraceId,
+			SpanId:            span.SpanId,
+			ParentSpanId:      span.ParentSpanId,
+			TraceState:        span.TraceState,
+			Name:              span.Name,
+			Kind:              int(span.Kind),
+			StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes()),
+			Status:            status,
+		})
+	}
+	resourceSpans.ScopeSpans = []otlpScopeSpans{scopeSpans}
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{resourceSpans},
+	}
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName,omitempty"`
+	Ipv4        string `json:"ipv4,omitempty"`
+}
+
+type zipkinSpan struct {
+	TraceId       string            `json:"traceId"`
+	Id            string            `json:"id"`
+	ParentId      string            `json:"parentId,omitempty"`
+	Name          string            `json:"name"`
+	Kind          string            `json:"kind,omitempty"`
+	Timestamp     int64             `json:"timestamp"`
+	Duration      int64             `json:"duration"`
+	LocalEndpoint zipkinEndpoint    `json:"localEndpoint"`
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+func zipkinKind(kind SpanKind) string {
+	switch kind {
+	case ServerKind:
+		return "SERVER"
+	case ClientKind:
+		return "CLIENT"
+	case ProducerKind:
+		return "PRODUCER"
+	case ConsumerKind:
+		return "CONSUMER"
+	default:
+		return ""
+	}
+}
+
+// encodeZipkin Zipkin v2 json 时间单位微秒
+func encodeZipkin(serviceName string, spans []*Span) []zipkinSpan {
+	ret := make([]zipkinSpan, 0, len(spans))
+	for _, span := range spans {
+		tags := span.Attributes()
+		if msg := span.Error(); msg != "" {
+			tags["error"] = msg
+		}
+		duration := span.EndTime.Sub(span.StartTime).Microseconds()
+		if duration <= 0 {
+			duration = 1
+		}
+		ret = append(ret, zipkinSpan{
+			TraceId:   span.TraceId,
+			Id:        span.SpanId,
+			ParentId:  span.ParentSpanId,
+			Name:      span.Name,
+			Kind:      zipkinKind(span.Kind),
+			Timestamp: span.StartTime.UnixMicro(),
+			Duration:  duration,
+			LocalEndpoint: zipkinEndpoint{
+				ServiceName: serviceName,
+				Ipv4:        common.GetLocalIP(),
+			},
+			Tags: tags,
+		})
+	}
+	return ret
+}
+
+func (k SpanKind) String() string {
+	switch k {
+	case ServerKind:
+		return "server"
+	case ClientKind:
+		return "client"
+	case ProducerKind:
+		return "producer"
+	case ConsumerKind:
+		return "consumer"
+	default:
+		return "internal"
+	}
+}
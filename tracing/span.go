@@ -0,0 +1,246 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/LeeZXin/zsf/logger"
+	"github.com/LeeZXin/zsf/property/dynamic"
+	"github.com/LeeZXin/zsf/property/static"
+	"github.com/LeeZXin/zsf/rpcheader"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 链路追踪
+// span的traceId、spanId来自rpcheader.SpanContext 入口处的span使用rpcheader.StartSpan创建的上下文
+// 采样 有上游调用链时跟随上游的采样标记 否则按span名称匹配的采样率根据traceId决定
+// 采样规则通过动态配置tracing.json修改 {"sampleRate":0.1,"rules":[{"prefix":"GET /health","sampleRate":0}]}
+
+type SpanKind int
+
+const (
+	InternalKind SpanKind = iota + 1
+	ServerKind
+	ClientKind
+	ProducerKind
+	ConsumerKind
+)
+
+const (
+	defaultDynamicKey = "tracing.json"
+)
+
+var (
+	sampler atomic.Pointer[SamplerConfig]
+)
+
+type SampleRule struct {
+	// Prefix span名称前缀
+	Prefix     string  `json:"prefix"`
+	SampleRate float64 `json:"sampleRate"`
+}
+
+type SamplerConfig struct {
+	// SampleRate 默认采样率 0到1
+	SampleRate float64      `json:"sampleRate"`
+	Rules      []SampleRule `json:"rules"`
+}
+
+// rate 按前缀匹配规则 最长前缀优先
+func (c *SamplerConfig) rate(name string) float64 {
+	ret, matched := c.SampleRate, -1
+	for _, rule := range c.Rules {
+		if strings.HasPrefix(name, rule.Prefix) && len(rule.Prefix) > matched {
+			ret, matched = rule.SampleRate, len(rule.Prefix)
+		}
+	}
+	return ret
+}
+
+func init() {
+	cfg := SamplerConfig{
+		SampleRate: 1,
+	}
+	if static.Exists("tracing.sampleRate") {
+		cfg.SampleRate = static.GetFloat64("tracing.sampleRate")
+	}
+	SetSampler(cfg)
+	key := static.GetString("tracing.dynamicKey")
+	if key == "" {
+		key = defaultDynamicKey
+	}
+	content, b := dynamic.GetRawContent(key)
+	if b {
+		loadSampler(content.Content)
+	}
+	dynamic.RegisterListener(key, func(eventType dynamic.EventType, content dynamic.Content) {
+		switch eventType {
+		case dynamic.PutEventType:
+			loadSampler(content.Content)
+		case dynamic.DeleteEventType:
+			SetSampler(cfg)
+		}
+	})
+	initExporter()
+}
+
+func loadSampler(content string) {
+	var cfg SamplerConfig
+	if err := json.Unmarshal([]byte(content), &cfg); err != nil {
+		logger.Logger.Errorf("tracing read dynamic config failed with err: %v", err)
+		return
+	}
+	SetSampler(cfg)
+	logger.Logger.Infof("tracing sampler refreshed, sampleRate: %v, rules: %v", cfg.SampleRate, cfg.Rules)
+}
+
+// SetSampler 替换采样规则
+func SetSampler(cfg SamplerConfig) {
+	sampler.Store(&cfg)
+}
+
+// shouldSample 根据traceId的低8字节决定 同一条链路在各服务的结果一致
+func shouldSample(traceId, name string) bool {
+	rate := sampler.Load().rate(name)
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	b, err := hex.DecodeString(traceId[16:])
+	if err != nil {
+		return false
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return float64(v>>11)/float64(1<<53) < rate
+}
+
+type Span struct {
+	rpcheader.SpanContext
+	Name      string
+	Kind      SpanKind
+	StartTime time.Time
+	EndTime   time.Time
+	mu        sync.Mutex
+	attrs     map[string]string
+	err       string
+	ended     bool
+}
+
+// SetAttribute 设置属性 span为nil时忽略
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs[key] = value
+}
+
+// SetError 标记span失败
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt 结束span并加入导出队列
+func (s *Span) EndAt(t time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = t
+	s.mu.Unlock()
+	export(s)
+}
+
+// Attributes 属性副本
+func (s *Span) Attributes() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make(map[string]string, len(s.attrs))
+	for k, v := range s.attrs {
+		ret[k] = v
+	}
+	return ret
+}
+
+func (s *Span) Error() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func newSpan(sc rpcheader.SpanContext, name string, kind SpanKind, start time.Time) *Span {
+	if !sc.Sampled {
+		return nil
+	}
+	return &Span{
+		SpanContext: sc,
+		Name:        name,
+		Kind:        kind,
+		StartTime:   start,
+		attrs:       make(map[string]string, 8),
+	}
+}
+
+// StartCurrentSpan 记录ctx中的span 用于入口处rpcheader.StartSpan之后
+// 没有上游调用链时在这里决定是否采样 并更新ctx中的采样标记
+func StartCurrentSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if !Enabled() {
+		return ctx, nil
+	}
+	sc, b := rpcheader.GetSpanContext(ctx)
+	if !b {
+		return StartSpan(ctx, name, kind)
+	}
+	if sc.ParentSpanId == "" && sc.Sampled {
+		sc.Sampled = shouldSample(sc.TraceId, name)
+		ctx = rpcheader.WithSpanContext(ctx, sc)
+	}
+	return ctx, newSpan(sc, name, kind, time.Now())
+}
+
+// StartSpan 创建子span 返回的ctx向下游传递子span
+func StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	return StartSpanAt(ctx, name, kind, time.Now())
+}
+
+// StartSpanAt 指定开始时间创建子span
+func StartSpanAt(ctx context.Context, name string, kind SpanKind, start time.Time) (context.Context, *Span) {
+	if !Enabled() {
+		return ctx, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	parent, b := rpcheader.GetSpanContext(ctx)
+	var sc rpcheader.SpanContext
+	if b {
+		sc = parent.Child()
+	} else {
+		sc = rpcheader.NewRootSpanContext(strings.ReplaceAll(rpcheader.GetHeaders(ctx).Get(rpcheader.TraceId), "-", ""))
+		sc.Sampled = shouldSample(sc.TraceId, name)
+	}
+	return rpcheader.WithSpanContext(ctx, sc), newSpan(sc, name, kind, start)
+}
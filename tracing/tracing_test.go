@@ -0,0 +1,134 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/LeeZXin/zsf/rpcheader"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type collector struct {
+	sync.Mutex
+	bodies [][]byte
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	c.Lock()
+	c.bodies = append(c.bodies, body)
+	c.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *collector) last() []byte {
+	c.Lock()
+	defer c.Unlock()
+	if len(c.bodies) == 0 {
+		return nil
+	}
+	return c.bodies[len(c.bodies)-1]
+}
+
+func startTestExporter(t *testing.T, format string) *collector {
+	c := &collector{}
+	server := httptest.NewServer(c)
+	exporter := NewExporter(ExporterConfig{
+		Endpoint:      server.URL,
+		Format:        format,
+		ServiceName:   "test-service",
+		FlushInterval: time.Hour,
+	})
+	SetExporter(exporter)
+	t.Cleanup(func() {
+		SetExporter(nil)
+		exporter.Close()
+		server.Close()
+	})
+	return c
+}
+
+func TestExport_Otlp(t *testing.T) {
+	c := startTestExporter(t, OtlpFormat)
+	SetSampler(SamplerConfig{SampleRate: 1})
+	ctx := rpcheader.StartSpan(context.Background(), func(string) string { return "" })
+	ctx, server := StartCurrentSpan(ctx, "GET /user", ServerKind)
+	server.SetAttribute("http.route", "/user")
+	_, client := StartSpan(ctx, "GET /order", ClientKind)
+	client.SetError(errors.New("timeout"))
+	client.End()
+	server.End()
+	currentExporter.Load().Flush()
+	var req otlpRequest
+	if err := json.Unmarshal(c.last(), &req); err != nil {
+		t.Fatal(err)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Kind != int(ClientKind) || spans[0].Status.Code != 2 || spans[0].ParentSpanId != spans[1].SpanId {
+		t.Fatalf("unexpected client span: %+v", spans[0])
+	}
+	if spans[1].TraceId != spans[0].TraceId || spans[1].Attributes[0].Value.StringValue != "/user" {
+		t.Fatalf("unexpected server span: %+v", spans[1])
+	}
+}
+
+func TestExport_Zipkin(t *testing.T) {
+	c := startTestExporter(t, ZipkinFormat)
+	SetSampler(SamplerConfig{SampleRate: 1})
+	start := time.Now().Add(-10 * time.Millisecond)
+	_, span := StartSpanAt(context.Background(), "SQL", ClientKind, start)
+	span.SetError(errors.New("deadlock"))
+	span.EndAt(start.Add(10 * time.Millisecond))
+	currentExporter.Load().Flush()
+	var spans []zipkinSpan
+	if err := json.Unmarshal(c.last(), &spans); err != nil {
+		t.Fatal(err)
+	}
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	s := spans[0]
+	if s.Kind != "CLIENT" || s.Duration != 10000 || s.Tags["error"] != "deadlock" || s.LocalEndpoint.ServiceName != "test-service" {
+		t.Fatalf("unexpected span: %+v", s)
+	}
+}
+
+func TestSampler(t *testing.T) {
+	startTestExporter(t, OtlpFormat)
+	SetSampler(SamplerConfig{
+		SampleRate: 1,
+		Rules: []SampleRule{
+			{Prefix: "GET /health", SampleRate: 0},
+		},
+	})
+	ctx := rpcheader.StartSpan(context.Background(), func(string) string { return "" })
+	ctx, span := StartCurrentSpan(ctx, "GET /health", ServerKind)
+	if span != nil {
+		t.Fatal("expected span not sampled")
+	}
+	// 子span跟随采样标记
+	if _, child := StartSpan(ctx, "SQL", ClientKind); child != nil {
+		t.Fatal("expected child span not sampled")
+	}
+	if _, span = StartSpan(context.Background(), "GET /user", ServerKind); span == nil {
+		t.Fatal("expected span sampled")
+	}
+	// 上游已采样时忽略本地规则
+	ctx = rpcheader.StartSpan(context.Background(), func(key string) string {
+		if key == rpcheader.TraceParent {
+			return "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+		}
+		return ""
+	})
+	if _, span = StartCurrentSpan(ctx, "GET /health", ServerKind); span == nil {
+		t.Fatal("expected span sampled by upstream")
+	}
+}
@@ -2,10 +2,18 @@ package xormlog
 
 import (
 	"github.com/LeeZXin/zsf/logger"
+	"github.com/LeeZXin/zsf/tracing"
+	"regexp"
+	"strings"
 	"time"
 	"xorm.io/xorm/log"
 )
 
+var (
+	sqlLiteralRegexp = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|\b\d+(?:\.\d+)?\b`)
+	sqlInListRegexp  = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+)
+
 // XLogger 实现xorm sql的日志告警
 type XLogger struct {
 	log.DiscardLogger
@@ -28,6 +36,14 @@ func (x *XLogger) IsShowSQL() bool {
 func (x *XLogger) BeforeSQL(log.LogContext) {}
 
 func (x *XLogger) AfterSQL(ctx log.LogContext) {
+	if tracing.Enabled() {
+		now := time.Now()
+		_, span := tracing.StartSpanAt(ctx.Ctx, "SQL", tracing.ClientKind, now.Add(-ctx.ExecuteTime))
+		span.SetAttribute("db.system", "sql")
+		span.SetAttribute("db.statement", SqlFingerprint(ctx.SQL))
+		span.SetError(ctx.Err)
+		span.EndAt(now)
+	}
 	if x.showSql {
 		logger.Logger.WithContext(ctx.Ctx).Infof("[SQL] %s %v - %v", ctx.SQL, ctx.Args, ctx.ExecuteTime)
 	}
@@ -35,3 +51,10 @@ func (x *XLogger) AfterSQL(ctx log.LogContext) {
 		logger.Logger.WithContext(ctx.Ctx).Errorf("[SlowSQL] %s %v - %v", ctx.SQL, ctx.Args, ctx.ExecuteTime)
 	}
 }
+
+// SqlFingerprint sql指纹 去掉字面量和多余空白 in列表合并为一个占位符
+func SqlFingerprint(sql string) string {
+	sql = strings.Join(strings.Fields(sql), " ")
+	sql = sqlLiteralRegexp.ReplaceAllString(sql, "?")
+	return sqlInListRegexp.ReplaceAllString(sql, "(?)")
+}
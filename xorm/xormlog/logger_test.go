@@ -0,0 +1,16 @@
+package xormlog
+
+import "testing"
+
+func TestSqlFingerprint(t *testing.T) {
+	tests := map[string]string{
+		"SELECT * FROM user\n  WHERE id = 12 AND name = 'it''s'": "SELECT * FROM user WHERE id = ? AND name = ?",
+		"select * from t1 where id in (?, ?, ?)":                 "select * from t1 where id in (?)",
+		"update t set v = 1.5 where k in (1,2)":                  "update t set v = ? where k in (?)",
+	}
+	for sql, expected := range tests {
+		if ret := SqlFingerprint(sql); ret != expected {
+			t.Fatalf("expected %q, got %q", expected, ret)
+		}
+	}
+}